@@ -7,10 +7,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"time"
 
@@ -21,8 +23,12 @@ import (
 
 	"go.senan.xyz/gonic"
 	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/oidc"
 	"go.senan.xyz/gonic/paths"
+	"go.senan.xyz/gonic/scanner"
+	"go.senan.xyz/gonic/scanner/tags"
 	"go.senan.xyz/gonic/server"
+	"go.senan.xyz/gonic/server/ctrladmin"
 )
 
 const (
@@ -41,21 +47,53 @@ func main() {
 	confDBPath := set.String("db-path", "gonic.db", "path to database (optional)")
 	confScanIntervalMins := set.Int("scan-interval", 0, "interval (in minutes) to automatically scan music (optional)")
 	confScanAtStart := set.Bool("scan-at-start-enabled", false, "whether to perform an initial scan at startup (optional)")
+	confScanWorkers := set.Int("scan-workers", runtime.NumCPU(), "number of folders to read tags from in parallel while scanning (optional)")
+	confScanExclude := set.String("scan-exclude", "", "regular expression of paths relative to the music path to skip while scanning, eg. '@eaDir|\\.stfolder', or comma separated globs after 'glob:', eg. 'glob:@eaDir,*.m3u' (optional)")
+	confDiscFolders := set.String("disc-folders", "", "regular expression of folder names to fold into the album in the folder above as its discs, eg. '(?i)^(cd|dis[ck]) ?[0-9]+$' (optional)")
+	confTagReader := set.String("tag-reader", "taglib", "comma separated list of tag readers to try in order, from taglib and ffprobe. eg. 'taglib,ffprobe' to fall back to ffprobe for files taglib can't read (optional)")
 	confScanWatcher := set.Bool("scan-watcher-enabled", false, "whether to watch file system for new music and rescan (optional)")
 	confJukeboxEnabled := set.Bool("jukebox-enabled", false, "whether the subsonic jukebox api should be enabled (optional)")
 	confJukeboxMPVExtraArgs := set.String("jukebox-mpv-extra-args", "", "extra command line arguments to pass to the jukebox mpv daemon (optional)")
 	confPodcastPurgeAgeDays := set.Int("podcast-purge-age", 0, "age (in days) to purge podcast episodes if not accessed (optional)")
 	confProxyPrefix := set.String("proxy-prefix", "", "url path prefix to use if behind proxy. eg '/gonic' (optional)")
 	confGenreSplit := set.String("genre-split", "\n", "character or string to split genre tag data on (optional)")
+	confArtistSplit := set.String("artist-split", "", "character or string to split artist and album artist tag data on (optional)")
 	confHTTPLog := set.Bool("http-log", true, "http request logging (optional)")
+	confProxyAuthHeader := set.String("proxy-auth-header", "", "header with the name of a user the reverse proxy has already logged in to the web interface, eg. 'Remote-User'. only trusted from -trusted-proxies (optional)")
+	confTrustedProxies := set.String("trusted-proxies", "", "comma separated list of CIDRs of reverse proxies trusted to set X-Forwarded-For and the proxy auth header, eg. '127.0.0.1/32,172.16.0.0/12' (optional)")
+	confOIDCIssuer := set.String("oidc-issuer", "", "url of an OpenID Connect provider to log in to the web interface with, eg. 'https://auth.example.com' (optional)")
+	confOIDCClientID := set.String("oidc-client-id", "", "client id registered with the OpenID Connect provider (optional)")
+	confOIDCClientSecret := set.String("oidc-client-secret", "", "client secret registered with the OpenID Connect provider (optional)")
+	confOIDCUsernameClaim := set.String("oidc-username-claim", oidc.DefaultUsernameClaim, "id token claim with the username of users created by OpenID Connect (optional)")
+	confPasswordKey := set.String("password-key", "", "secret to encrypt users' passwords in the database with, instead of the one in -password-key-file (optional)")
+	confPasswordKeyFile := set.String("password-key-file", "", "path to a file with the secret to encrypt users' passwords in the database with, created with a random secret if it doesn't exist. it can't be in the db's directory, so that a leak or backup of one doesn't have the other")
+	confNewPasswordKey := set.String("new-password-key", "", "with the rotate-password-key command, the new secret to encrypt users' passwords with (optional)")
+	confNewPasswordKeyFile := set.String("new-password-key-file", "", "with the rotate-password-key command, path to a file with the new secret, created if it doesn't exist. without either, the secret in -password-key-file is replaced (optional)")
+	confAuthCreateUsers := set.Bool("auth-create-users", false, "whether to create users that log in with the proxy auth header or OpenID Connect but don't exist yet (optional)")
 	confShowVersion := set.Bool("version", false, "show gonic version")
+	confDryRun := set.Bool("dry-run", false, "with the scan command, report what a scan would change without changing anything (optional)")
+	confDryRunFormat := set.String("dry-run-format", "text", "with the scan command, format of the dry run report, text or json (optional)")
+	confFullScan := set.Bool("full", false, "with the scan command, read every track rather than only those changed since the last scan (optional)")
 
 	var confMusicPaths paths.MusicPaths
 	set.Var(&confMusicPaths, "music-path", "path to music")
 
 	_ = set.String("config-path", "", "path to config (optional)")
 
-	if err := ff.Parse(set, os.Args[1:],
+	// `gonic scan` scans once and exits, rather than starting the server. and
+	// `gonic rotate-password-key` encrypts users' passwords with a new key
+	args := os.Args[1:]
+	var scanCommand, rotateKeyCommand bool
+	if len(args) > 0 {
+		switch args[0] {
+		case "scan":
+			scanCommand, args = true, args[1:]
+		case "rotate-password-key":
+			rotateKeyCommand, args = true, args[1:]
+		}
+	}
+
+	if err := ff.Parse(set, args,
 		ff.WithConfigFileFlag("config-path"),
 		ff.WithConfigFileParser(ff.PlainParser),
 		ff.WithEnvVarPrefix(gonic.NameUpper),
@@ -72,6 +110,9 @@ func main() {
 	log.Printf("provided config\n")
 	set.VisitAll(func(f *flag.Flag) {
 		value := strings.ReplaceAll(f.Value.String(), "\n", "")
+		if (strings.Contains(f.Name, "secret") || strings.HasSuffix(f.Name, "password-key")) && value != "" {
+			value = "(hidden)"
+		}
 		log.Printf("    %-25s %s\n", f.Name, value)
 	})
 
@@ -83,6 +124,85 @@ func main() {
 			log.Fatalf("music directory %q not found", confMusicPath.Path)
 		}
 	}
+	passwordSecret := *confPasswordKey
+	var passwordKeyPath string
+	if passwordSecret == "" {
+		passwordKeyPath = *confPasswordKeyFile
+		if passwordKeyPath == "" {
+			log.Fatal("please provide a key file outside of the database's directory to encrypt passwords with, with -password-key-file, or a secret with -password-key")
+		}
+		if err := checkKeyPath(passwordKeyPath, *confDBPath); err != nil {
+			log.Fatalf("error checking password key file: %v\n", err)
+		}
+		var err error
+		if passwordSecret, err = readOrCreateSecret(passwordKeyPath); err != nil {
+			log.Fatalf("error reading password key: %v\n", err)
+		}
+	}
+	if *confNewPasswordKeyFile != "" {
+		if err := checkKeyPath(*confNewPasswordKeyFile, *confDBPath); err != nil {
+			log.Fatalf("error checking new password key file: %v\n", err)
+		}
+	}
+	passwordKey, err := db.NewPasswordKey(passwordSecret)
+	if err != nil {
+		log.Fatalf("error creating password key: %v\n", err)
+	}
+
+	dbc, err := db.New(*confDBPath, db.DefaultOptions())
+	if err != nil {
+		log.Fatalf("error opening database: %v\n", err)
+	}
+	defer dbc.Close()
+
+	migrationCtx := db.MigrationContext{
+		OriginalMusicPath: confMusicPaths[0].Path,
+		PasswordKey:       passwordKey,
+	}
+	// a dry run migrates a copy of the db instead
+	if !scanCommand || !*confDryRun {
+		if err := dbc.Migrate(migrationCtx); err != nil {
+			log.Panicf("error migrating database: %v\n", err)
+		}
+		if err := dbc.CheckPasswordKey(passwordKey); err != nil {
+			log.Fatalf("error checking password key, is it the one the passwords were encrypted with? %v\n", err)
+		}
+	}
+
+	if rotateKeyCommand {
+		if err := runRotatePasswordKey(dbc, passwordKey, passwordKeyPath, *confNewPasswordKey, *confNewPasswordKeyFile); err != nil {
+			log.Fatalf("error rotating password key: %v\n", err)
+		}
+		return
+	}
+
+	var scanExcludeExpr *regexp.Regexp
+	if *confScanExclude != "" {
+		if scanExcludeExpr, err = scanner.ParseExclude(*confScanExclude); err != nil {
+			log.Fatalf("error parsing scan exclude pattern: %v\n", err)
+		}
+	}
+
+	var discFoldersExpr *regexp.Regexp
+	if *confDiscFolders != "" {
+		if discFoldersExpr, err = regexp.Compile(*confDiscFolders); err != nil {
+			log.Fatalf("error parsing disc folders pattern: %v\n", err)
+		}
+	}
+
+	tagReader, err := tags.ReaderFromNames(strings.Split(*confTagReader, ","))
+	if err != nil {
+		log.Fatalf("error creating tag reader: %v\n", err)
+	}
+
+	if scanCommand {
+		s := scanner.New(confMusicPaths.Paths(), dbc, *confGenreSplit, *confArtistSplit, *confScanWorkers, scanExcludeExpr, discFoldersExpr, tagReader, &tags.FFmpegCoverReader{})
+		if err := runScan(s, scanner.ScanOptions{IsFull: *confFullScan}, *confDryRun, *confDryRunFormat, migrationCtx); err != nil {
+			log.Fatalf("error scanning: %v\n", err)
+		}
+		return
+	}
+
 	if _, err := os.Stat(*confPodcastPath); os.IsNotExist(err) {
 		log.Fatal("please provide a valid podcast directory")
 	}
@@ -104,17 +224,37 @@ func main() {
 		}
 	}
 
-	dbc, err := db.New(*confDBPath, db.DefaultOptions())
-	if err != nil {
-		log.Fatalf("error opening database: %v\n", err)
+	var trustedProxies []*net.IPNet
+	for _, cidr := range strings.Split(*confTrustedProxies, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, trusted, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("error parsing trusted proxy cidr: %v\n", err)
+		}
+		trustedProxies = append(trustedProxies, trusted)
 	}
-	defer dbc.Close()
 
-	err = dbc.Migrate(db.MigrationContext{
-		OriginalMusicPath: confMusicPaths[0].Path,
-	})
-	if err != nil {
-		log.Panicf("error migrating database: %v\n", err)
+	var proxyAuth *ctrladmin.ProxyAuth
+	if *confProxyAuthHeader != "" {
+		if len(trustedProxies) == 0 {
+			log.Fatal("please provide the reverse proxies trusted to set the proxy auth header with -trusted-proxies")
+		}
+		proxyAuth = &ctrladmin.ProxyAuth{Header: *confProxyAuthHeader}
+	}
+
+	var oidcProvider *oidc.Provider
+	if *confOIDCIssuer != "" {
+		if *confOIDCClientID == "" {
+			log.Fatal("please provide an OpenID Connect client id")
+		}
+		oidcProvider = oidc.New(oidc.Config{
+			Issuer:        *confOIDCIssuer,
+			ClientID:      *confOIDCClientID,
+			ClientSecret:  *confOIDCClientSecret,
+			UsernameClaim: *confOIDCUsernameClaim,
+		})
 	}
 
 	proxyPrefixExpr := regexp.MustCompile(`^\/*(.*?)\/*$`)
@@ -122,13 +262,24 @@ func main() {
 	server, err := server.New(server.Options{
 		DB:             dbc,
 		MusicPaths:     confMusicPaths,
+		PasswordKey:    passwordKey,
 		CachePath:      filepath.Clean(cacheDirAudio),
 		CoverCachePath: cacheDirCovers,
 		ProxyPrefix:    *confProxyPrefix,
 		GenreSplit:     *confGenreSplit,
+		ArtistSplit:    *confArtistSplit,
+		ScanWorkers:    *confScanWorkers,
+		ScanExclude:    scanExcludeExpr,
+		DiscFolders:    discFoldersExpr,
+		TagReader:      tagReader,
 		PodcastPath:    filepath.Clean(*confPodcastPath),
 		HTTPLog:        *confHTTPLog,
 		JukeboxEnabled: *confJukeboxEnabled,
+		TrustedProxies: trustedProxies,
+
+		ProxyAuth:       proxyAuth,
+		OIDC:            oidcProvider,
+		AuthCreateUsers: *confAuthCreateUsers,
 	})
 	if err != nil {
 		log.Panicf("error creating server: %v\n", err)
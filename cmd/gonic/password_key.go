@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.senan.xyz/gonic/db"
+)
+
+// readOrCreateSecret reads the secret that users' passwords are encrypted with from the
+// key file, creating it with a random secret if it doesn't exist yet
+func readOrCreateSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		secret, err := randomSecret()
+		if err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(path, []byte(secret+"\n"), 0o600); err != nil {
+			return "", fmt.Errorf("create key file: %w", err)
+		}
+		log.Printf("created password key file %q. keep it safe, and back it up apart from the database\n", path)
+		return secret, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read key file: %w", err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("key file %q is empty", path)
+	}
+	return secret, nil
+}
+
+// checkKeyPath makes sure the key file isn't in the database's directory, or one below it,
+// where a leak or backup of the database would likely have the key too
+func checkKeyPath(keyPath, dbPath string) error {
+	keyDir, err := filepath.Abs(filepath.Dir(keyPath))
+	if err != nil {
+		return fmt.Errorf("find key file directory: %w", err)
+	}
+	dbDir, err := filepath.Abs(filepath.Dir(dbPath))
+	if err != nil {
+		return fmt.Errorf("find database directory: %w", err)
+	}
+	rel, err := filepath.Rel(dbDir, keyDir)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("key file %q is in the database's directory %q, please keep it somewhere else", keyPath, dbDir)
+	}
+	return nil
+}
+
+func randomSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("make secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// runRotatePasswordKey encrypts users' passwords with a new key. it's the new secret if
+// one is given, otherwise the one from the new key file. without either, the secret in
+// the current key file is replaced with a random one
+func runRotatePasswordKey(dbc *db.DB, oldKey *db.PasswordKey, keyPath, newSecret, newKeyPath string) error {
+	if newSecret == "" && newKeyPath == "" {
+		if keyPath == "" {
+			return errors.New("please provide the new secret with -new-password-key or -new-password-key-file")
+		}
+		return replaceKeyFile(dbc, oldKey, keyPath)
+	}
+	if newSecret == "" {
+		var err error
+		if newSecret, err = readOrCreateSecret(newKeyPath); err != nil {
+			return err
+		}
+	}
+	newKey, err := db.NewPasswordKey(newSecret)
+	if err != nil {
+		return fmt.Errorf("create new key: %w", err)
+	}
+	if err := dbc.RotatePasswordKey(oldKey, newKey); err != nil {
+		return fmt.Errorf("rotate key: %w", err)
+	}
+	log.Printf("rotated password key. from now on, please start gonic with the new one\n")
+	return nil
+}
+
+// replaceKeyFile keeps the new secret beside the key file until the passwords are
+// encrypted with it, so that neither is lost if something goes wrong
+func replaceKeyFile(dbc *db.DB, oldKey *db.PasswordKey, keyPath string) error {
+	newSecret, err := randomSecret()
+	if err != nil {
+		return err
+	}
+	newKey, err := db.NewPasswordKey(newSecret)
+	if err != nil {
+		return fmt.Errorf("create new key: %w", err)
+	}
+	newKeyPath := keyPath + ".new"
+	if err := os.WriteFile(newKeyPath, []byte(newSecret+"\n"), 0o600); err != nil {
+		return fmt.Errorf("write new key file: %w", err)
+	}
+	if err := dbc.RotatePasswordKey(oldKey, newKey); err != nil {
+		_ = os.Remove(newKeyPath)
+		return fmt.Errorf("rotate key: %w", err)
+	}
+	if err := os.Rename(newKeyPath, keyPath); err != nil {
+		return fmt.Errorf("passwords use the new key, but please move %q to %q: %w", newKeyPath, keyPath, err)
+	}
+	log.Printf("rotated password key in %q\n", keyPath)
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/scanner"
+)
+
+func runScan(s *scanner.Scanner, opts scanner.ScanOptions, dryRun bool, format string, migrationCtx db.MigrationContext) error {
+	if !dryRun {
+		c, err := s.ScanAndClean(opts)
+		if c == nil {
+			return err
+		}
+		if err != nil {
+			log.Printf("finished scan with errors: %v\n", err)
+		}
+		return nil
+	}
+
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown dry run format %q", format)
+	}
+	changes, err := s.DryRun(opts, migrationCtx)
+	if err != nil {
+		return fmt.Errorf("dry run: %w", err)
+	}
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(changes)
+	}
+	writeChanges(os.Stdout, changes)
+	return nil
+}
+
+func writeChanges(w io.Writer, changes *scanner.Changes) {
+	for _, section := range []struct {
+		name string
+		set  *scanner.ChangeSet
+	}{
+		{"albums", changes.Albums},
+		{"tracks", changes.Tracks},
+		{"artists", changes.Artists},
+		{"genres", changes.Genres},
+	} {
+		fmt.Fprintf(w, "%s: %d added, %d updated, %d removed\n", section.name, len(section.set.Added), len(section.set.Updated), len(section.set.Removed))
+		for _, name := range section.set.Added {
+			fmt.Fprintf(w, "  + %s\n", name)
+		}
+		for _, name := range section.set.Updated {
+			fmt.Fprintf(w, "  ~ %s\n", name)
+		}
+		for _, name := range section.set.Removed {
+			fmt.Fprintf(w, "  - %s\n", name)
+		}
+	}
+	fmt.Fprintf(w, "errors: %d\n", len(changes.Errors))
+	for _, err := range changes.Errors {
+		fmt.Fprintf(w, "  ! %s\n", err)
+	}
+}
@@ -0,0 +1,222 @@
+// Package cue parses cue sheets, which describe the tracks of an album ripped
+// to a single audio file
+package cue
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// https://wiki.hydrogenaud.io/index.php?title=Cue_sheet
+
+var (
+	ErrNoTracks = errors.New("no tracks")
+	ErrBadIndex = errors.New("bad index")
+	ErrNoFile   = errors.New("track before any file")
+)
+
+const framesPerSec = 75
+
+type Track struct {
+	Number    int
+	Title     string
+	Performer string
+	Start     time.Duration
+}
+
+type File struct {
+	Name   string
+	Tracks []*Track
+}
+
+type Sheet struct {
+	Title      string
+	Performer  string
+	Genre      string
+	Date       string
+	DiscNumber int
+	Files      []*File
+}
+
+// Parse parses a raw cue sheet. sheets that aren't valid UTF-8 are read as Latin-1,
+// which is what most older rippers write
+func Parse(data []byte) (*Sheet, error) {
+	raw := string(data)
+	if !utf8.ValidString(raw) {
+		raw = latin1(data)
+	}
+	raw = strings.TrimPrefix(raw, "\ufeff")
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+
+	var ret Sheet
+	var file *File
+	var track *Track
+	var trackFile *File // the file the track was started in
+	var index00 map[*Track]time.Duration
+	for i, line := range strings.Split(raw, "\n") {
+		fields := splitFields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		args := fields[1:]
+		arg := func(n int) string {
+			if n < len(args) {
+				return args[n]
+			}
+			return ""
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "FILE":
+			// the open track is kept, since multi-file sheets (like EAC's default) put the
+			// pregap at the end of the previous file, and the track's index 01 after this
+			file = &File{Name: arg(0)}
+			ret.Files = append(ret.Files, file)
+		case "TRACK":
+			if file == nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, ErrNoFile)
+			}
+			number, _ := strconv.Atoi(arg(0))
+			track = &Track{Number: number}
+			trackFile = file
+			file.Tracks = append(file.Tracks, track)
+		case "TITLE":
+			if track != nil {
+				track.Title = arg(0)
+				continue
+			}
+			ret.Title = arg(0)
+		case "PERFORMER":
+			if track != nil {
+				track.Performer = arg(0)
+				continue
+			}
+			ret.Performer = arg(0)
+		case "REM":
+			if track != nil {
+				continue
+			}
+			switch strings.ToUpper(arg(0)) {
+			case "GENRE":
+				ret.Genre = arg(1)
+			case "DATE":
+				ret.Date = arg(1)
+			case "DISCNUMBER":
+				ret.DiscNumber, _ = strconv.Atoi(arg(1))
+			}
+		case "INDEX":
+			if track == nil {
+				continue
+			}
+			start, err := parseTime(arg(1))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			switch arg(0) {
+			case "00":
+				// only used as the start if there's no index 01, as it's the pregap
+				if index00 == nil {
+					index00 = map[*Track]time.Duration{}
+				}
+				index00[track] = start
+			case "01":
+				if trackFile != file {
+					// the track starts in this file, its pregap is the end of the last one
+					trackFile.Tracks = trackFile.Tracks[:len(trackFile.Tracks)-1]
+					file.Tracks = append(file.Tracks, track)
+					trackFile = file
+				}
+				track.Start = start
+				delete(index00, track)
+			}
+		}
+	}
+	for track, start := range index00 {
+		track.Start = start
+	}
+
+	var files []*File
+	for _, file := range ret.Files {
+		if len(file.Tracks) > 0 {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil, ErrNoTracks
+	}
+	ret.Files = files
+	return &ret, nil
+}
+
+// Year returns the year from the sheet's date, or 0
+func (s *Sheet) Year() int {
+	if len(s.Date) < 4 {
+		return 0
+	}
+	year, _ := strconv.Atoi(s.Date[:4])
+	return year
+}
+
+// IsSheet returns whether the filename looks like a cue sheet
+func IsSheet(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".cue")
+}
+
+// parseTime parses a mm:ss:ff time, where ff is frames of 1/75th of a second
+func parseTime(in string) (time.Duration, error) {
+	parts := strings.Split(in, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("%q: %w", in, ErrBadIndex)
+	}
+	var nums [3]int
+	for i, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil || num < 0 {
+			return 0, fmt.Errorf("%q: %w", in, ErrBadIndex)
+		}
+		nums[i] = num
+	}
+	mins, secs, frames := nums[0], nums[1], nums[2]
+	return time.Duration(mins)*time.Minute +
+		time.Duration(secs)*time.Second +
+		time.Duration(frames)*time.Second/framesPerSec, nil
+}
+
+// splitFields splits a line on whitespace, keeping double quoted strings together
+func splitFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	var inField, quoted bool
+	for _, r := range strings.TrimSpace(line) {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
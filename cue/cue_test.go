@@ -0,0 +1,109 @@
+package cue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	sheet, err := Parse([]byte("\ufeffREM GENRE Classical\r\n" +
+		"REM DATE 1998\r\n" +
+		"PERFORMER \"Some Orchestra\"\r\n" +
+		"TITLE \"Some Album\"\r\n" +
+		"FILE \"Some Album.flac\" WAVE\r\n" +
+		"  TRACK 01 AUDIO\r\n" +
+		"    TITLE \"First Movement\"\r\n" +
+		"    INDEX 01 00:00:00\r\n" +
+		"  TRACK 02 AUDIO\r\n" +
+		"    TITLE \"Second Movement\"\r\n" +
+		"    PERFORMER \"Some Soloist\"\r\n" +
+		"    INDEX 00 04:10:00\r\n" +
+		"    INDEX 01 04:12:37\r\n" +
+		"  TRACK 03 AUDIO\r\n" +
+		"    TITLE Third\r\n" +
+		"    INDEX 00 09:00:00\r\n"))
+	is.NoErr(err)
+
+	is.Equal(sheet.Title, "Some Album")
+	is.Equal(sheet.Performer, "Some Orchestra")
+	is.Equal(sheet.Genre, "Classical")
+	is.Equal(sheet.Year(), 1998)
+	is.Equal(len(sheet.Files), 1)
+	is.Equal(sheet.Files[0].Name, "Some Album.flac")
+
+	tracks := sheet.Files[0].Tracks
+	is.Equal(len(tracks), 3)
+	is.Equal(*tracks[0], Track{Number: 1, Title: "First Movement"})
+	is.Equal(*tracks[1], Track{Number: 2, Title: "Second Movement", Performer: "Some Soloist", Start: 4*time.Minute + 12*time.Second + 37*time.Second/75})
+	is.Equal(*tracks[2], Track{Number: 3, Title: "Third", Start: 9 * time.Minute}) // no index 01, so falls back to 00
+}
+
+func TestParseMultiFile(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	// as written by EAC by default, with each track's pregap at the end of the file before
+	sheet, err := Parse([]byte(`TITLE "Some Album"
+FILE "01.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "First"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Second"
+    INDEX 00 03:00:00
+FILE "02.wav" WAVE
+    INDEX 01 00:00:00
+  TRACK 03 AUDIO
+    TITLE "Third"
+    INDEX 00 04:10:00
+FILE "03.wav" WAVE
+    INDEX 01 00:00:00
+`))
+	is.NoErr(err)
+
+	is.Equal(len(sheet.Files), 3)
+	for i, file := range sheet.Files {
+		is.Equal(len(file.Tracks), 1) // each file has its own track
+		is.Equal(file.Tracks[0].Number, i+1)
+		is.Equal(file.Tracks[0].Start, time.Duration(0))
+	}
+	is.Equal(sheet.Files[1].Name, "02.wav")
+	is.Equal(sheet.Files[1].Tracks[0].Title, "Second")
+	is.Equal(sheet.Files[2].Tracks[0].Title, "Third")
+}
+
+func TestParseLatin1(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	sheet, err := Parse([]byte("TITLE \"Caf\xe9\"\nFILE a.flac WAVE\nTRACK 01 AUDIO\nINDEX 01 00:00:00\n"))
+	is.NoErr(err)
+	is.Equal(sheet.Title, "Café")
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	_, err := Parse([]byte("TITLE \"Nothing\"\n"))
+	is.True(errors.Is(err, ErrNoTracks))
+	_, err = Parse([]byte("TRACK 01 AUDIO\n"))
+	is.True(errors.Is(err, ErrNoFile))
+	_, err = Parse([]byte("FILE a.flac WAVE\nTRACK 01 AUDIO\nINDEX 01 00:xx:00\n"))
+	is.True(errors.Is(err, ErrBadIndex))
+}
+
+func TestIsSheet(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	is.True(IsSheet("album.cue"))
+	is.True(IsSheet("album.CUE"))
+	is.True(!IsSheet("album.flac"))
+}
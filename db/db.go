@@ -1,12 +1,16 @@
 package db
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 )
@@ -92,6 +96,7 @@ func (db *DB) GetUserByID(id int) *User {
 	var user User
 	err := db.
 		Where("id=?", id).
+		Preload("MusicFolders").
 		First(&user).
 		Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -104,6 +109,7 @@ func (db *DB) GetUserByName(name string) *User {
 	var user User
 	err := db.
 		Where("name=?", name).
+		Preload("MusicFolders").
 		First(&user).
 		Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -112,6 +118,264 @@ func (db *DB) GetUserByName(name string) *User {
 	return &user
 }
 
+// LoadTrackPlays sets how many times the user played each track, and when they last did,
+// counted in one query instead of loading every play
+func (db *DB) LoadTrackPlays(userID int, tracks ...*Track) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+	ids := make([]int, 0, len(tracks))
+	for _, track := range tracks {
+		ids = append(ids, track.ID)
+	}
+	var plays []struct {
+		TrackID int
+		Count   int
+		Last    time.Time
+	}
+	// with max(), sqlite takes the bare time column from the latest play, keeping its type
+	err := db.
+		Raw(`
+			SELECT track_id, count(*) AS count, time AS last, max(time)
+			FROM track_plays
+			WHERE user_id=? AND track_id IN (?)
+			GROUP BY track_id`, userID, ids).
+		Scan(&plays).
+		Error
+	if err != nil {
+		return fmt.Errorf("count plays: %w", err)
+	}
+	byTrack := make(map[int]int, len(plays))
+	for i, play := range plays {
+		byTrack[play.TrackID] = i
+	}
+	for _, track := range tracks {
+		track.PlayCount, track.LastPlayed = 0, nil
+		if i, ok := byTrack[track.ID]; ok {
+			track.PlayCount, track.LastPlayed = plays[i].Count, &plays[i].Last
+		}
+	}
+	return nil
+}
+
+// GetShareTracks finds the tracks of each entry of a share, in the order they were shared.
+// the share's entries, and its user with their music folders, must be preloaded. tracks
+// the user can't see aren't shared
+func (db *DB) GetShareTracks(share *Share) ([]*Track, error) {
+	var ret []*Track
+	for _, entry := range share.Entries {
+		var trackIDs []int
+		switch {
+		case entry.TrackID != 0:
+			trackIDs = []int{entry.TrackID}
+		case entry.AlbumID != 0:
+			err := db.
+				Model(Track{}).
+				Where("album_id=?", entry.AlbumID).
+				Order("tag_disc_number, tag_track_number, filename").
+				Pluck("id", &trackIDs).
+				Error
+			if err != nil {
+				return nil, fmt.Errorf("find album tracks: %w", err)
+			}
+		case entry.PlaylistID != 0:
+			var playlist Playlist
+			if err := db.First(&playlist, entry.PlaylistID).Error; err != nil {
+				return nil, fmt.Errorf("find playlist: %w", err)
+			}
+			trackIDs = playlist.GetItems()
+		}
+		for _, id := range trackIDs {
+			var track Track
+			err := db.
+				Preload("Album").
+				Preload("Album.TagArtist").
+				First(&track, id).
+				Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue // removed since it was added to a playlist
+			}
+			if err != nil {
+				return nil, fmt.Errorf("find track: %w", err)
+			}
+			if !share.User.HasMusicFolder(track.Album.RootDir) {
+				continue
+			}
+			ret = append(ret, &track)
+		}
+	}
+	return ret, nil
+}
+
+// GetUserByOIDCSubject finds the user who linked the OpenID provider's account with
+// the subject
+func (db *DB) GetUserByOIDCSubject(subject string) *User {
+	if subject == "" {
+		return nil
+	}
+	var user User
+	err := db.
+		Where("oidc_subject=?", subject).
+		Preload("MusicFolders").
+		First(&user).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return &user
+}
+
+var ErrOIDCSubjectLinked = errors.New("single sign-on account is already linked to another user")
+
+// SetUserOIDCSubject links the OpenID provider's account with the subject to the user,
+// or unlinks it if the subject is empty. an account can only be linked to one user
+func (db *DB) SetUserOIDCSubject(user *User, subject string) error {
+	if other := db.GetUserByOIDCSubject(subject); other != nil && other.ID != user.ID {
+		return ErrOIDCSubjectLinked
+	}
+	var value interface{}
+	if subject != "" {
+		value = subject
+	}
+	if err := db.Model(user).Update("oidc_subject", value).Error; err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	user.OIDCSubject = subject
+	return nil
+}
+
+// SetUserMusicFolders limits the user to the music paths, or lets them see every music path
+// if there are none
+func (db *DB) SetUserMusicFolders(user *User, paths []string) error {
+	folders := make([]*UserMusicFolder, 0, len(paths))
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id=?", user.ID).Delete(UserMusicFolder{}).Error; err != nil {
+			return fmt.Errorf("delete music folders: %w", err)
+		}
+		for _, path := range paths {
+			folder := &UserMusicFolder{UserID: user.ID, Path: path}
+			if err := tx.Create(folder).Error; err != nil {
+				return fmt.Errorf("create music folder: %w", err)
+			}
+			folders = append(folders, folder)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	user.MusicFolders = folders
+	return nil
+}
+
+// CreateAPIKey makes a new api key for the user, returning the key itself. it can't be
+// found again later, since only its hash is stored
+func (db *DB) CreateAPIKey(user *User, label string) (string, *APIKey, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, fmt.Errorf("generate key: %w", err)
+	}
+	key := hex.EncodeToString(buf)
+	apiKey := &APIKey{UserID: user.ID, Label: label, Hash: HashAPIKey(key)}
+	if err := db.Create(apiKey).Error; err != nil {
+		return "", nil, fmt.Errorf("create api key: %w", err)
+	}
+	return key, apiKey, nil
+}
+
+// GetUserByAPIKey finds the user the api key belongs to, and notes that the key was
+// used. nil is returned if there's no such key
+func (db *DB) GetUserByAPIKey(key string) (*User, error) {
+	var apiKey APIKey
+	err := db.
+		Where("hash=?", HashAPIKey(key)).
+		First(&apiKey).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find api key: %w", err)
+	}
+	if err := db.Model(&apiKey).Update("last_used", time.Now()).Error; err != nil {
+		return nil, fmt.Errorf("update last used: %w", err)
+	}
+	return db.GetUserByID(apiKey.UserID), nil
+}
+
+// UseTOTPStep notes the step of a code the user just logged in with, returning false if
+// it, or a later one, was already used
+func (db *DB) UseTOTPStep(user *User, step int64) (bool, error) {
+	q := db.
+		Model(&User{}).
+		Where("id=? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if err := q.Error; err != nil {
+		return false, fmt.Errorf("update last step: %w", err)
+	}
+	return q.RowsAffected == 1, nil
+}
+
+// CreateTOTPRecoveryCodes replaces the user's recovery codes with new ones, returning
+// them. like api keys, only their hashes are stored
+func (db *DB) CreateTOTPRecoveryCodes(user *User) ([]string, error) {
+	const count = 10
+	codes := make([]string, 0, count)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id=?", user.ID).Delete(TOTPRecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("delete old codes: %w", err)
+		}
+		for i := 0; i < count; i++ {
+			buf := make([]byte, 5)
+			if _, err := rand.Read(buf); err != nil {
+				return fmt.Errorf("generate code: %w", err)
+			}
+			code := hex.EncodeToString(buf)
+			code = code[:5] + "-" + code[5:]
+			if err := tx.Create(&TOTPRecoveryCode{UserID: user.ID, Hash: HashAPIKey(code)}).Error; err != nil {
+				return fmt.Errorf("create code: %w", err)
+			}
+			codes = append(codes, code)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseTOTPRecoveryCode uses up one of the user's recovery codes, returning false if it
+// isn't one of theirs
+func (db *DB) UseTOTPRecoveryCode(user *User, code string) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	q := db.
+		Where("user_id=? AND hash=?", user.ID, HashAPIKey(code)).
+		Delete(TOTPRecoveryCode{})
+	if err := q.Error; err != nil {
+		return false, fmt.Errorf("delete code: %w", err)
+	}
+	return q.RowsAffected == 1, nil
+}
+
+// DisableTOTP removes the user's two-factor auth, and their recovery codes
+func (db *DB) DisableTOTP(user *User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{"totp_secret": "", "totp_last_step": 0}).Error; err != nil {
+			return fmt.Errorf("clear secret: %w", err)
+		}
+		if err := tx.Where("user_id=?", user.ID).Delete(TOTPRecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("delete recovery codes: %w", err)
+		}
+		return nil
+	})
+}
+
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
 func (db *DB) Begin() *DB {
 	return &DB{DB: db.DB.Begin()}
 }
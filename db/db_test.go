@@ -1,11 +1,14 @@
 package db
 
 import (
+	"errors"
 	"io"
 	"log"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/matryer/is"
@@ -30,7 +33,11 @@ func TestGetSetting(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error creating db: %v", err)
 	}
-	if err := testDB.Migrate(MigrationContext{}); err != nil {
+	passwordKey, err := NewPasswordKey("test")
+	if err != nil {
+		t.Fatalf("error creating password key: %v", err)
+	}
+	if err := testDB.Migrate(MigrationContext{PasswordKey: passwordKey}); err != nil {
 		t.Fatalf("error migrating db: %v", err)
 	}
 
@@ -46,7 +53,150 @@ func TestGetSetting(t *testing.T) {
 	is.Equal(actual, value)
 }
 
+func TestPasswordKey(t *testing.T) {
+	is := is.New(t)
+
+	key, err := NewPasswordKey("test")
+	is.NoErr(err)
+	encrypted, err := key.Encrypt("admin")
+	is.NoErr(err)
+	is.True(encrypted != "admin")
+	decrypted, err := key.Decrypt(encrypted)
+	is.NoErr(err)
+	is.Equal(decrypted, "admin")
+
+	otherKey, err := NewPasswordKey("other")
+	is.NoErr(err)
+	_, err = otherKey.Decrypt(encrypted)
+	is.True(err != nil)
+
+	testDB, err := NewMock()
+	is.NoErr(err)
+	is.True(errors.Is(testDB.Migrate(MigrationContext{}), ErrNoPasswordKey))
+
+	// the initial user's password is encrypted by the migrations
+	testDB, err = NewMock()
+	is.NoErr(err)
+	is.NoErr(testDB.Migrate(MigrationContext{PasswordKey: key}))
+	password := func(key *PasswordKey) (string, error) {
+		return key.Decrypt(testDB.GetUserByName("admin").Password)
+	}
+	decrypted, err = password(key)
+	is.NoErr(err)
+	is.Equal(decrypted, "admin")
+	is.NoErr(testDB.CheckPasswordKey(key))
+	is.True(testDB.CheckPasswordKey(otherKey) != nil)
+
+	is.NoErr(testDB.RotatePasswordKey(key, otherKey))
+	_, err = password(key)
+	is.True(err != nil)
+	decrypted, err = password(otherKey)
+	is.NoErr(err)
+	is.Equal(decrypted, "admin")
+
+	// nothing changes with the wrong old key
+	is.True(testDB.RotatePasswordKey(key, otherKey) != nil)
+	decrypted, err = password(otherKey)
+	is.NoErr(err)
+	is.Equal(decrypted, "admin")
+}
+
 func TestMain(m *testing.M) {
 	log.SetOutput(io.Discard)
 	os.Exit(m.Run())
 }
+
+func TestTOTP(t *testing.T) {
+	is := is.New(t)
+
+	key, err := NewPasswordKey("test")
+	is.NoErr(err)
+	testDB, err := NewMock()
+	is.NoErr(err)
+	is.NoErr(testDB.Migrate(MigrationContext{PasswordKey: key}))
+
+	user := testDB.GetUserByName("admin")
+	is.True(!user.HasTOTP())
+	encrypted, err := key.Encrypt("JBSWY3DPEHPK3PXP")
+	is.NoErr(err)
+	is.NoErr(testDB.Model(user).Update("totp_secret", encrypted).Error)
+	user = testDB.GetUserByName("admin")
+	is.True(user.HasTOTP())
+
+	// each step can only be used once, and not after a later one
+	ok, err := testDB.UseTOTPStep(user, 10)
+	is.NoErr(err)
+	is.True(ok)
+	ok, err = testDB.UseTOTPStep(user, 10)
+	is.NoErr(err)
+	is.True(!ok)
+	ok, err = testDB.UseTOTPStep(user, 9)
+	is.NoErr(err)
+	is.True(!ok)
+
+	codes, err := testDB.CreateTOTPRecoveryCodes(user)
+	is.NoErr(err)
+	is.Equal(len(codes), 10)
+	ok, err = testDB.UseTOTPRecoveryCode(user, strings.ToUpper(codes[0]))
+	is.NoErr(err)
+	is.True(ok)
+	ok, err = testDB.UseTOTPRecoveryCode(user, codes[0])
+	is.NoErr(err)
+	is.True(!ok) // used up
+
+	// making new codes replaces the old ones
+	_, err = testDB.CreateTOTPRecoveryCodes(user)
+	is.NoErr(err)
+	ok, err = testDB.UseTOTPRecoveryCode(user, codes[1])
+	is.NoErr(err)
+	is.True(!ok)
+
+	// the secret is rotated with the password
+	otherKey, err := NewPasswordKey("other")
+	is.NoErr(err)
+	is.NoErr(testDB.RotatePasswordKey(key, otherKey))
+	secret, err := otherKey.Decrypt(testDB.GetUserByName("admin").TOTPSecret)
+	is.NoErr(err)
+	is.Equal(secret, "JBSWY3DPEHPK3PXP")
+
+	is.NoErr(testDB.DisableTOTP(user))
+	user = testDB.GetUserByName("admin")
+	is.True(!user.HasTOTP())
+	is.Equal(user.TOTPLastStep, int64(0))
+	var count int
+	is.NoErr(testDB.Model(TOTPRecoveryCode{}).Count(&count).Error)
+	is.Equal(count, 0)
+}
+
+func TestMigrateTrackPlays(t *testing.T) {
+	is := is.New(t)
+
+	key, err := NewPasswordKey("test")
+	is.NoErr(err)
+	testDB, err := NewMock()
+	is.NoErr(err)
+	is.NoErr(testDB.Migrate(MigrationContext{PasswordKey: key}))
+
+	user := testDB.GetUserByName("admin")
+	artist := Artist{Name: "artist"}
+	is.NoErr(testDB.Create(&artist).Error)
+	album := Album{LeftPath: "artist/", RightPath: "album"}
+	is.NoErr(testDB.Create(&album).Error)
+	second := Track{Filename: "b.flac", AlbumID: album.ID, ArtistID: artist.ID, TagTrackNumber: 2}
+	is.NoErr(testDB.Create(&second).Error)
+	first := Track{Filename: "a.flac", AlbumID: album.ID, ArtistID: artist.ID, TagTrackNumber: 1}
+	is.NoErr(testDB.Create(&first).Error)
+	played := time.Date(2022, time.October, 1, 12, 0, 0, 0, time.UTC)
+	is.NoErr(testDB.Create(&Play{UserID: user.ID, AlbumID: album.ID, Time: played, Count: 3}).Error)
+
+	// the album's plays are kept, on its first track
+	is.NoErr(migrateTrackPlays(testDB.DB, MigrationContext{}))
+	var plays []*TrackPlay
+	is.NoErr(testDB.Find(&plays).Error)
+	is.Equal(len(plays), 3)
+	for _, play := range plays {
+		is.Equal(play.UserID, user.ID)
+		is.Equal(play.TrackID, first.ID)
+		is.True(play.Time.Equal(played))
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 type MigrationContext struct {
 	OriginalMusicPath string
+	PasswordKey       *PasswordKey
 }
 
 func (db *DB) Migrate(ctx MigrationContext) error {
@@ -45,6 +46,23 @@ func (db *DB) Migrate(ctx MigrationContext) error {
 		construct(ctx, "202206011628", migrateInternetRadioStations),
 		construct(ctx, "202206101425", migrateUser),
 		construct(ctx, "202207251148", migrateStarRating),
+		construct(ctx, "202210121921", migrateMultiArtist),
+		construct(ctx, "202210141702", migrateTrackLyrics),
+		construct(ctx, "202210172046", migrateScanHistory),
+		construct(ctx, "202210191204", migrateTrackCue),
+		construct(ctx, "202210201015", migrateTrackReplayGain),
+		construct(ctx, "202210211630", migrateRicherTags),
+		construct(ctx, "202210241230", migrateTrackPlays),
+		construct(ctx, "202210251900", migrateNowPlaying),
+		construct(ctx, "202210281430", migrateShares),
+		construct(ctx, "202210301200", migrateUserRoles),
+		construct(ctx, "202211021530", migrateUserMusicFolders),
+		construct(ctx, "202211051400", migrateAPIKeys),
+		construct(ctx, "202211081000", migrateEncryptPasswords),
+		construct(ctx, "202211101200", migrateTOTP),
+		construct(ctx, "202211121500", migrateSmartPlaylists),
+		construct(ctx, "202211141200", migrateUserOIDCSubject),
+		construct(ctx, "202211151200", migrateTrackArtists),
 	}
 
 	return gormigrate.
@@ -75,6 +93,7 @@ func migrateInitSchema(tx *gorm.DB, _ MigrationContext) error {
 		Genre{},
 		TrackGenre{},
 		AlbumGenre{},
+		AlbumArtist{},
 		Track{},
 		Artist{},
 		User{},
@@ -386,3 +405,225 @@ func migrateStarRating(tx *gorm.DB, _ MigrationContext) error {
 	).
 		Error
 }
+
+func migrateMultiArtist(tx *gorm.DB, _ MigrationContext) error {
+	step := tx.AutoMigrate(
+		AlbumArtist{},
+	)
+	if err := step.Error; err != nil {
+		return fmt.Errorf("step auto migrate: %w", err)
+	}
+
+	step = tx.Exec(`
+		INSERT OR IGNORE INTO album_artists (album_id, artist_id)
+			SELECT id, tag_artist_id
+			FROM albums
+			WHERE tag_artist_id IS NOT NULL;
+	`)
+	if err := step.Error; err != nil {
+		return fmt.Errorf("step migrate album artists: %w", err)
+	}
+	return nil
+}
+
+func migrateTrackLyrics(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		Track{},
+	).
+		Error
+}
+
+func migrateScanHistory(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		Scan{},
+		ScanError{},
+	).
+		Error
+}
+
+func migrateTrackCue(tx *gorm.DB, _ MigrationContext) error {
+	step := tx.AutoMigrate(
+		Track{},
+	)
+	if err := step.Error; err != nil {
+		return fmt.Errorf("step auto migrate: %w", err)
+	}
+
+	// a file can now have many tracks, one for each track in its cue sheet
+	step = tx.Exec(`
+		DROP INDEX IF EXISTS idx_folder_filename;
+		CREATE UNIQUE INDEX idx_folder_filename ON tracks (album_id, filename, cue_track);
+	`)
+	if err := step.Error; err != nil {
+		return fmt.Errorf("step recreate idx: %w", err)
+	}
+	return nil
+}
+
+func migrateTrackReplayGain(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		Track{},
+	).
+		Error
+}
+
+func migrateRicherTags(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		Track{},
+		Album{},
+	).
+		Error
+}
+
+func migrateTrackPlays(tx *gorm.DB, _ MigrationContext) error {
+	step := tx.AutoMigrate(
+		TrackPlay{},
+	)
+	if err := step.Error; err != nil {
+		return fmt.Errorf("step auto migrate: %w", err)
+	}
+
+	// the old plays only counted by album, so which tracks were played is lost. give
+	// each album's plays to its first track, so that the albums' play counts and last
+	// played times stay the same for the frequent and recent album lists
+	step = tx.Exec(`
+		WITH RECURSIVE counter(n) AS (
+			SELECT 1
+			UNION ALL
+			SELECT n+1 FROM counter WHERE n < (SELECT max(count) FROM plays)
+		)
+		INSERT INTO track_plays (user_id, track_id, time)
+		SELECT plays.user_id, (
+			SELECT tracks.id FROM tracks
+			WHERE tracks.album_id=plays.album_id
+			ORDER BY tracks.tag_disc_number, tracks.tag_track_number, tracks.filename
+			LIMIT 1
+		) track_id, plays.time
+		FROM plays
+		JOIN counter ON counter.n <= plays.count
+		WHERE plays.time IS NOT NULL AND track_id IS NOT NULL;
+	`)
+	if err := step.Error; err != nil {
+		return fmt.Errorf("step copy plays: %w", err)
+	}
+	return nil
+}
+
+func migrateNowPlaying(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		NowPlayingEntry{},
+	).
+		Error
+}
+
+func migrateShares(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		Share{},
+		ShareEntry{},
+	).
+		Error
+}
+
+func migrateUserRoles(tx *gorm.DB, _ MigrationContext) error {
+	step := tx.AutoMigrate(
+		User{},
+	)
+	if err := step.Error; err != nil {
+		return fmt.Errorf("step auto migrate: %w", err)
+	}
+
+	// keep what users could do before they had roles. managing podcasts was only for
+	// admins, who have every role anyway
+	step = tx.Exec(`
+		UPDATE users SET download_role=1, jukebox_role=1, share_role=1, scan_role=1, playlist_role=1;
+	`)
+	if err := step.Error; err != nil {
+		return fmt.Errorf("step set roles: %w", err)
+	}
+	return nil
+}
+
+func migrateUserMusicFolders(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		UserMusicFolder{},
+	).
+		Error
+}
+
+func migrateAPIKeys(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		APIKey{},
+	).
+		Error
+}
+
+// migrateEncryptPasswords encrypts the passwords that were stored in plain text before,
+// including the initial user's
+func migrateEncryptPasswords(tx *gorm.DB, ctx MigrationContext) error {
+	if ctx.PasswordKey == nil {
+		return ErrNoPasswordKey
+	}
+	var users []*User
+	if err := tx.Find(&users).Error; err != nil {
+		return fmt.Errorf("find users: %w", err)
+	}
+	// encrypt them all before saving any, so that a failure doesn't leave some
+	// encrypted to be encrypted again next time
+	encrypted := make([]string, len(users))
+	for i, user := range users {
+		var err error
+		if encrypted[i], err = ctx.PasswordKey.Encrypt(user.Password); err != nil {
+			return fmt.Errorf("encrypt password of %q: %w", user.Name, err)
+		}
+	}
+	for i, user := range users {
+		if err := tx.Model(user).Update("password", encrypted[i]).Error; err != nil {
+			return fmt.Errorf("save password of %q: %w", user.Name, err)
+		}
+	}
+	return nil
+}
+
+func migrateTOTP(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		User{},
+		TOTPRecoveryCode{},
+	).
+		Error
+}
+
+func migrateSmartPlaylists(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		Playlist{},
+	).
+		Error
+}
+
+func migrateUserOIDCSubject(tx *gorm.DB, _ MigrationContext) error {
+	return tx.AutoMigrate(
+		User{},
+	).
+		Error
+}
+
+func migrateTrackArtists(tx *gorm.DB, _ MigrationContext) error {
+	step := tx.AutoMigrate(
+		TrackArtist{},
+	)
+	if err := step.Error; err != nil {
+		return fmt.Errorf("step auto migrate: %w", err)
+	}
+
+	// until the tracks are read again, credit them to the artist with the same name as
+	// their artist tag, if there is one
+	step = tx.Exec(`
+		INSERT OR IGNORE INTO track_artists (track_id, artist_id)
+			SELECT tracks.id, artists.id
+			FROM tracks
+			JOIN artists ON artists.name=tracks.tag_track_artist;
+	`)
+	if err := step.Error; err != nil {
+		return fmt.Errorf("step migrate track artists: %w", err)
+	}
+	return nil
+}
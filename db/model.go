@@ -84,28 +84,42 @@ type AudioFile interface {
 }
 
 type Track struct {
-	ID             int `gorm:"primary_key"`
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Filename       string `gorm:"not null; unique_index:idx_folder_filename" sql:"default: null"`
-	FilenameUDec   string `sql:"default: null"`
-	Album          *Album
-	AlbumID        int `gorm:"not null; unique_index:idx_folder_filename" sql:"default: null; type:int REFERENCES albums(id) ON DELETE CASCADE"`
-	Artist         *Artist
-	ArtistID       int      `gorm:"not null" sql:"default: null; type:int REFERENCES artists(id) ON DELETE CASCADE"`
-	Genres         []*Genre `gorm:"many2many:track_genres"`
-	Size           int      `sql:"default: null"`
-	Length         int      `sql:"default: null"`
-	Bitrate        int      `sql:"default: null"`
-	TagTitle       string   `sql:"default: null"`
-	TagTitleUDec   string   `sql:"default: null"`
-	TagTrackArtist string   `sql:"default: null"`
-	TagTrackNumber int      `sql:"default: null"`
-	TagDiscNumber  int      `sql:"default: null"`
-	TagBrainzID    string   `sql:"default: null"`
-	TrackStar      *TrackStar
-	TrackRating    *TrackRating
-	AverageRating  float64 `sql:"default: null"`
+	ID                  int `gorm:"primary_key"`
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	Filename            string `gorm:"not null; unique_index:idx_folder_filename" sql:"default: null"`
+	FilenameUDec        string `sql:"default: null"`
+	Album               *Album
+	AlbumID             int `gorm:"not null; unique_index:idx_folder_filename" sql:"default: null; type:int REFERENCES albums(id) ON DELETE CASCADE"`
+	Artist              *Artist
+	ArtistID            int      `gorm:"not null" sql:"default: null; type:int REFERENCES artists(id) ON DELETE CASCADE"`
+	Genres              []*Genre `gorm:"many2many:track_genres"`
+	Size                int      `sql:"default: null"`
+	Length              int      `sql:"default: null"`
+	Bitrate             int      `sql:"default: null"`
+	TagTitle            string   `sql:"default: null"`
+	TagTitleUDec        string   `sql:"default: null"`
+	TagTrackArtist      string   `sql:"default: null"`
+	TagTrackNumber      int      `sql:"default: null"`
+	TagDiscNumber       int      `sql:"default: null"`
+	TagBrainzID         string   `sql:"default: null"`
+	Lyrics              string   `sql:"default: null"`
+	TrackStar           *TrackStar
+	TrackRating         *TrackRating
+	AverageRating       float64  `sql:"default: null"`
+	CueTrack            int      `gorm:"not null; default:0; unique_index:idx_folder_filename"` // 0 unless the track is one of many in a file split by a cue sheet
+	CueStart            int      `sql:"default: null"`                                          // offset into the file in milliseconds
+	CueEnd              int      `sql:"default: null"`                                          // 0 if the track plays to the end of the file
+	ReplayGainTrackGain *float32 `sql:"default: null"`                                          // nil if untagged
+	ReplayGainTrackPeak *float32 `sql:"default: null"`
+	ReplayGainAlbumGain *float32 `sql:"default: null"`
+	ReplayGainAlbumPeak *float32 `sql:"default: null"`
+	TagComposer         string   `sql:"default: null"`
+	TagBPM              int      `sql:"default: null"`
+	TagComment          string   `sql:"default: null"`
+
+	PlayCount  int        `sql:"-"` // of a user, set by LoadTrackPlays
+	LastPlayed *time.Time `sql:"-"`
 }
 
 func (t *Track) AudioLength() int  { return t.Length }
@@ -176,6 +190,113 @@ type User struct {
 	ListenBrainzToken string `sql:"default: null"`
 	IsAdmin           bool   `sql:"default: null"`
 	Avatar            []byte `sql:"default: null"`
+	DownloadRole      bool
+	JukeboxRole       bool
+	PodcastRole       bool
+	ShareRole         bool
+	ScanRole          bool
+	PlaylistRole      bool
+	MusicFolders      []*UserMusicFolder
+	TOTPSecret        string `sql:"default: null"` // encrypted like the password, empty without two-factor auth
+	TOTPLastStep      int64  // of the last code used, so that it can't be used again
+	OIDCSubject       string `gorm:"column:oidc_subject; index" sql:"default: null"` // the OpenID provider's id for them, if they've linked single sign-on
+}
+
+// Role is something a user can be allowed to do. admins can do everything
+type Role string
+
+const (
+	RoleDownload Role = "download"
+	RoleJukebox  Role = "jukebox"
+	RolePodcast  Role = "podcast"
+	RoleShare    Role = "share"
+	RoleScan     Role = "scan"
+	RolePlaylist Role = "playlist"
+)
+
+func (u *User) HasRole(role Role) bool {
+	if u.IsAdmin {
+		return true
+	}
+	switch role {
+	case RoleDownload:
+		return u.DownloadRole
+	case RoleJukebox:
+		return u.JukeboxRole
+	case RolePodcast:
+		return u.PodcastRole
+	case RoleShare:
+		return u.ShareRole
+	case RoleScan:
+		return u.ScanRole
+	case RolePlaylist:
+		return u.PlaylistRole
+	default:
+		return false
+	}
+}
+
+// SetDefaultRoles allows the user to do what every user could before there were roles.
+// managing podcasts was only for admins, so it has to be given
+func (u *User) SetDefaultRoles() {
+	u.DownloadRole = true
+	u.JukeboxRole = true
+	u.ShareRole = true
+	u.ScanRole = true
+	u.PlaylistRole = true
+}
+
+// UserMusicFolder limits a user to one of the music paths. users without any can see
+// every music path
+type UserMusicFolder struct {
+	User   *User
+	UserID int    `gorm:"primary_key; not null" sql:"default: null; type:int REFERENCES users(id) ON DELETE CASCADE"`
+	Path   string `gorm:"primary_key; not null"`
+}
+
+// TOTPRecoveryCode is a hashed single use code that logs a user in to the web
+// interface when they don't have their authenticator app
+type TOTPRecoveryCode struct {
+	ID     int `gorm:"primary_key"`
+	User   *User
+	UserID int    `gorm:"not null; index" sql:"default: null; type:int REFERENCES users(id) ON DELETE CASCADE"`
+	Hash   string `gorm:"not null" sql:"default: null"`
+}
+
+// HasTOTP returns whether the user needs a code from an authenticator app to log in
+// to the web interface
+func (u *User) HasTOTP() bool {
+	return u.TOTPSecret != ""
+}
+
+// HasMusicFolder returns whether the user can see the music path. admins can see them all
+func (u *User) HasMusicFolder(path string) bool {
+	if !u.IsRestricted() {
+		return true
+	}
+	for _, folder := range u.MusicFolders {
+		if folder.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRestricted returns whether the user is limited to some of the music paths
+func (u *User) IsRestricted() bool {
+	return !u.IsAdmin && len(u.MusicFolders) > 0
+}
+
+// APIKey lets a client log in as the user with the apiKey param, instead of their
+// password. only a hash of the key is kept
+type APIKey struct {
+	ID        int `gorm:"primary_key"`
+	CreatedAt time.Time
+	User      *User
+	UserID    int        `gorm:"not null; index" sql:"default: null; type:int REFERENCES users(id) ON DELETE CASCADE"`
+	Label     string     `sql:"default: null"`
+	Hash      string     `gorm:"not null; unique_index" sql:"default: null"`
+	LastUsed  *time.Time `sql:"default: null"`
 }
 
 type Setting struct {
@@ -193,31 +314,45 @@ type Play struct {
 	Count   int
 }
 
+// TrackPlay is one listen of a track, for the user's play counts and history
+type TrackPlay struct {
+	ID      int `gorm:"primary_key"`
+	User    *User
+	UserID  int `gorm:"not null; index" sql:"default: null; type:int REFERENCES users(id) ON DELETE CASCADE"`
+	Track   *Track
+	TrackID int       `gorm:"not null; index" sql:"default: null; type:int REFERENCES tracks(id) ON DELETE CASCADE"`
+	Time    time.Time `gorm:"not null; index" sql:"default: null"`
+}
+
 type Album struct {
-	ID            int `gorm:"primary_key"`
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	ModifiedAt    time.Time
-	LeftPath      string `gorm:"unique_index:idx_album_abs_path"`
-	RightPath     string `gorm:"not null; unique_index:idx_album_abs_path" sql:"default: null"`
-	RightPathUDec string `sql:"default: null"`
-	Parent        *Album
-	ParentID      int      `sql:"default: null; type:int REFERENCES albums(id) ON DELETE CASCADE"`
-	RootDir       string   `gorm:"unique_index:idx_album_abs_path" sql:"default: null"`
-	Genres        []*Genre `gorm:"many2many:album_genres"`
-	Cover         string   `sql:"default: null"`
-	TagArtist     *Artist
-	TagArtistID   int    `gorm:"index" sql:"default: null; type:int REFERENCES artists(id) ON DELETE CASCADE"`
-	TagTitle      string `sql:"default: null"`
-	TagTitleUDec  string `sql:"default: null"`
-	TagBrainzID   string `sql:"default: null"`
-	TagYear       int    `sql:"default: null"`
-	Tracks        []*Track
-	ChildCount    int `sql:"-"`
-	Duration      int `sql:"-"`
-	AlbumStar     *AlbumStar
-	AlbumRating   *AlbumRating
-	AverageRating float64 `sql:"default: null"`
+	ID              int `gorm:"primary_key"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	ModifiedAt      time.Time
+	LeftPath        string `gorm:"unique_index:idx_album_abs_path"`
+	RightPath       string `gorm:"not null; unique_index:idx_album_abs_path" sql:"default: null"`
+	RightPathUDec   string `sql:"default: null"`
+	Parent          *Album
+	ParentID        int      `sql:"default: null; type:int REFERENCES albums(id) ON DELETE CASCADE"`
+	RootDir         string   `gorm:"unique_index:idx_album_abs_path" sql:"default: null"`
+	Genres          []*Genre `gorm:"many2many:album_genres"`
+	Cover           string   `sql:"default: null"`
+	TagArtist       *Artist
+	TagArtistID     int    `gorm:"index" sql:"default: null; type:int REFERENCES artists(id) ON DELETE CASCADE"`
+	TagTitle        string `sql:"default: null"`
+	TagTitleUDec    string `sql:"default: null"`
+	TagBrainzID     string `sql:"default: null"`
+	TagYear         int    `sql:"default: null"`
+	TagOriginalYear int    `sql:"default: null"`
+	TagLabel        string `sql:"default: null"`
+	TagCompilation  bool   `sql:"default: 0"`
+	TagReleaseType  string `sql:"default: null"` // lower case, separated by ";" if there's more than one
+	Tracks          []*Track
+	ChildCount      int `sql:"-"`
+	Duration        int `sql:"-"`
+	AlbumStar       *AlbumStar
+	AlbumRating     *AlbumRating
+	AverageRating   float64 `sql:"default: null"`
 }
 
 func (a *Album) SID() *specid.ID {
@@ -253,7 +388,13 @@ type Playlist struct {
 	Comment    string
 	TrackCount int
 	Items      string
-	IsPublic   bool `sql:"default: null"`
+	IsPublic   bool   `sql:"default: null"`
+	Rules      string `sql:"default: null"` // json of smartplaylist.Rules for a smart playlist, whose tracks aren't in Items
+}
+
+// IsSmart returns whether the playlist's tracks are found by its rules
+func (p *Playlist) IsSmart() bool {
+	return p.Rules != ""
 }
 
 func (p *Playlist) GetItems() []int {
@@ -265,6 +406,46 @@ func (p *Playlist) SetItems(items []int) {
 	p.TrackCount = len(items)
 }
 
+// Share is a public link to some of a user's music, for people without an account
+type Share struct {
+	ID          int `gorm:"primary_key"`
+	CreatedAt   time.Time
+	UUID        string `gorm:"not null; unique_index" sql:"default: null"`
+	User        *User
+	UserID      int        `gorm:"not null; index" sql:"default: null; type:int REFERENCES users(id) ON DELETE CASCADE"`
+	Description string     `sql:"default: null"`
+	Expires     *time.Time `sql:"default: null"` // nil if the share never expires
+	LastVisited *time.Time `sql:"default: null"`
+	VisitCount  int
+	Entries     []*ShareEntry
+}
+
+func (s *Share) IsExpired(now time.Time) bool {
+	return s.Expires != nil && !now.Before(*s.Expires)
+}
+
+// ShareEntry is one of the tracks, albums, or playlists in a share. only one ID is set
+type ShareEntry struct {
+	ID         int `gorm:"primary_key"`
+	Share      *Share
+	ShareID    int `gorm:"not null; index" sql:"default: null; type:int REFERENCES shares(id) ON DELETE CASCADE"`
+	TrackID    int `sql:"default: null; type:int REFERENCES tracks(id) ON DELETE CASCADE"`
+	AlbumID    int `sql:"default: null; type:int REFERENCES albums(id) ON DELETE CASCADE"`
+	PlaylistID int `sql:"default: null; type:int REFERENCES playlists(id) ON DELETE CASCADE"`
+}
+
+// NowPlayingEntry is the track a user's client last said it's playing, until the track
+// would have finished
+type NowPlayingEntry struct {
+	User    *User
+	UserID  int    `gorm:"primary_key; not null" sql:"default: null; type:int REFERENCES users(id) ON DELETE CASCADE"`
+	Client  string `gorm:"primary_key; not null"`
+	Track   *Track
+	TrackID int       `gorm:"not null" sql:"default: null; type:int REFERENCES tracks(id) ON DELETE CASCADE"`
+	Time    time.Time `gorm:"not null"`
+	Expires time.Time `gorm:"not null; index"`
+}
+
 type PlayQueue struct {
 	ID        int `gorm:"primary_key"`
 	CreatedAt time.Time
@@ -310,6 +491,20 @@ type AlbumGenre struct {
 	GenreID int `gorm:"not null; unique_index:idx_album_id_genre_id" sql:"default: null; type:int REFERENCES genres(id) ON DELETE CASCADE"`
 }
 
+type TrackArtist struct {
+	Track    *Track
+	TrackID  int `gorm:"not null; unique_index:idx_track_id_artist_id" sql:"default: null; type:int REFERENCES tracks(id) ON DELETE CASCADE"`
+	Artist   *Artist
+	ArtistID int `gorm:"not null; unique_index:idx_track_id_artist_id" sql:"default: null; type:int REFERENCES artists(id) ON DELETE CASCADE"`
+}
+
+type AlbumArtist struct {
+	Album    *Album
+	AlbumID  int `gorm:"not null; unique_index:idx_album_id_artist_id" sql:"default: null; type:int REFERENCES albums(id) ON DELETE CASCADE"`
+	Artist   *Artist
+	ArtistID int `gorm:"not null; unique_index:idx_album_id_artist_id" sql:"default: null; type:int REFERENCES artists(id) ON DELETE CASCADE"`
+}
+
 type AlbumStar struct {
 	UserID   int `gorm:"primary_key; not null" sql:"default: null; type:int REFERENCES users(id) ON DELETE CASCADE"`
 	AlbumID  int `gorm:"primary_key; not null" sql:"default: null; type:int REFERENCES albums(id) ON DELETE CASCADE"`
@@ -445,3 +640,37 @@ type InternetRadioStation struct {
 func (ir *InternetRadioStation) SID() *specid.ID {
 	return &specid.ID{Type: specid.InternetRadioStation, Value: ir.ID}
 }
+
+type ScanType string
+
+const (
+	ScanTypeIncremental ScanType = "incremental"
+	ScanTypeFull        ScanType = "full"
+	ScanTypeWatch       ScanType = "watch"
+)
+
+// Scan is a record of a finished scan, kept for the history on the admin home
+type Scan struct {
+	ID             int `gorm:"primary_key"`
+	Type           ScanType
+	StartedAt      time.Time `gorm:"index"`
+	FinishedAt     time.Time
+	TracksSeen     int
+	TracksNew      int
+	TracksRemoved  int
+	AlbumsRemoved  int
+	ArtistsRemoved int
+	GenresRemoved  int
+	Error          string       `sql:"default: null"` // set if the scan couldn't finish
+	Errors         []*ScanError // errors for individual files and folders
+}
+
+func (s *Scan) Duration() time.Duration {
+	return s.FinishedAt.Sub(s.StartedAt)
+}
+
+type ScanError struct {
+	ID     int `gorm:"primary_key"`
+	ScanID int `gorm:"not null; index" sql:"default: null; type:int REFERENCES scans(id) ON DELETE CASCADE"`
+	Error  string
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+var ErrNoPasswordKey = errors.New("no key to encrypt passwords with")
+
+// PasswordKey encrypts users' passwords in the db. they can't be hashed instead, since
+// the subsonic token auth needs the plain password to check against
+type PasswordKey struct {
+	aead cipher.AEAD
+}
+
+// NewPasswordKey derives a key from a secret of any length
+func NewPasswordKey(secret string) (*PasswordKey, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("empty secret")
+	}
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+	return &PasswordKey{aead: aead}, nil
+}
+
+func (k *PasswordKey) Encrypt(password string) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("make nonce: %w", err)
+	}
+	sealed := k.aead.Seal(nonce, nonce, []byte(password), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *PasswordKey) Decrypt(encrypted string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("decode: %w", err)
+	}
+	if len(sealed) < k.aead.NonceSize() {
+		return "", fmt.Errorf("too short")
+	}
+	nonce, sealed := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	password, err := k.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("open: %w", err)
+	}
+	return string(password), nil
+}
+
+// CheckPasswordKey returns an error if the key isn't the one users' passwords were
+// encrypted with, eg. if it was changed without rotating it
+func (db *DB) CheckPasswordKey(key *PasswordKey) error {
+	var user User
+	err := db.First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("find user: %w", err)
+	}
+	if _, err := key.Decrypt(user.Password); err != nil {
+		return fmt.Errorf("decrypt password of %q: %w", user.Name, err)
+	}
+	return nil
+}
+
+// RotatePasswordKey encrypts every user's password and totp secret with the new key
+// instead of the old one. if any can't be, none are
+func (db *DB) RotatePasswordKey(oldKey, newKey *PasswordKey) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var users []*User
+		if err := tx.Find(&users).Error; err != nil {
+			return fmt.Errorf("find users: %w", err)
+		}
+		for _, user := range users {
+			password, err := oldKey.Decrypt(user.Password)
+			if err != nil {
+				return fmt.Errorf("decrypt password of %q: %w", user.Name, err)
+			}
+			encrypted, err := newKey.Encrypt(password)
+			if err != nil {
+				return fmt.Errorf("encrypt password of %q: %w", user.Name, err)
+			}
+			if err := tx.Model(user).Update("password", encrypted).Error; err != nil {
+				return fmt.Errorf("save password of %q: %w", user.Name, err)
+			}
+			if !user.HasTOTP() {
+				continue
+			}
+			secret, err := oldKey.Decrypt(user.TOTPSecret)
+			if err != nil {
+				return fmt.Errorf("decrypt totp secret of %q: %w", user.Name, err)
+			}
+			if encrypted, err = newKey.Encrypt(secret); err != nil {
+				return fmt.Errorf("encrypt totp secret of %q: %w", user.Name, err)
+			}
+			if err := tx.Model(user).Update("totp_secret", encrypted).Error; err != nil {
+				return fmt.Errorf("save totp secret of %q: %w", user.Name, err)
+			}
+		}
+		return nil
+	})
+}
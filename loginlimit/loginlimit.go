@@ -0,0 +1,196 @@
+// Package loginlimit slows down guessing passwords. after a few failed logins from an
+// address or for a username, each failure locks it out for twice as long as the last
+package loginlimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFreeFailures = 5
+	DefaultBaseLockout  = time.Second
+	DefaultMaxLockout   = 15 * time.Minute
+	DefaultForgetAfter  = time.Hour
+)
+
+type Kind string
+
+const (
+	KindIP       Kind = "ip"
+	KindUsername Kind = "username"
+)
+
+type key struct {
+	kind Kind
+	name string
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Limiter counts failed logins by address and by username. it's safe to use from
+// many requests at once
+type Limiter struct {
+	FreeFailures int           // failures before any lockout
+	BaseLockout  time.Duration // lockout after the first failure past the free ones
+	MaxLockout   time.Duration
+	ForgetAfter  time.Duration // failures are forgotten after this long without another
+
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[key]*entry
+	lastPrune time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{
+		FreeFailures: DefaultFreeFailures,
+		BaseLockout:  DefaultBaseLockout,
+		MaxLockout:   DefaultMaxLockout,
+		ForgetAfter:  DefaultForgetAfter,
+		now:          time.Now,
+		entries:      map[key]*entry{},
+	}
+}
+
+// Wait returns how long until the address and username can try to log in again, rounded
+// up to the second, or 0 if they can now. either can be empty
+func (l *Limiter) Wait(ip, username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var wait time.Duration
+	for _, k := range keys(ip, username) {
+		if e, ok := l.entries[k]; ok && e.lockedUntil.After(now) {
+			if w := e.lockedUntil.Sub(now); w > wait {
+				wait = w
+			}
+		}
+	}
+	if wait%time.Second != 0 {
+		wait = wait.Truncate(time.Second) + time.Second
+	}
+	return wait
+}
+
+// Fail counts a failed login. the username should only be given if the user exists,
+// so that made up names don't fill the limiter
+func (l *Limiter) Fail(ip, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+	for _, k := range keys(ip, username) {
+		e, ok := l.entries[k]
+		if !ok || now.Sub(e.lastFailure) > l.ForgetAfter {
+			e = &entry{}
+			l.entries[k] = e
+		}
+		e.failures++
+		e.lastFailure = now
+		if over := e.failures - l.FreeFailures; over > 0 {
+			e.lockedUntil = now.Add(l.lockout(over))
+		}
+	}
+}
+
+// Succeed forgets the user's failed logins. the address's aren't, so that an attacker
+// can't log in to their own account now and then to keep guessing others
+func (l *Limiter) Succeed(username string) {
+	if username == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key{KindUsername, username})
+}
+
+// Clear forgets the failed logins of an address or username, unlocking it
+func (l *Limiter) Clear(kind Kind, name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key{kind, name})
+}
+
+type Failures struct {
+	Kind        Kind
+	Name        string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time // zero if it isn't locked
+}
+
+// Failures lists the addresses and usernames with recent failed logins, locked ones
+// and those with the most failures first
+func (l *Limiter) Failures() []*Failures {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+	ret := make([]*Failures, 0, len(l.entries))
+	for k, e := range l.entries {
+		if l.forgotten(e, now) {
+			continue
+		}
+		f := &Failures{Kind: k.kind, Name: k.name, Failures: e.failures, LastFailure: e.lastFailure}
+		if e.lockedUntil.After(now) {
+			f.LockedUntil = e.lockedUntil
+		}
+		ret = append(ret, f)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if li, lj := !ret[i].LockedUntil.IsZero(), !ret[j].LockedUntil.IsZero(); li != lj {
+			return li
+		}
+		if ret[i].Failures != ret[j].Failures {
+			return ret[i].Failures > ret[j].Failures
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+func (l *Limiter) lockout(over int) time.Duration {
+	lockout := l.BaseLockout
+	for i := 1; i < over && lockout < l.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > l.MaxLockout {
+		return l.MaxLockout
+	}
+	return lockout
+}
+
+// prune forgets old failures now and then, so that the limiter doesn't grow forever
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for k, e := range l.entries {
+		if l.forgotten(e, now) {
+			delete(l.entries, k)
+		}
+	}
+}
+
+func (l *Limiter) forgotten(e *entry, now time.Time) bool {
+	return now.Sub(e.lastFailure) > l.ForgetAfter && !e.lockedUntil.After(now)
+}
+
+func keys(ip, username string) []key {
+	var ret []key
+	if ip != "" {
+		ret = append(ret, key{KindIP, ip})
+	}
+	if username != "" {
+		ret = append(ret, key{KindUsername, username})
+	}
+	return ret
+}
@@ -0,0 +1,98 @@
+package loginlimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter() (*Limiter, *clock) {
+	c := &clock{t: time.Date(2022, 11, 10, 12, 0, 0, 0, time.UTC)}
+	l := New()
+	l.now = c.now
+	return l, c
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	l, c := newTestLimiter()
+
+	for i := 0; i < l.FreeFailures; i++ {
+		l.Fail("10.0.0.1", "alice")
+		is.Equal(l.Wait("10.0.0.1", "alice"), time.Duration(0))
+	}
+
+	// each failure past the free ones locks for twice as long
+	l.Fail("10.0.0.1", "alice")
+	is.Equal(l.Wait("10.0.0.1", "alice"), 1*time.Second)
+	l.Fail("10.0.0.1", "alice")
+	is.Equal(l.Wait("10.0.0.1", "alice"), 2*time.Second)
+	l.Fail("10.0.0.1", "alice")
+	is.Equal(l.Wait("10.0.0.1", "alice"), 4*time.Second)
+
+	// either the address or the username being locked is enough
+	is.Equal(l.Wait("10.0.0.2", "alice"), 4*time.Second)
+	is.Equal(l.Wait("10.0.0.1", "bob"), 4*time.Second)
+	is.Equal(l.Wait("10.0.0.2", "bob"), time.Duration(0))
+
+	c.advance(4 * time.Second)
+	is.Equal(l.Wait("10.0.0.1", "alice"), time.Duration(0))
+
+	// up to the max
+	for i := 0; i < 20; i++ {
+		l.Fail("10.0.0.1", "alice")
+	}
+	is.Equal(l.Wait("10.0.0.1", "alice"), l.MaxLockout)
+}
+
+func TestSucceedAndClear(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	l, _ := newTestLimiter()
+
+	for i := 0; i < l.FreeFailures+1; i++ {
+		l.Fail("10.0.0.1", "alice")
+	}
+
+	// logging in forgets the username, but not the address
+	l.Succeed("alice")
+	is.Equal(l.Wait("", "alice"), time.Duration(0))
+	is.True(l.Wait("10.0.0.1", "") > 0)
+
+	failures := l.Failures()
+	is.Equal(len(failures), 1)
+	is.Equal(failures[0].Kind, KindIP)
+	is.Equal(failures[0].Name, "10.0.0.1")
+	is.Equal(failures[0].Failures, l.FreeFailures+1)
+	is.True(!failures[0].LockedUntil.IsZero())
+
+	l.Clear(KindIP, "10.0.0.1")
+	is.Equal(l.Wait("10.0.0.1", ""), time.Duration(0))
+	is.Equal(len(l.Failures()), 0)
+}
+
+func TestForget(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	l, c := newTestLimiter()
+
+	for i := 0; i < l.FreeFailures; i++ {
+		l.Fail("10.0.0.1", "")
+	}
+	c.advance(l.ForgetAfter + time.Second)
+
+	// the old failures don't count towards a lockout anymore
+	l.Fail("10.0.0.1", "")
+	is.Equal(l.Wait("10.0.0.1", ""), time.Duration(0))
+	is.Equal(l.Failures()[0].Failures, 1)
+
+	c.advance(l.ForgetAfter + time.Second)
+	is.Equal(len(l.Failures()), 0)
+}
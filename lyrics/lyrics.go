@@ -0,0 +1,130 @@
+// Package lyrics parses plain text and LRC formatted lyrics
+package lyrics
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// https://en.wikipedia.org/wiki/LRC_(file_format)
+
+type Line struct {
+	Start time.Duration
+	Value string
+}
+
+type Lyrics struct {
+	Artist string
+	Title  string
+	Lang   string
+	Offset time.Duration
+	Synced bool
+	Lines  []*Line
+}
+
+var (
+	timeTagExpr = regexp.MustCompile(`^\[(\d+):(\d{1,2}(?:[.:]\d{1,3})?)\]`)
+	idTagExpr   = regexp.MustCompile(`^\[([a-z]+):(.*)\]$`)
+	wordTagExpr = regexp.MustCompile(`<\d+:\d{1,2}(?:[.:]\d{1,3})?>`)
+)
+
+// Parse parses raw lyrics, either plain text or LRC. if any line has a
+// time tag, the lyrics are considered synced and untimed lines are dropped
+func Parse(raw string) *Lyrics {
+	raw = strings.TrimPrefix(raw, "\ufeff")
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+
+	var ret Lyrics
+	var plain []*Line
+	for _, rawLine := range strings.Split(raw, "\n") {
+		rawLine = strings.TrimSpace(rawLine)
+
+		var starts []time.Duration
+		for {
+			match := timeTagExpr.FindStringSubmatch(rawLine)
+			if match == nil {
+				break
+			}
+			starts = append(starts, parseTime(match[1], match[2]))
+			rawLine = rawLine[len(match[0]):]
+		}
+		if len(starts) > 0 {
+			value := strings.TrimSpace(wordTagExpr.ReplaceAllString(rawLine, ""))
+			for _, start := range starts {
+				ret.Lines = append(ret.Lines, &Line{Start: start, Value: value})
+			}
+			continue
+		}
+
+		if match := idTagExpr.FindStringSubmatch(rawLine); match != nil {
+			ret.setIDTag(match[1], strings.TrimSpace(match[2]))
+			continue
+		}
+		plain = append(plain, &Line{Value: rawLine})
+	}
+
+	if len(ret.Lines) > 0 {
+		ret.Synced = true
+		sort.SliceStable(ret.Lines, func(i, j int) bool {
+			return ret.Lines[i].Start < ret.Lines[j].Start
+		})
+		return &ret
+	}
+
+	ret.Lines = trimBlank(plain)
+	return &ret
+}
+
+func (l *Lyrics) setIDTag(key, value string) {
+	switch key {
+	case "ar":
+		l.Artist = value
+	case "ti":
+		l.Title = value
+	case "la", "lang":
+		l.Lang = value
+	case "offset":
+		ms, _ := strconv.Atoi(strings.TrimPrefix(value, "+"))
+		l.Offset = time.Duration(ms) * time.Millisecond
+	}
+}
+
+// Text returns the lyrics without any timing information
+func (l *Lyrics) Text() string {
+	var lines []string
+	for _, line := range l.Lines {
+		lines = append(lines, line.Value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// IsSidecar returns whether the filename has an extension we can read lyrics from
+func IsSidecar(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".lrc", ".txt":
+		return true
+	default:
+		return false
+	}
+}
+
+func parseTime(mins, secs string) time.Duration {
+	m, _ := strconv.Atoi(mins)
+	// some files use mm:ss:xx instead of mm:ss.xx
+	s, _ := strconv.ParseFloat(strings.Replace(secs, ":", ".", 1), 64)
+	return time.Duration(m)*time.Minute + time.Duration(s*float64(time.Second)).Round(time.Millisecond)
+}
+
+func trimBlank(lines []*Line) []*Line {
+	for len(lines) > 0 && lines[0].Value == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1].Value == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
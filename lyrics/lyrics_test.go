@@ -0,0 +1,48 @@
+package lyrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestParseSynced(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	l := Parse("\ufeff[ar:Some Artist]\r\n[ti:Some Title]\r\n[offset:+250]\r\n" +
+		"[00:12.50]first <00:12.80>line\r\n" +
+		"[01:02.3][00:20.00]repeated\r\n" +
+		"not timed\r\n")
+
+	is.True(l.Synced)
+	is.Equal(l.Artist, "Some Artist")
+	is.Equal(l.Title, "Some Title")
+	is.Equal(l.Offset, 250*time.Millisecond)
+	is.Equal(len(l.Lines), 3)
+	is.Equal(*l.Lines[0], Line{Start: 12500 * time.Millisecond, Value: "first line"})
+	is.Equal(*l.Lines[1], Line{Start: 20 * time.Second, Value: "repeated"})
+	is.Equal(*l.Lines[2], Line{Start: time.Minute + 2300*time.Millisecond, Value: "repeated"})
+	is.Equal(l.Text(), "first line\nrepeated\nrepeated")
+}
+
+func TestParsePlain(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	l := Parse("\n\nverse one\n\nverse [two]\n\n")
+
+	is.True(!l.Synced)
+	is.Equal(len(l.Lines), 3)
+	is.Equal(l.Text(), "verse one\n\nverse [two]")
+}
+
+func TestIsSidecar(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	is.True(IsSidecar("01 track.lrc"))
+	is.True(IsSidecar("01 track.TXT"))
+	is.True(!IsSidecar("01 track.flac"))
+}
@@ -15,6 +15,9 @@ var supportedAudioTypes = map[string]string{
 	".ogg":  "audio/ogg",
 	".opus": "audio/ogg",
 	".wma":  "audio/x-ms-wma",
+	".wv":   "audio/x-wavpack",
+	".dsf":  "audio/x-dsf",
+	".mka":  "audio/x-matroska",
 }
 
 //nolint:gochecknoinits
@@ -1,12 +1,14 @@
 package mockfs
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -25,12 +27,20 @@ type MockFS struct {
 	dir       string
 	tagReader *tagReader
 	db        *db.DB
+
+	migrationCtx db.MigrationContext
 }
 
-func New(t testing.TB) *MockFS                        { return new(t, []string{""}) }
-func NewWithDirs(t testing.TB, dirs []string) *MockFS { return new(t, dirs) }
+func New(t testing.TB) *MockFS                        { return new(t, []string{""}, nil, nil) }
+func NewWithDirs(t testing.TB, dirs []string) *MockFS { return new(t, dirs, nil, nil) }
+func NewWithExclude(t testing.TB, exclude *regexp.Regexp) *MockFS {
+	return new(t, []string{""}, exclude, nil)
+}
+func NewWithDiscFolders(t testing.TB, discFolders *regexp.Regexp) *MockFS {
+	return new(t, []string{""}, nil, discFolders)
+}
 
-func new(t testing.TB, dirs []string) *MockFS {
+func new(t testing.TB, dirs []string, exclude, discFolders *regexp.Regexp) *MockFS {
 	dbc, err := db.NewMock()
 	if err != nil {
 		t.Fatalf("create db: %v", err)
@@ -41,7 +51,12 @@ func new(t testing.TB, dirs []string) *MockFS {
 		}
 	})
 
-	if err := dbc.Migrate(db.MigrationContext{}); err != nil {
+	passwordKey, err := db.NewPasswordKey("mock")
+	if err != nil {
+		t.Fatalf("create password key: %v", err)
+	}
+	migrationCtx := db.MigrationContext{PasswordKey: passwordKey}
+	if err := dbc.Migrate(migrationCtx); err != nil {
 		t.Fatalf("migrate db db: %v", err)
 	}
 	dbc.LogMode(false)
@@ -59,19 +74,21 @@ func new(t testing.TB, dirs []string) *MockFS {
 	}
 
 	tagReader := &tagReader{paths: map[string]*tagReaderResult{}}
-	scanner := scanner.New(absDirs, dbc, ";", tagReader)
+	scanner := scanner.New(absDirs, dbc, ";", ";", 4, exclude, discFolders, tagReader, coverReader{})
 
 	return &MockFS{
-		t:         t,
-		scanner:   scanner,
-		dir:       tmpDir,
-		tagReader: tagReader,
-		db:        dbc,
+		t:            t,
+		scanner:      scanner,
+		dir:          tmpDir,
+		tagReader:    tagReader,
+		db:           dbc,
+		migrationCtx: migrationCtx,
 	}
 }
 
-func (m *MockFS) DB() *db.DB     { return m.db }
-func (m *MockFS) TmpDir() string { return m.dir }
+func (m *MockFS) DB() *db.DB                   { return m.db }
+func (m *MockFS) TmpDir() string               { return m.dir }
+func (m *MockFS) PasswordKey() *db.PasswordKey { return m.migrationCtx.PasswordKey }
 
 func (m *MockFS) ScanAndClean() *scanner.Context {
 	ctx, err := m.scanner.ScanAndClean(scanner.ScanOptions{})
@@ -85,6 +102,34 @@ func (m *MockFS) ScanAndCleanErr() (*scanner.Context, error) {
 	return m.scanner.ScanAndClean(scanner.ScanOptions{})
 }
 
+// ScanPaths scans paths relative to the mock fs root, as if reported by the watcher
+func (m *MockFS) ScanPaths(changed, removed []string) *scanner.Context {
+	abs := func(paths []string) []string {
+		var ret []string
+		for _, path := range paths {
+			ret = append(ret, filepath.Join(m.dir, path))
+		}
+		return ret
+	}
+	ctx, err := m.scanner.ScanPaths(abs(changed), abs(removed))
+	if err != nil {
+		m.t.Fatalf("error scanning paths: %v", err)
+	}
+	return ctx
+}
+
+func (m *MockFS) DryRun() *scanner.Changes {
+	changes, err := m.scanner.DryRun(scanner.ScanOptions{}, m.migrationCtx)
+	if err != nil {
+		m.t.Fatalf("error dry running: %v", err)
+	}
+	return changes
+}
+
+func (m *MockFS) Progress() (folders, tracks int) {
+	return m.scanner.Progress()
+}
+
 func (m *MockFS) ResetDates() {
 	t := time.Date(2020, 0, 0, 0, 0, 0, 0, time.UTC)
 	if err := m.db.Model(db.Album{}).Updates(db.Album{CreatedAt: t, UpdatedAt: t, ModifiedAt: t}).Error; err != nil {
@@ -134,6 +179,23 @@ func (m *MockFS) RemoveAll(path string) {
 	}
 }
 
+func (m *MockFS) Rename(src, dest string) {
+	absSrc := filepath.Join(m.dir, src)
+	absDest := filepath.Join(m.dir, dest)
+	if err := os.MkdirAll(filepath.Dir(absDest), os.ModePerm); err != nil {
+		m.t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Rename(absSrc, absDest); err != nil {
+		m.t.Fatalf("rename: %v", err)
+	}
+	for k, v := range m.tagReader.paths {
+		if k == absSrc || strings.HasPrefix(k, absSrc+string(filepath.Separator)) {
+			delete(m.tagReader.paths, k)
+			m.tagReader.paths[absDest+strings.TrimPrefix(k, absSrc)] = v
+		}
+	}
+}
+
 func (m *MockFS) Symlink(src, dest string) {
 	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
 		m.t.Fatalf("mkdir: %v", err)
@@ -240,6 +302,19 @@ func (m *MockFS) LogTrackGenres() {
 	m.t.Logf("total %d", len(tgs))
 }
 
+func (m *MockFS) LogAlbumArtists() {
+	var aas []*db.AlbumArtist
+	if err := m.db.Find(&aas).Error; err != nil {
+		m.t.Fatalf("error logging items: %v", err)
+	}
+
+	m.t.Logf("\nalbum artists")
+	for _, aa := range aas {
+		m.t.Logf("aid %-3d arid %-3d", aa.AlbumID, aa.ArtistID)
+	}
+	m.t.Logf("total %d", len(aas))
+}
+
 func (m *MockFS) AddTrack(path string) {
 	abspath := filepath.Join(m.dir, path)
 	dir := filepath.Dir(abspath)
@@ -253,6 +328,45 @@ func (m *MockFS) AddTrack(path string) {
 	defer f.Close()
 }
 
+func (m *MockFS) AddLyrics(path string, lyrics string) {
+	abspath := filepath.Join(m.dir, path)
+	if err := os.MkdirAll(filepath.Dir(abspath), os.ModePerm); err != nil {
+		m.t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(abspath, []byte(lyrics), 0600); err != nil {
+		m.t.Fatalf("write lyrics: %v", err)
+	}
+}
+
+func (m *MockFS) AddCueSheet(path string, sheet string) {
+	abspath := filepath.Join(m.dir, path)
+	if err := os.MkdirAll(filepath.Dir(abspath), os.ModePerm); err != nil {
+		m.t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(abspath, []byte(sheet), 0600); err != nil {
+		m.t.Fatalf("write cue sheet: %v", err)
+	}
+}
+
+// AddIgnoreFile writes a .gonicignore file in the folder
+func (m *MockFS) AddIgnoreFile(dir string, patterns string) {
+	abspath := filepath.Join(m.dir, dir, ".gonicignore")
+	if err := os.MkdirAll(filepath.Dir(abspath), os.ModePerm); err != nil {
+		m.t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(abspath, []byte(patterns), 0600); err != nil {
+		m.t.Fatalf("write ignore file: %v", err)
+	}
+}
+
+// AddEmbeddedCover writes the track with a cover that the mock cover reader finds
+func (m *MockFS) AddEmbeddedCover(path string) {
+	abspath := filepath.Join(m.dir, path)
+	if err := os.WriteFile(abspath, []byte(embeddedCoverPrefix+"data"), 0600); err != nil {
+		m.t.Fatalf("write embedded cover: %v", err)
+	}
+}
+
 func (m *MockFS) AddCover(path string) {
 	abspath := filepath.Join(m.dir, path)
 	if err := os.MkdirAll(filepath.Dir(abspath), os.ModePerm); err != nil {
@@ -347,6 +461,32 @@ func (m *tagReader) Read(abspath string) (tags.Parser, error) {
 
 var _ tags.Reader = (*tagReader)(nil)
 
+const embeddedCoverPrefix = "embedded cover: "
+
+// coverReader finds the covers written by AddEmbeddedCover
+type coverReader struct{}
+
+func (r coverReader) HasCover(abspath string) (bool, error) {
+	_, err := r.ReadCover(abspath)
+	if errors.Is(err, tags.ErrNoCover) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (coverReader) ReadCover(abspath string) ([]byte, error) {
+	data, err := os.ReadFile(abspath)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(embeddedCoverPrefix)) {
+		return nil, tags.ErrNoCover
+	}
+	return bytes.TrimPrefix(data, []byte(embeddedCoverPrefix)), nil
+}
+
+var _ tags.CoverReader = coverReader{}
+
 type Tags struct {
 	RawTitle       string
 	RawArtist      string
@@ -356,18 +496,59 @@ type Tags struct {
 
 	RawBitrate int
 	RawLength  int
+
+	RawArtists      []string
+	RawAlbumArtists []string
+	RawLyrics       string
+
+	RawReplayGainTrackGain *float32
+	RawReplayGainTrackPeak *float32
+	RawReplayGainAlbumGain *float32
+	RawReplayGainAlbumPeak *float32
+
+	RawComposer     string
+	RawLabel        string
+	RawCompilation  bool
+	RawReleaseType  string
+	RawOriginalYear int
+	RawBPM          int
+	RawComment      string
+
+	RawDiscNumber *int // nil for the default of 1
+}
+
+func (m *Tags) Title() string          { return m.RawTitle }
+func (m *Tags) BrainzID() string       { return "" }
+func (m *Tags) Artist() string         { return m.RawArtist }
+func (m *Tags) Artists() []string      { return m.RawArtists }
+func (m *Tags) Album() string          { return m.RawAlbum }
+func (m *Tags) AlbumArtist() string    { return m.RawAlbumArtist }
+func (m *Tags) AlbumArtists() []string { return m.RawAlbumArtists }
+func (m *Tags) AlbumBrainzID() string  { return "" }
+func (m *Tags) Genre() string          { return m.RawGenre }
+func (m *Tags) TrackNumber() int       { return 1 }
+func (m *Tags) Year() int              { return 2021 }
+func (m *Tags) Lyrics() string         { return m.RawLyrics }
+
+func (m *Tags) DiscNumber() int {
+	if m.RawDiscNumber != nil {
+		return *m.RawDiscNumber
+	}
+	return 1
 }
 
-func (m *Tags) Title() string         { return m.RawTitle }
-func (m *Tags) BrainzID() string      { return "" }
-func (m *Tags) Artist() string        { return m.RawArtist }
-func (m *Tags) Album() string         { return m.RawAlbum }
-func (m *Tags) AlbumArtist() string   { return m.RawAlbumArtist }
-func (m *Tags) AlbumBrainzID() string { return "" }
-func (m *Tags) Genre() string         { return m.RawGenre }
-func (m *Tags) TrackNumber() int      { return 1 }
-func (m *Tags) DiscNumber() int       { return 1 }
-func (m *Tags) Year() int             { return 2021 }
+func (m *Tags) Composer() string    { return m.RawComposer }
+func (m *Tags) Label() string       { return m.RawLabel }
+func (m *Tags) Compilation() bool   { return m.RawCompilation }
+func (m *Tags) ReleaseType() string { return m.RawReleaseType }
+func (m *Tags) OriginalYear() int   { return m.RawOriginalYear }
+func (m *Tags) BPM() int            { return m.RawBPM }
+func (m *Tags) Comment() string     { return m.RawComment }
+
+func (m *Tags) ReplayGainTrackGain() *float32 { return m.RawReplayGainTrackGain }
+func (m *Tags) ReplayGainTrackPeak() *float32 { return m.RawReplayGainTrackPeak }
+func (m *Tags) ReplayGainAlbumGain() *float32 { return m.RawReplayGainAlbumGain }
+func (m *Tags) ReplayGainAlbumPeak() *float32 { return m.RawReplayGainAlbumPeak }
 
 func (m *Tags) Length() int  { return firstInt(100, m.RawLength) }
 func (m *Tags) Bitrate() int { return firstInt(100, m.RawBitrate) }
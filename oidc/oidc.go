@@ -0,0 +1,234 @@
+// Package oidc logs users in to the web interface with an OpenID Connect provider, using
+// the authorization code flow
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v3"
+	"golang.org/x/oauth2"
+)
+
+var ErrInvalidToken = errors.New("invalid id token")
+
+const DefaultUsernameClaim = "preferred_username"
+
+// keysRefetchInterval is how often the provider's keys can be fetched again for a token
+// signed with a key we don't have
+const keysRefetchInterval = time.Minute
+
+type Config struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	UsernameClaim string // defaults to DefaultUsernameClaim
+}
+
+// Identity is who the provider logged in. the subject is the provider's stable id for
+// the account, while the username can often be changed by the user
+type Identity struct {
+	Subject  string
+	Username string
+}
+
+// Provider is an OpenID provider. its endpoints are discovered from the issuer the first
+// time they're needed, so gonic can start while the provider is down
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+}
+
+func New(config Config) *Provider {
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = DefaultUsernameClaim
+	}
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) discover(ctx context.Context) (*oidc.Provider, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider != nil {
+		return p.provider, p.verifier, nil
+	}
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, p.client), p.config.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discover provider: %w", err)
+	}
+	var claims struct {
+		JWKSURI    string   `json:"jwks_uri"`
+		Algorithms []string `json:"id_token_signing_alg_values_supported"`
+	}
+	if err := provider.Claims(&claims); err != nil {
+		return nil, nil, fmt.Errorf("discover provider: %w", err)
+	}
+	keys := &keySet{url: claims.JWKSURI, client: p.client}
+	p.provider = provider
+	p.verifier = oidc.NewVerifier(p.config.Issuer, keys, &oidc.Config{
+		ClientID:             p.config.ClientID,
+		SupportedSigningAlgs: signingAlgs(claims.Algorithms),
+	})
+	return p.provider, p.verifier, nil
+}
+
+func (p *Provider) oauth2Config(provider *oidc.Provider, redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+}
+
+// AuthCodeURL is where to send the user to log in. the provider sends them back to the
+// redirect URL with a code for Exchange, and the state
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURL, state, nonce string) (string, error) {
+	provider, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.oauth2Config(provider, redirectURL).AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+// Exchange trades the code from the provider for an id token, and returns who it's for
+// once it's verified. the username is empty if the token doesn't have the username claim
+func (p *Provider) Exchange(ctx context.Context, redirectURL, code, nonce string) (*Identity, error) {
+	provider, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = oidc.ClientContext(ctx, p.client)
+	token, err := p.oauth2Config(provider, redirectURL).Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, errors.New("token request: no id token")
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, fmt.Errorf("%w: wrong nonce", ErrInvalidToken)
+	}
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	username, _ := claims[p.config.UsernameClaim].(string)
+	return &Identity{
+		Subject:  idToken.Subject,
+		Username: strings.TrimSpace(username),
+	}, nil
+}
+
+// signingAlgs are the algorithms the provider signs id tokens with that we can verify.
+// without any, the verifier expects RS256
+func signingAlgs(algs []string) []string {
+	supported := map[string]struct{}{
+		oidc.RS256: {}, oidc.RS384: {}, oidc.RS512: {},
+		oidc.ES256: {}, oidc.ES384: {}, oidc.ES512: {},
+		oidc.PS256: {}, oidc.PS384: {}, oidc.PS512: {},
+		oidc.EdDSA: {},
+	}
+	var ret []string
+	for _, alg := range algs {
+		if _, ok := supported[alg]; ok {
+			ret = append(ret, alg)
+		}
+	}
+	return ret
+}
+
+// keySet is the provider's signing keys. they're fetched again for a token signed with a
+// key we don't have in case they were rotated, but not more than once a keysRefetchInterval,
+// so that bad tokens can't keep us fetching them
+type keySet struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      []jose.JSONWebKey
+	fetchedAt time.Time
+}
+
+// VerifySignature verifies the token's signature, returning its payload. the verifier
+// has already checked its algorithm and claims
+func (k *keySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("parse token: %w", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, errors.New("token doesn't have one signature")
+	}
+	kid := jws.Signatures[0].Header.KeyID
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if payload, ok := verifyWith(jws, k.keys, kid); ok {
+		return payload, nil
+	}
+	if time.Since(k.fetchedAt) < keysRefetchInterval {
+		return nil, errors.New("no key to verify the token with")
+	}
+	k.fetchedAt = time.Now()
+	if err := k.fetch(ctx); err != nil {
+		return nil, fmt.Errorf("get keys: %w", err)
+	}
+	if payload, ok := verifyWith(jws, k.keys, kid); ok {
+		return payload, nil
+	}
+	return nil, errors.New("no key to verify the token with")
+}
+
+func (k *keySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return fmt.Errorf("make request: %w", err)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("get %q: %w", k.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %q: status %d", k.url, resp.StatusCode)
+	}
+	var keys jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return fmt.Errorf("decode %q: %w", k.url, err)
+	}
+	k.keys = keys.Keys
+	return nil
+}
+
+func verifyWith(jws *jose.JSONWebSignature, keys []jose.JSONWebKey, kid string) ([]byte, bool) {
+	for i := range keys {
+		if kid != "" && keys[i].KeyID != kid {
+			continue
+		}
+		if payload, err := jws.Verify(&keys[i]); err == nil {
+			return payload, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,228 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+const (
+	mockClientID     = "gonic"
+	mockClientSecret = "secret"
+	mockRedirectURL  = "http://gonic.example.com/admin/login_oidc_callback"
+	mockCode         = "the-code"
+)
+
+// mockIssuer is an OpenID provider that hands out id tokens with the claims for
+// mockCode. the claims can be changed, or the token replaced, to make bad tokens
+type mockIssuer struct {
+	*httptest.Server
+	key        *rsa.PrivateKey
+	claims     map[string]interface{}
+	idToken    string
+	keyFetches int32
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	m := &mockIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&m.keyFetches, 1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != mockClientID || secret != mockClientSecret || r.FormValue("code") != mockCode ||
+			r.FormValue("redirect_uri") != mockRedirectURL {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idToken := m.idToken
+		if idToken == "" {
+			idToken = m.sign(t, m.claims)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "the-access-token",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	m.claims = map[string]interface{}{
+		"iss":                m.URL,
+		"aud":                mockClientID,
+		"sub":                "1234",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              "the-nonce",
+		"preferred_username": "alice",
+	}
+	return m
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key-1"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	issuer := newMockIssuer(t)
+	p := New(Config{Issuer: issuer.URL, ClientID: mockClientID, ClientSecret: mockClientSecret})
+
+	authURL, err := p.AuthCodeURL(context.Background(), mockRedirectURL, "the-state", "the-nonce")
+	is.NoErr(err)
+	is.True(strings.HasPrefix(authURL, issuer.URL+"/authorize?"))
+	parsed, err := url.Parse(authURL)
+	is.NoErr(err)
+	is.Equal(parsed.Query().Get("client_id"), mockClientID)
+	is.Equal(parsed.Query().Get("redirect_uri"), mockRedirectURL)
+	is.Equal(parsed.Query().Get("state"), "the-state")
+	is.Equal(parsed.Query().Get("nonce"), "the-nonce")
+	is.Equal(parsed.Query().Get("response_type"), "code")
+}
+
+func TestExchange(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	issuer := newMockIssuer(t)
+	p := New(Config{Issuer: issuer.URL, ClientID: mockClientID, ClientSecret: mockClientSecret})
+	ctx := context.Background()
+
+	identity, err := p.Exchange(ctx, mockRedirectURL, mockCode, "the-nonce")
+	is.NoErr(err)
+	is.Equal(identity.Subject, "1234")
+	is.Equal(identity.Username, "alice")
+
+	_, err = p.Exchange(ctx, mockRedirectURL, "not-the-code", "the-nonce")
+	is.True(err != nil)
+
+	_, err = p.Exchange(ctx, mockRedirectURL, mockCode, "another-nonce")
+	is.True(errors.Is(err, ErrInvalidToken))
+
+	p = New(Config{Issuer: issuer.URL, ClientID: mockClientID, ClientSecret: mockClientSecret, UsernameClaim: "email"})
+	identity, err = p.Exchange(ctx, mockRedirectURL, mockCode, "the-nonce")
+	is.NoErr(err)
+	is.Equal(identity.Subject, "1234")
+	is.Equal(identity.Username, "")
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	with := func(claims map[string]interface{}, key string, value interface{}) map[string]interface{} {
+		ret := map[string]interface{}{}
+		for k, v := range claims {
+			ret[k] = v
+		}
+		ret[key] = value
+		return ret
+	}
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherIssuer := &mockIssuer{key: otherKey}
+
+	cases := []struct {
+		name    string
+		idToken func(m *mockIssuer) string
+		ok      bool
+	}{
+		{"good", func(m *mockIssuer) string { return m.sign(t, m.claims) }, true},
+		{"audience list", func(m *mockIssuer) string { return m.sign(t, with(m.claims, "aud", []string{mockClientID, "other"})) }, true},
+		{"wrong audience", func(m *mockIssuer) string { return m.sign(t, with(m.claims, "aud", "other")) }, false},
+		{"wrong issuer", func(m *mockIssuer) string { return m.sign(t, with(m.claims, "iss", "https://evil.example.com")) }, false},
+		{"expired", func(m *mockIssuer) string {
+			return m.sign(t, with(m.claims, "exp", time.Now().Add(-time.Minute).Unix()))
+		}, false},
+		{"wrong nonce", func(m *mockIssuer) string { return m.sign(t, with(m.claims, "nonce", "another-nonce")) }, false},
+		{"wrong key", func(m *mockIssuer) string { return otherIssuer.sign(t, m.claims) }, false},
+		{"tampered", func(m *mockIssuer) string {
+			good := m.sign(t, m.claims)
+			return good[:strings.LastIndex(good, ".")-2] + "xx" + good[strings.LastIndex(good, "."):]
+		}, false},
+		{"malformed", func(m *mockIssuer) string { return "not a token" }, false},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			issuer := newMockIssuer(t)
+			issuer.idToken = tc.idToken(issuer)
+			p := New(Config{Issuer: issuer.URL, ClientID: mockClientID, ClientSecret: mockClientSecret})
+
+			_, err := p.Exchange(context.Background(), mockRedirectURL, mockCode, "the-nonce")
+			if tc.ok && err != nil {
+				t.Fatalf("expected token to verify, got %v", err)
+			}
+			if !tc.ok && !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("expected invalid token, got %v", err)
+			}
+		})
+	}
+}
+
+func TestKeysRefetchThrottled(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	issuer := newMockIssuer(t)
+	p := New(Config{Issuer: issuer.URL, ClientID: mockClientID, ClientSecret: mockClientSecret})
+	ctx := context.Background()
+
+	_, err := p.Exchange(ctx, mockRedirectURL, mockCode, "the-nonce")
+	is.NoErr(err)
+	is.Equal(atomic.LoadInt32(&issuer.keyFetches), int32(1))
+
+	// tokens signed with keys we don't have can't make us fetch the keys again each time
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	issuer.idToken = (&mockIssuer{key: otherKey}).sign(t, issuer.claims)
+	for i := 0; i < 5; i++ {
+		_, err := p.Exchange(ctx, mockRedirectURL, mockCode, "the-nonce")
+		is.True(errors.Is(err, ErrInvalidToken))
+	}
+	is.Equal(atomic.LoadInt32(&issuer.keyFetches), int32(1))
+}
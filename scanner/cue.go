@@ -0,0 +1,181 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.senan.xyz/gonic/cue"
+	"go.senan.xyz/gonic/scanner/tags"
+)
+
+// cueTrack is one of the tracks in a single audio file, as described by a cue sheet
+type cueTrack struct {
+	sheet   *cue.Sheet
+	track   *cue.Track
+	end     time.Duration // 0 if the track plays to the end of the file
+	modTime time.Time     // of the cue sheet, so that editing it updates the track
+}
+
+// readCueSheets parses the cue sheets in a folder, returning a map of the audio files
+// they describe to their tracks. if more than one sheet describes a file, the first wins
+func readCueSheets(dirPath string, sheets []string, audioFiles []string) (map[string][]*cueTrack, error) {
+	ret := map[string][]*cueTrack{}
+	for _, name := range sheets {
+		sheetPath := filepath.Join(dirPath, name)
+		stat, err := os.Stat(sheetPath)
+		if err != nil {
+			return nil, fmt.Errorf("stating cue sheet %q: %w", name, err)
+		}
+		data, err := os.ReadFile(sheetPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading cue sheet %q: %w", name, err)
+		}
+		sheet, err := cue.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing cue sheet %q: %w", name, err)
+		}
+		for _, file := range sheet.Files {
+			basename := matchCueFile(file.Name, audioFiles)
+			if basename == "" {
+				continue
+			}
+			if _, ok := ret[basename]; ok {
+				continue
+			}
+			// a file that's the whole of its only track, like in a one file per track rip,
+			// is a normal track that can be served as is
+			if len(file.Tracks) == 1 && file.Tracks[0].Start == 0 {
+				continue
+			}
+			renumberCueTracks(file.Tracks)
+			for i, track := range file.Tracks {
+				var end time.Duration
+				if i+1 < len(file.Tracks) {
+					end = file.Tracks[i+1].Start
+				}
+				ret[basename] = append(ret[basename], &cueTrack{sheet: sheet, track: track, end: end, modTime: stat.ModTime()})
+			}
+		}
+	}
+	return ret, nil
+}
+
+// renumberCueTracks numbers the tracks by their position in the file if any number is
+// missing, 0, or repeated, since the number identifies the track in the file, and 0 is
+// the whole file
+func renumberCueTracks(tracks []*cue.Track) {
+	seen := map[int]struct{}{}
+	for _, track := range tracks {
+		if _, ok := seen[track.Number]; ok || track.Number <= 0 {
+			for i, track := range tracks {
+				track.Number = i + 1
+			}
+			return
+		}
+		seen[track.Number] = struct{}{}
+	}
+}
+
+// matchCueFile finds the audio file a cue sheet's FILE refers to. sheets are often
+// written for the original rip and then kept after transcoding, so if there's no
+// exact match, a file with the same name but a different extension is used
+func matchCueFile(name string, audioFiles []string) string {
+	name = filepath.Base(filepath.FromSlash(strings.ReplaceAll(name, `\`, "/")))
+	for _, basename := range audioFiles {
+		if basename == name {
+			return basename
+		}
+	}
+	for _, basename := range audioFiles {
+		if strings.EqualFold(basename, name) {
+			return basename
+		}
+	}
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, basename := range audioFiles {
+		if strings.EqualFold(strings.TrimSuffix(basename, filepath.Ext(basename)), stem) {
+			return basename
+		}
+	}
+	return ""
+}
+
+// cueTags are the tags of a single audio file, overridden with what the cue sheet
+// says about one of its tracks
+type cueTags struct {
+	tags.Parser
+	cue *cueTrack
+}
+
+func (t *cueTags) Title() string    { return t.cue.track.Title }
+func (t *cueTags) BrainzID() string { return "" }
+func (t *cueTags) Artist() string {
+	return firstOf(t.cue.track.Performer, t.cue.sheet.Performer, t.Parser.Artist())
+}
+func (t *cueTags) Artists() []string {
+	if t.cue.track.Performer != "" || t.cue.sheet.Performer != "" {
+		return nil
+	}
+	return t.Parser.Artists()
+}
+func (t *cueTags) Album() string { return firstOf(t.cue.sheet.Title, t.Parser.Album()) }
+func (t *cueTags) AlbumArtist() string {
+	return firstOf(t.cue.sheet.Performer, t.Parser.AlbumArtist())
+}
+func (t *cueTags) AlbumArtists() []string {
+	if t.cue.sheet.Performer != "" {
+		return nil
+	}
+	return t.Parser.AlbumArtists()
+}
+func (t *cueTags) Genre() string    { return firstOf(t.cue.sheet.Genre, t.Parser.Genre()) }
+func (t *cueTags) TrackNumber() int { return t.cue.track.Number }
+func (t *cueTags) DiscNumber() int {
+	if t.cue.sheet.DiscNumber != 0 {
+		return t.cue.sheet.DiscNumber
+	}
+	return t.Parser.DiscNumber()
+}
+func (t *cueTags) Year() int {
+	if year := t.cue.sheet.Year(); year != 0 {
+		return year
+	}
+	return t.Parser.Year()
+}
+func (t *cueTags) Length() int {
+	end := t.cue.end
+	if end == 0 {
+		end = time.Duration(t.Parser.Length()) * time.Second
+	}
+	if length := end - t.cue.track.Start; length > 0 {
+		return int(length.Round(time.Second) / time.Second)
+	}
+	return 0
+}
+
+// the lyrics of the whole file won't be right for any single track
+func (t *cueTags) Lyrics() string { return "" }
+
+// nor will its track gain, which is really the gain of all of its tracks. the album gain
+// is still right
+func (t *cueTags) ReplayGainTrackGain() *float32 { return nil }
+func (t *cueTags) ReplayGainTrackPeak() *float32 { return nil }
+
+func (t *cueTags) SomeAlbum() string  { return firstOf(t.Album(), "Unknown Album") }
+func (t *cueTags) SomeArtist() string { return firstOf(t.Artist(), "Unknown Artist") }
+func (t *cueTags) SomeAlbumArtist() string {
+	return firstOf(t.AlbumArtist(), t.Artist(), "Unknown Artist")
+}
+func (t *cueTags) SomeGenre() string { return firstOf(t.Genre(), "Unknown Genre") }
+
+func firstOf(strs ...string) string {
+	for _, str := range strs {
+		if str != "" {
+			return str
+		}
+	}
+	return ""
+}
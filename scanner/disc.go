@@ -0,0 +1,106 @@
+package scanner
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/scanner/tags"
+)
+
+var discNumberExpr = regexp.MustCompile(`\d+`)
+
+// discFolder returns whether the folder is one disc of an album split into subfolders,
+// eg. "Album/CD1", and the disc number from its name if it has one. the tracks of disc
+// folders belong to the album of the folder above, with filenames relative to it
+func (s *Scanner) discFolder(relPath string) (isDisc bool, number int) {
+	if s.discFolders == nil {
+		return false, 0
+	}
+	dir, basename := filepath.Split(relPath)
+	if dir == "" || !s.discFolders.MatchString(basename) {
+		return false, 0
+	}
+	if numbers := discNumberExpr.FindAllString(basename, -1); len(numbers) > 0 {
+		number, _ = strconv.Atoi(numbers[len(numbers)-1])
+	}
+	return true, number
+}
+
+// clearAlbumTags removes a disc folder from the tag based views, in case it was
+// scanned as an album of its own before
+func clearAlbumTags(tx *db.DB, album *db.Album) error {
+	if album.TagArtistID == 0 {
+		return nil
+	}
+	if err := tx.Model(album).Update("tag_artist_id", nil).Error; err != nil {
+		return fmt.Errorf("clear tag artist: %w", err)
+	}
+	for _, table := range []string{"album_artists", "album_genres"} {
+		if err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE album_id=?", table), album.ID).Error; err != nil {
+			return fmt.Errorf("delete %s: %w", table, err)
+		}
+	}
+	album.TagArtistID = 0
+	return nil
+}
+
+// firstDisc returns whether the disc folder is the album's first, by disc number and then by
+// name, so that the album's tags come from the same disc however many of them are scanned,
+// and in whatever order
+func firstDisc(tx *db.DB, album *db.Album, disc disc, number int) (bool, error) {
+	if album.TagArtistID == 0 {
+		return true, nil
+	}
+	var earlier int
+	err := tx.
+		Model(db.Track{}).
+		Where("album_id=? AND substr(filename, 1, ?)<>?", album.ID, utf8.RuneCountInString(disc.prefix), disc.prefix).
+		Where("tag_disc_number<? OR (tag_disc_number=? AND filename<?)", number, number, disc.prefix).
+		Count(&earlier).
+		Error
+	if err != nil {
+		return false, fmt.Errorf("count earlier discs: %w", err)
+	}
+	return earlier == 0, nil
+}
+
+// disc is where the tracks of a folder go if it's a disc folder
+type disc struct {
+	prefix string // the folder's name and a slash, or "" if it's not a disc folder
+	number int
+}
+
+// discTags are the tags of a track in a disc folder, with the disc number from the
+// folder's name if the tags don't have one
+type discTags struct {
+	tags.Parser
+	number int
+}
+
+func (t *discTags) DiscNumber() int {
+	if number := t.Parser.DiscNumber(); number != 0 {
+		return number
+	}
+	return t.number
+}
+
+// removedDiscTracks finds the tracks at or under a removed path that belong to the album
+// above their disc folder, whether the path is a disc folder or a track in one
+func (s *Scanner) removedDiscTracks(musicDir, relPath string) ([]*db.Track, error) {
+	dir, basename := filepath.Dir(relPath), filepath.Base(relPath)
+	prefix := filepath.ToSlash(basename) + "/"
+	discDir, discBasename := filepath.Dir(dir), filepath.Base(dir)
+	var tracks []*db.Track
+	err := s.db.
+		Preload("Album").
+		Joins("JOIN albums ON albums.id=tracks.album_id").
+		Where("albums.root_dir=? AND ((albums.left_path || albums.right_path=? AND substr(tracks.filename, 1, ?)=?) OR (albums.left_path || albums.right_path=? AND tracks.filename=?))",
+			musicDir, dir, utf8.RuneCountInString(prefix), prefix, discDir, discBasename+"/"+basename).
+		Find(&tracks).
+		Error
+	return tracks, err
+}
@@ -0,0 +1,188 @@
+package scanner
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.senan.xyz/gonic/db"
+)
+
+// Changes are what a scan would add to, update in, and remove from the db
+type Changes struct {
+	Albums  *ChangeSet `json:"albums"`
+	Tracks  *ChangeSet `json:"tracks"`
+	Artists *ChangeSet `json:"artists"`
+	Genres  *ChangeSet `json:"genres"`
+	Errors  []string   `json:"errors"`
+}
+
+// ChangeSet lists the paths of the albums and tracks that would change, or the names
+// of the artists and genres
+type ChangeSet struct {
+	Added   []string `json:"added"`
+	Updated []string `json:"updated"`
+	Removed []string `json:"removed"`
+}
+
+// DryRun scans into a copy of the db, and reports what the scan would change without
+// touching the db itself. tag read errors and the like are reported in the changes. the
+// copy is migrated with the context the db itself would be
+func (s *Scanner) DryRun(opts ScanOptions, migrationCtx db.MigrationContext) (*Changes, error) {
+	tmp, err := os.MkdirTemp("", "gonic-dry-run-")
+	if err != nil {
+		return nil, fmt.Errorf("make temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	copyPath := filepath.Join(tmp, "gonic.db")
+	if err := s.db.Exec("VACUUM INTO ?", copyPath).Error; err != nil {
+		return nil, fmt.Errorf("copy db: %w", err)
+	}
+	dbc, err := db.New(copyPath, db.DefaultOptions())
+	if err != nil {
+		return nil, fmt.Errorf("open db copy: %w", err)
+	}
+	defer dbc.Close()
+	dbc.LogMode(false)
+
+	// the db itself is left alone, so it may still need to be migrated for this version
+	if err := dbc.Migrate(migrationCtx); err != nil {
+		return nil, fmt.Errorf("migrate db copy: %w", err)
+	}
+
+	dry := New(s.musicDirs, dbc, s.genreSplit, s.artistSplit, s.workers, s.exclude, s.discFolders, s.tagger, s.covers)
+	c, err := dry.ScanAndClean(opts)
+	if c == nil {
+		return nil, err
+	}
+
+	changes := &Changes{Errors: []string{}}
+	for _, err := range c.errs.Errors() {
+		changes.Errors = append(changes.Errors, err.Error())
+	}
+	if changes.Albums, changes.Tracks, err = diffAlbumsTracks(s.db, dbc); err != nil {
+		return nil, fmt.Errorf("diff albums and tracks: %w", err)
+	}
+	if changes.Artists, err = diffNames(s.db, dbc, "artists"); err != nil {
+		return nil, fmt.Errorf("diff artists: %w", err)
+	}
+	if changes.Genres, err = diffNames(s.db, dbc, "genres"); err != nil {
+		return nil, fmt.Errorf("diff genres: %w", err)
+	}
+	return changes, nil
+}
+
+func diffAlbumsTracks(before, after *db.DB) (albums, tracks *ChangeSet, err error) {
+	albumsBefore, err := tableRows(before, "albums")
+	if err != nil {
+		return nil, nil, err
+	}
+	albumsAfter, err := tableRows(after, "albums")
+	if err != nil {
+		return nil, nil, err
+	}
+	tracksBefore, err := tableRows(before, "tracks")
+	if err != nil {
+		return nil, nil, err
+	}
+	tracksAfter, err := tableRows(after, "tracks")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	albumPath := func(row map[string]string) string {
+		return path.Join(row["root_dir"], row["left_path"], row["right_path"])
+	}
+	trackPath := func(row map[string]string) string {
+		album, ok := albumsAfter[row["album_id"]]
+		if !ok {
+			album = albumsBefore[row["album_id"]]
+		}
+		p := path.Join(albumPath(album), row["filename"])
+		if cueTrack := row["cue_track"]; cueTrack != "" && cueTrack != "0" {
+			p = fmt.Sprintf("%s (cue track %s)", p, cueTrack)
+		}
+		return p
+	}
+	return diffRows(albumsBefore, albumsAfter, albumPath), diffRows(tracksBefore, tracksAfter, trackPath), nil
+}
+
+func diffNames(before, after *db.DB, table string) (*ChangeSet, error) {
+	rowsBefore, err := tableRows(before, table)
+	if err != nil {
+		return nil, err
+	}
+	rowsAfter, err := tableRows(after, table)
+	if err != nil {
+		return nil, err
+	}
+	name := func(row map[string]string) string { return row["name"] }
+	return diffRows(rowsBefore, rowsAfter, name), nil
+}
+
+// diffRows compares rows by ID. a row is updated if any column it had before has
+// changed, other than the time it was last written
+func diffRows(before, after map[string]map[string]string, label func(map[string]string) string) *ChangeSet {
+	ret := ChangeSet{Added: []string{}, Updated: []string{}, Removed: []string{}}
+	for id, row := range after {
+		prev, ok := before[id]
+		if !ok {
+			ret.Added = append(ret.Added, label(row))
+			continue
+		}
+		for col, value := range prev {
+			if col != "updated_at" && row[col] != value {
+				ret.Updated = append(ret.Updated, label(row))
+				break
+			}
+		}
+	}
+	for id, row := range before {
+		if _, ok := after[id]; !ok {
+			ret.Removed = append(ret.Removed, label(row))
+		}
+	}
+	sort.Strings(ret.Added)
+	sort.Strings(ret.Updated)
+	sort.Strings(ret.Removed)
+	return &ret
+}
+
+// tableRows reads a whole table as text, keyed by ID. a db that was never migrated
+// has no tables, and so no rows
+func tableRows(dbc *db.DB, table string) (map[string]map[string]string, error) {
+	ret := map[string]map[string]string{}
+	if !dbc.HasTable(table) {
+		return ret, nil
+	}
+	rows, err := dbc.Raw(fmt.Sprintf("SELECT * FROM %s", table)).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("select %s: %w", table, err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("columns of %s: %w", table, err)
+	}
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("scan %s: %w", table, err)
+		}
+		row := make(map[string]string, len(cols))
+		for i, col := range cols {
+			row[strings.ToLower(col)] = values[i].String
+		}
+		ret[row["id"]] = row
+	}
+	return ret, rows.Err()
+}
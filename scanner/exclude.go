@@ -0,0 +1,105 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.senan.xyz/gonic/scanner/ignore"
+)
+
+// ignoreFilename is the name of the files with gitignore style patterns of what not
+// to scan in the folder they're in and below
+const ignoreFilename = ".gonicignore"
+
+// excludeGlobPrefix marks a -scan-exclude pattern as globs rather than a regular expression
+const excludeGlobPrefix = "glob:"
+
+// ParseExclude parses a pattern of paths to skip while scanning, relative to the music
+// dir. it's a regular expression, eg. `@eaDir|\.stfolder`, or with a "glob:" prefix, comma
+// separated globs like in a .gonicignore file, eg. "glob:@eaDir,*.m3u,/Scans". globs can't
+// be negated or only match folders
+func ParseExclude(pattern string) (*regexp.Regexp, error) {
+	if !strings.HasPrefix(pattern, excludeGlobPrefix) {
+		return regexp.Compile(pattern)
+	}
+	var exprs []string
+	for _, glob := range strings.Split(strings.TrimPrefix(pattern, excludeGlobPrefix), ",") {
+		if glob = strings.TrimSpace(glob); glob == "" {
+			continue
+		}
+		if strings.HasPrefix(glob, "!") || strings.HasSuffix(glob, "/") {
+			return nil, fmt.Errorf("glob %q: can't be negated or only match folders", glob)
+		}
+		expr, err := ignore.Expr(glob)
+		if err != nil {
+			return nil, fmt.Errorf("glob %q: %w", glob, err)
+		}
+		exprs = append(exprs, expr.String())
+	}
+	if len(exprs) == 0 {
+		return nil, errors.New("no globs")
+	}
+	return regexp.Compile(strings.Join(exprs, "|"))
+}
+
+// excluder decides which paths in the music dirs are skipped. it caches the ignore
+// files it reads, so should only live as long as a single scan or walk
+type excluder struct {
+	expr  *regexp.Regexp // the global pattern, may be nil
+	mu    sync.Mutex
+	lists map[string]*ignore.List // nil for folders without an ignore file
+}
+
+func (s *Scanner) newExcluder() *excluder {
+	return &excluder{expr: s.exclude, lists: map[string]*ignore.List{}}
+}
+
+// excluded returns whether the path should be skipped. it's checked along with every
+// folder it's in, since anything in an excluded folder is excluded too
+func (e *excluder) excluded(musicDir, absPath string, isDir bool) bool {
+	relPath, err := filepath.Rel(musicDir, absPath)
+	if err != nil || relPath == "." || strings.HasPrefix(relPath, "..") {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(relPath), "/")
+	for i := range parts {
+		partIsDir := isDir || i < len(parts)-1
+		if e.expr != nil && e.expr.MatchString(strings.Join(parts[:i+1], "/")) {
+			return true
+		}
+		// the ignore file in each folder above can match, the deepest having the last word
+		var ignored bool
+		dir := musicDir
+		for j := 0; j <= i; j++ {
+			if list := e.list(dir); list != nil {
+				if matched, ign := list.Match(strings.Join(parts[j:i+1], "/"), partIsDir); matched {
+					ignored = ign
+				}
+			}
+			dir = filepath.Join(dir, parts[j])
+		}
+		if ignored {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *excluder) list(dir string) *ignore.List {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if list, ok := e.lists[dir]; ok {
+		return list
+	}
+	var list *ignore.List
+	if data, err := os.ReadFile(filepath.Join(dir, ignoreFilename)); err == nil {
+		list = ignore.Parse(string(data))
+	}
+	e.lists[dir] = list
+	return list
+}
@@ -0,0 +1,129 @@
+// Package ignore matches paths against patterns in gitignore syntax
+package ignore
+
+import (
+	"regexp"
+	"strings"
+)
+
+// https://git-scm.com/docs/gitignore#_pattern_format
+
+type rule struct {
+	expr    *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// List is the rules from a single ignore file
+type List struct {
+	rules []*rule
+}
+
+// Parse parses the contents of an ignore file. lines that can't be
+// understood are skipped
+func Parse(raw string) *List {
+	raw = strings.TrimPrefix(raw, "\ufeff")
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+
+	var ret List
+	for _, line := range strings.Split(raw, "\n") {
+		if rule := parseRule(line); rule != nil {
+			ret.rules = append(ret.rules, rule)
+		}
+	}
+	return &ret
+}
+
+// Match reports whether any rule matches the path, and if so, whether the last
+// to match ignores it or re-includes it with a negated pattern. relPath is
+// slash separated and relative to the ignore file's directory
+func (l *List) Match(relPath string, isDir bool) (matched, ignored bool) {
+	for _, rule := range l.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.expr.MatchString(relPath) {
+			matched, ignored = true, !rule.negate
+		}
+	}
+	return matched, ignored
+}
+
+func parseRule(line string) *rule {
+	if !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " \t")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	var ret rule
+	if strings.HasPrefix(line, "!") {
+		ret.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		ret.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+
+	expr, err := Expr(line)
+	if err != nil {
+		return nil
+	}
+	ret.expr = expr
+	return &ret
+}
+
+// Expr compiles a single pattern to a regular expression matching slash separated paths.
+// it's the pattern without a leading "!" or a trailing "/", which a List handles
+func Expr(pattern string) (*regexp.Regexp, error) {
+	// a pattern with a slash anywhere but the end is relative to the ignore file,
+	// otherwise it can match at any depth
+	prefix := "^(?:.*/)?"
+	if strings.Contains(pattern, "/") {
+		prefix = "^"
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	return regexp.Compile(prefix + translate(pattern) + "$")
+}
+
+// translate converts a glob to a regular expression
+func translate(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**") && i+2 == len(glob) && (i == 0 || glob[i-1] == '/'):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			b.WriteString(regexp.QuoteMeta(glob[i+1 : i+2]))
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package ignore
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	list := Parse("# thumbnails\n" +
+		"@eaDir/\n" +
+		"*.m3u\n" +
+		"!keep.m3u\n" +
+		"/Scans\n" +
+		"artwork/**\n" +
+		"**/live/*.flac\n" +
+		"disc [0-9]\n" +
+		`\#hash` + "\n")
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		matched bool
+		ignored bool
+	}{
+		{"@eaDir", true, true, true},
+		{"album/@eaDir", true, true, true},
+		{"@eaDir", false, false, false}, // only dirs
+		{"album/list.m3u", false, true, true},
+		{"album/keep.m3u", false, true, false}, // negated
+		{"Scans", true, true, true},
+		{"album/Scans", true, false, false}, // anchored to the ignore file
+		{"artwork/front.jpg", false, true, true},
+		{"artwork", true, false, false},
+		{"a/b/live/track.flac", false, true, true},
+		{"live/track.flac", false, true, true},
+		{"live/track.mp3", false, false, false},
+		{"disc 1", true, true, true},
+		{"disc a", true, false, false},
+		{"#hash", false, true, true},
+		{"album/track.flac", false, false, false},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.path, func(t *testing.T) {
+			t.Parallel()
+			is := is.New(t)
+
+			matched, ignored := list.Match(tc.path, tc.isDir)
+			is.Equal(matched, tc.matched)
+			is.Equal(ignored, tc.ignored)
+		})
+	}
+}
@@ -7,21 +7,27 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/jinzhu/gorm"
 	"github.com/rainycape/unidecode"
 
+	"go.senan.xyz/gonic/cue"
 	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/lyrics"
 	"go.senan.xyz/gonic/mime"
 	"go.senan.xyz/gonic/multierr"
 	"go.senan.xyz/gonic/scanner/tags"
+	"go.senan.xyz/gonic/server/ctrlsubsonic/specid"
 )
 
 var (
@@ -30,25 +36,40 @@ var (
 )
 
 type Scanner struct {
-	db         *db.DB
-	musicDirs  []string
-	genreSplit string
-	tagger     tags.Reader
-	scanning   *int32
-	watcher    *fsnotify.Watcher
-	watchMap   map[string]string // maps watched dirs back to root music dir
-	watchDone  chan bool
+	db          *db.DB
+	musicDirs   []string
+	genreSplit  string
+	artistSplit string
+	workers     int
+	exclude     *regexp.Regexp
+	discFolders *regexp.Regexp // names of folders to fold into their parent as discs, may be nil
+	tagger      tags.Reader
+	covers      tags.CoverReader
+	scanning    *int32
+	progress    *progress
+	watcher     *fsnotify.Watcher
+	watchMap    map[string]string // maps watched dirs back to root music dir
+	watchDone   chan bool
 }
 
-func New(musicDirs []string, db *db.DB, genreSplit string, tagger tags.Reader) *Scanner {
+func New(musicDirs []string, db *db.DB, genreSplit, artistSplit string, workers int, exclude, discFolders *regexp.Regexp, tagger tags.Reader, covers tags.CoverReader) *Scanner {
+	if workers < 1 {
+		workers = 1
+	}
 	return &Scanner{
-		db:         db,
-		musicDirs:  musicDirs,
-		genreSplit: genreSplit,
-		tagger:     tagger,
-		scanning:   new(int32),
-		watchMap:   make(map[string]string),
-		watchDone:  make(chan bool),
+		db:          db,
+		musicDirs:   musicDirs,
+		genreSplit:  genreSplit,
+		artistSplit: artistSplit,
+		workers:     workers,
+		exclude:     exclude,
+		discFolders: discFolders,
+		tagger:      tagger,
+		covers:      covers,
+		scanning:    new(int32),
+		progress:    &progress{},
+		watchMap:    make(map[string]string),
+		watchDone:   make(chan bool),
 	}
 }
 
@@ -56,12 +77,22 @@ func (s *Scanner) IsScanning() bool {
 	return atomic.LoadInt32(s.scanning) == 1
 }
 func (s *Scanner) StartScanning() bool {
-	return atomic.CompareAndSwapInt32(s.scanning, 0, 1)
+	if !atomic.CompareAndSwapInt32(s.scanning, 0, 1) {
+		return false
+	}
+	s.progress.reset()
+	return true
 }
 func (s *Scanner) StopScanning() {
 	defer atomic.StoreInt32(s.scanning, 0)
 }
 
+// Progress returns the number of folders and tracks the current scan has been
+// through so far
+func (s *Scanner) Progress() (folders, tracks int) {
+	return s.progress.get()
+}
+
 type ScanOptions struct {
 	IsFull bool
 }
@@ -72,44 +103,75 @@ func (s *Scanner) ScanAndClean(opts ScanOptions) (*Context, error) {
 	}
 	defer s.StopScanning()
 
-	start := time.Now()
-	c := &Context{
-		errs:       &multierr.Err{},
-		seenTracks: map[int]struct{}{},
-		seenAlbums: map[int]struct{}{},
-		isFull:     opts.IsFull,
-	}
+	c := newContext(opts.IsFull)
 
 	log.Println("starting scan")
 	defer func() {
 		log.Printf("finished scan in %s, +%d/%d tracks (%d err)\n",
-			durSince(start), c.SeenTracksNew(), c.SeenTracks(), c.errs.Len())
+			durSince(c.start), c.SeenTracksNew(), c.SeenTracks(), c.errs.Len())
 	}()
 
+	scanType := db.ScanTypeIncremental
+	if opts.IsFull {
+		scanType = db.ScanTypeFull
+	}
+	err := s.scanAndClean(c)
+	s.saveScan(c, scanType, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.SetSetting("last_scan_time", strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
+		return nil, fmt.Errorf("set scan time: %w", err)
+	}
+
+	if c.errs.Len() > 0 {
+		return c, c.errs
+	}
+
+	return c, nil
+}
+
+func (s *Scanner) scanAndClean(c *Context) error {
+	var roots []scanRoot
 	for _, dir := range s.musicDirs {
-		err := filepath.WalkDir(dir, func(absPath string, d fs.DirEntry, err error) error {
-			return s.scanCallback(c, dir, absPath, d, err)
-		})
-		if err != nil {
-			return nil, fmt.Errorf("walk: %w", err)
-		}
+		roots = append(roots, scanRoot{musicDir: dir, dir: dir})
+	}
+	// anything excluded isn't seen, so is cleaned like it's no longer on disk
+	if err := s.scanDirs(c, s.newExcluder(), roots); err != nil {
+		return err
 	}
 
 	if err := s.cleanTracks(c); err != nil {
-		return nil, fmt.Errorf("clean tracks: %w", err)
+		return fmt.Errorf("clean tracks: %w", err)
 	}
 	if err := s.cleanAlbums(c); err != nil {
-		return nil, fmt.Errorf("clean albums: %w", err)
+		return fmt.Errorf("clean albums: %w", err)
 	}
 	if err := s.cleanArtists(c); err != nil {
-		return nil, fmt.Errorf("clean artists: %w", err)
+		return fmt.Errorf("clean artists: %w", err)
 	}
 	if err := s.cleanGenres(c); err != nil {
-		return nil, fmt.Errorf("clean genres: %w", err)
+		return fmt.Errorf("clean genres: %w", err)
 	}
+	return nil
+}
 
-	if err := s.db.SetSetting("last_scan_time", strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
-		return nil, fmt.Errorf("set scan time: %w", err)
+// ScanPaths is an incremental scan of only the paths reported by the watcher. changed
+// folders are scanned as usual, and whatever was at a removed path that's no longer on
+// disk is cleaned. albums and tracks that were moved from a removed path to a changed
+// one keep their stars, ratings, plays, bookmarks, and playlist entries
+func (s *Scanner) ScanPaths(changed, removed []string) (*Context, error) {
+	if !s.StartScanning() {
+		return nil, ErrAlreadyScanning
+	}
+	defer s.StopScanning()
+
+	c := newContext(false)
+	err := s.scanPaths(c, changed, removed)
+	s.saveScan(c, db.ScanTypeWatch, err)
+	if err != nil {
+		return nil, err
 	}
 
 	if c.errs.Len() > 0 {
@@ -119,6 +181,79 @@ func (s *Scanner) ScanAndClean(opts ScanOptions) (*Context, error) {
 	return c, nil
 }
 
+func (s *Scanner) scanPaths(c *Context, changed, removed []string) error {
+	// tracks created by this scan are the candidates for moved tracks
+	var maxTrackID int
+	if err := s.db.Model(&db.Track{}).Select("coalesce(max(id), 0)").Row().Scan(&maxTrackID); err != nil {
+		return fmt.Errorf("find max track id: %w", err)
+	}
+
+	ex := s.newExcluder()
+	var roots []scanRoot
+	for _, absPath := range changed {
+		musicDir := s.musicDirFor(absPath)
+		if musicDir == "" {
+			continue
+		}
+		if _, err := os.Stat(absPath); err != nil {
+			continue // gone again since, we'll hear about it in removed
+		}
+		roots = append(roots, scanRoot{musicDir: musicDir, dir: absPath})
+	}
+	if err := s.scanDirs(c, ex, roots); err != nil {
+		return err
+	}
+
+	// an ignore file may have been added or changed, so whatever it now excludes goes too
+	excluded, err := s.excludedUnder(ex, roots)
+	if err != nil {
+		return fmt.Errorf("find excluded: %w", err)
+	}
+	removed = append(removed, excluded...)
+
+	if err := s.cleanRemoved(c, ex, removed, maxTrackID); err != nil {
+		return fmt.Errorf("clean removed: %w", err)
+	}
+	if err := s.cleanArtists(c); err != nil {
+		return fmt.Errorf("clean artists: %w", err)
+	}
+	if err := s.cleanGenres(c); err != nil {
+		return fmt.Errorf("clean genres: %w", err)
+	}
+	return nil
+}
+
+// saveScan records a finished scan in the history, keeping only the most recent
+func (s *Scanner) saveScan(c *Context, scanType db.ScanType, scanErr error) {
+	scan := &db.Scan{
+		Type:           scanType,
+		StartedAt:      c.start,
+		FinishedAt:     time.Now(),
+		TracksSeen:     c.SeenTracks(),
+		TracksNew:      c.SeenTracksNew(),
+		TracksRemoved:  c.TracksMissing(),
+		AlbumsRemoved:  c.AlbumsMissing(),
+		ArtistsRemoved: c.ArtistsMissing(),
+		GenresRemoved:  c.GenresMissing(),
+	}
+	if scanErr != nil {
+		scan.Error = scanErr.Error()
+	}
+	for _, err := range c.errs.Errors() {
+		scan.Errors = append(scan.Errors, &db.ScanError{Error: err.Error()})
+	}
+	if err := s.db.Create(scan).Error; err != nil {
+		log.Printf("error saving scan: %v", err)
+		return
+	}
+
+	const keep = 50
+	q := `DELETE FROM scans WHERE id NOT IN (SELECT id FROM scans ORDER BY started_at DESC LIMIT ?)`
+	if err := s.db.Exec(q, keep).Error; err != nil {
+		log.Printf("error cleaning scan history: %v", err)
+	}
+}
+
 func (s *Scanner) ExecuteWatch() error {
 	var err error
 	s.watcher, err = fsnotify.NewWatcher()
@@ -133,65 +268,54 @@ func (s *Scanner) ExecuteWatch() error {
 		<-t.C
 	}
 
+	ex := s.newExcluder()
 	for _, dir := range s.musicDirs {
 		err := filepath.WalkDir(dir, func(absPath string, d fs.DirEntry, err error) error {
-			return s.watchCallback(dir, absPath, d, err)
+			return s.watchCallback(ex, dir, absPath, d, err)
 		})
 		if err != nil {
 			log.Printf("error watching directory tree: %v\n", err)
 		}
 	}
 
-	scanList := map[string]struct{}{}
+	changed := map[string]struct{}{}
+	removed := map[string]struct{}{}
 	for {
 		select {
 		case <-t.C:
-			if !s.StartScanning() {
-				scanList = map[string]struct{}{}
-				break
-			}
-			for dirName := range scanList {
-				c := &Context{
-					errs:       &multierr.Err{},
-					seenTracks: map[int]struct{}{},
-					seenAlbums: map[int]struct{}{},
-					isFull:     false,
-				}
-				musicDirName := s.watchMap[dirName]
-				if musicDirName == "" {
-					musicDirName = s.watchMap[filepath.Dir(dirName)]
-				}
+			ex := s.newExcluder()
+			for dirName := range changed {
+				musicDirName := s.musicDirFor(dirName)
 				err = filepath.WalkDir(dirName, func(absPath string, d fs.DirEntry, err error) error {
-					return s.watchCallback(musicDirName, absPath, d, err)
+					return s.watchCallback(ex, musicDirName, absPath, d, err)
 				})
 				if err != nil {
 					log.Printf("error watching directory tree: %v\n", err)
 				}
-				err = filepath.WalkDir(dirName, func(absPath string, d fs.DirEntry, err error) error {
-					return s.scanCallback(c, musicDirName, absPath, d, err)
-				})
-				if err != nil {
-					log.Printf("error walking: %v", err)
-				}
-
 			}
-			scanList = map[string]struct{}{}
-			s.StopScanning()
+			if _, err := s.ScanPaths(sortedKeys(changed), sortedKeys(removed)); err != nil {
+				log.Printf("error scanning watched paths: %v", err)
+			}
+			changed = map[string]struct{}{}
+			removed = map[string]struct{}{}
 		case event := <-s.watcher.Events:
-			var dirName string
-			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
-				break
+			switch {
+			case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+				if fileInfo, err := os.Stat(event.Name); err == nil && fileInfo.IsDir() {
+					changed[event.Name] = struct{}{}
+				} else {
+					changed[filepath.Dir(event.Name)] = struct{}{}
+				}
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				// a rename is reported for the old path, the new one gets a create
+				removed[event.Name] = struct{}{}
+				s.unwatch(event.Name)
+			default:
+				continue
 			}
-			if len(scanList) == 0 {
+			if len(changed)+len(removed) == 1 {
 				t.Reset(10 * time.Second)
 			}
-			fileInfo, err := os.Stat(event.Name)
-			if err != nil && fileInfo.IsDir() {
-				dirName = event.Name
-			} else {
-				dirName = filepath.Dir(event.Name)
-			}
-			scanList[dirName] = struct{}{}
 		case err = <-s.watcher.Errors:
 			log.Printf("error from watcher: %v\n", err)
 		case <-s.watchDone:
@@ -204,18 +328,24 @@ func (s *Scanner) CancelWatch() {
 	s.watchDone <- true
 }
 
-func (s *Scanner) watchCallback(dir string, absPath string, d fs.DirEntry, err error) error {
+func (s *Scanner) watchCallback(ex *excluder, dir string, absPath string, d fs.DirEntry, err error) error {
 	if err != nil {
 		return err
 	}
 
 	switch d.Type() {
 	case os.ModeDir:
+		if ex.excluded(dir, absPath, true) {
+			return fs.SkipDir
+		}
 	case os.ModeSymlink:
+		if ex.excluded(dir, absPath, true) {
+			return nil
+		}
 		eval, _ := filepath.EvalSymlinks(absPath)
 		return filepath.WalkDir(eval, func(subAbs string, d fs.DirEntry, err error) error {
 			subAbs = strings.Replace(subAbs, eval, absPath, 1)
-			return s.watchCallback(dir, subAbs, d, err)
+			return s.watchCallback(ex, dir, subAbs, d, err)
 		})
 	default:
 		return nil
@@ -228,10 +358,100 @@ func (s *Scanner) watchCallback(dir string, absPath string, d fs.DirEntry, err e
 	return err
 }
 
-func (s *Scanner) scanCallback(c *Context, dir string, absPath string, d fs.DirEntry, err error) error {
+func (s *Scanner) unwatch(absPath string) {
+	for dir := range s.watchMap {
+		if dir == absPath || strings.HasPrefix(dir, absPath+string(filepath.Separator)) {
+			_ = s.watcher.Remove(dir)
+			delete(s.watchMap, dir)
+		}
+	}
+}
+
+// musicDirFor returns the music dir that absPath is in, or an empty string if none
+func (s *Scanner) musicDirFor(absPath string) string {
+	if dir := s.watchMap[absPath]; dir != "" {
+		return dir
+	}
+	for _, dir := range s.musicDirs {
+		if absPath == dir || strings.HasPrefix(absPath, dir+string(filepath.Separator)) {
+			return dir
+		}
+	}
+	return ""
+}
+
+type scanRoot struct {
+	musicDir string // the music dir that the folders belong to
+	dir      string // where to start walking from
+}
+
+// scanDirs walks each root, reading the folders found with s.workers workers. the
+// folders are written to the db in the order they were walked from the calling
+// goroutine, which is the only one to touch the Context
+func (s *Scanner) scanDirs(c *Context, ex *excluder, roots []scanRoot) error {
+	done := make(chan struct{})
+	jobs := make(chan *scannedDir)
+	queue := make(chan *scannedDir, s.workers*2)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(done)
+
+	var walkErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(queue)
+		defer close(jobs)
+		emit := func(dir *scannedDir) error {
+			dir.read = make(chan struct{})
+			for _, ch := range []chan *scannedDir{queue, jobs} {
+				select {
+				case ch <- dir:
+				case <-done:
+					return errScanStopped
+				}
+			}
+			return nil
+		}
+		for _, root := range roots {
+			err := filepath.WalkDir(root.dir, func(absPath string, d fs.DirEntry, err error) error {
+				return s.scanCallback(emit, ex, root.musicDir, absPath, d, err)
+			})
+			if err != nil {
+				walkErr = fmt.Errorf("walk: %w", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range jobs {
+				if dir.err == nil {
+					s.readDir(c.isFull, ex, dir)
+				}
+				close(dir.read)
+			}
+		}()
+	}
+
+	for dir := range queue {
+		<-dir.read
+		if err := s.writeDir(c, dir); err != nil {
+			return err
+		}
+	}
+	return walkErr
+}
+
+var errScanStopped = errors.New("scan stopped")
+
+func (s *Scanner) scanCallback(emit func(*scannedDir) error, ex *excluder, dir string, absPath string, d fs.DirEntry, err error) error {
 	if err != nil {
-		c.errs.Add(err)
-		return nil
+		return emit(&scannedDir{musicDir: dir, absPath: absPath, err: err})
 	}
 	if dir == absPath {
 		return nil
@@ -239,42 +459,85 @@ func (s *Scanner) scanCallback(c *Context, dir string, absPath string, d fs.DirE
 
 	switch d.Type() {
 	case os.ModeDir:
+		if ex.excluded(dir, absPath, true) {
+			return fs.SkipDir
+		}
 	case os.ModeSymlink:
+		if ex.excluded(dir, absPath, true) {
+			return nil
+		}
 		eval, _ := filepath.EvalSymlinks(absPath)
 		return filepath.WalkDir(eval, func(subAbs string, d fs.DirEntry, err error) error {
 			subAbs = strings.Replace(subAbs, eval, absPath, 1)
-			return s.scanCallback(c, dir, subAbs, d, err)
+			return s.scanCallback(emit, ex, dir, subAbs, d, err)
 		})
 	default:
 		return nil
 	}
 
-	log.Printf("processing folder `%s`", absPath)
+	return emit(&scannedDir{musicDir: dir, absPath: absPath})
+}
+
+// scannedDir is a folder in a music dir, along with everything read from the
+// filesystem that's needed to write it to the db
+type scannedDir struct {
+	musicDir string
+	absPath  string
+	err      error
+	read     chan struct{} // closed once a worker has read the folder
 
-	tx := s.db.Begin()
-	if err := s.scanDir(tx, c, dir, absPath); err != nil {
-		c.errs.Add(fmt.Errorf("%q: %w", absPath, err))
-		tx.Rollback()
-		return nil
-	}
-	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("commit tx: %w", err)
-	}
+	cover  string
+	tracks []*scannedTrack
+}
 
-	return nil
+type scannedTrack struct {
+	basename   string
+	modTime    time.Time // the latest of the track's and its lyrics file's or cue sheet's
+	stat       fs.FileInfo
+	tags       tags.Parser // nil if the track wasn't read since it's unchanged
+	tagsErr    error
+	lyricsPath string
+	lyrics     string
+	cue        *cueTrack // set if the track is one of many in the file
+}
+
+// cueNumber is the track's number in its cue sheet, or 0 if the track is the whole file
+func (t *scannedTrack) cueNumber() int {
+	if t.cue == nil {
+		return 0
+	}
+	return t.cue.track.Number
 }
 
-func (s *Scanner) scanDir(tx *db.DB, c *Context, musicDir string, absPath string) error {
-	items, err := os.ReadDir(absPath)
+// readDir does the slow filesystem work for a folder, and is safe to run from many
+// goroutines. any error is stored in dir.err, to be reported by the writer
+func (s *Scanner) readDir(isFull bool, ex *excluder, dir *scannedDir) {
+	items, err := os.ReadDir(dir.absPath)
 	if err != nil {
-		return err
+		dir.err = fmt.Errorf("%q: %w", dir.absPath, err)
+		return
 	}
 
-	var tracks []string
-	var cover string
+	var tracks, cueSheets []string
+	lyricsFiles := map[string]string{} // maps track filename without extension to its lyrics file
 	for _, item := range items {
+		if item.IsDir() || ex.excluded(dir.musicDir, filepath.Join(dir.absPath, item.Name()), false) {
+			continue
+		}
 		if isCover(item.Name()) {
-			cover = item.Name()
+			dir.cover = item.Name()
+			continue
+		}
+		if cue.IsSheet(item.Name()) {
+			cueSheets = append(cueSheets, item.Name())
+			continue
+		}
+		if lyrics.IsSidecar(item.Name()) {
+			stem := strings.TrimSuffix(item.Name(), filepath.Ext(item.Name()))
+			// prefer .lrc files since they can be synced
+			if existing, ok := lyricsFiles[stem]; !ok || !strings.EqualFold(filepath.Ext(existing), ".lrc") {
+				lyricsFiles[stem] = item.Name()
+			}
 			continue
 		}
 		if mime := mime.TypeByAudioExtension(filepath.Ext(item.Name())); mime != "" {
@@ -283,7 +546,228 @@ func (s *Scanner) scanDir(tx *db.DB, c *Context, musicDir string, absPath string
 		}
 	}
 
-	relPath, _ := filepath.Rel(musicDir, absPath)
+	// the writer makes the final call on whether a track has changed, but knowing
+	// here saves reading the tags of most tracks in an incremental scan
+	updatedAt := map[trackKey]time.Time{}
+	if !isFull {
+		var err error
+		if updatedAt, err = s.trackUpdateTimes(dir); err != nil {
+			dir.err = fmt.Errorf("%q: %w", dir.absPath, err)
+			return
+		}
+	}
+
+	sort.Strings(tracks)
+	if dir.cover == "" && len(tracks) > 0 {
+		// fall back to the first track's embedded cover, which is extracted when served
+		dir.cover = s.embeddedCover(dir, tracks[0], updatedAt)
+	}
+
+	cuedFiles, err := readCueSheets(dir.absPath, cueSheets, tracks)
+	if err != nil {
+		dir.err = fmt.Errorf("%q: %w", dir.absPath, err)
+		return
+	}
+
+	for _, basename := range tracks {
+		if cued, ok := cuedFiles[basename]; ok {
+			split, err := s.readCueTracks(dir, basename, cued, updatedAt)
+			if err != nil {
+				dir.err = fmt.Errorf("%q: populate track %q: %w", dir.absPath, basename, err)
+				return
+			}
+			dir.tracks = append(dir.tracks, split...)
+			continue
+		}
+		track, err := s.readTrack(dir, basename, lyricsFiles, updatedAt)
+		if err != nil {
+			dir.err = fmt.Errorf("%q: populate track %q: %w", dir.absPath, basename, err)
+			return
+		}
+		dir.tracks = append(dir.tracks, track)
+	}
+}
+
+// embeddedCover returns the track if it has an embedded cover. in an incremental scan
+// the folder keeps its cover while the track is unchanged, instead of opening it again
+func (s *Scanner) embeddedCover(dir *scannedDir, basename string, updatedAt map[trackKey]time.Time) string {
+	absPath := filepath.Join(dir.absPath, basename)
+	if cover, ok := s.previousCover(dir); ok && (cover == "" || cover == basename) {
+		if stat, err := os.Stat(absPath); err == nil && fileUnchanged(updatedAt, basename, stat.ModTime()) {
+			return cover
+		}
+	}
+	if ok, err := s.covers.HasCover(absPath); err != nil || !ok {
+		return ""
+	}
+	return basename
+}
+
+// previousCover is the cover the folder had after the last scan, if it was scanned
+func (s *Scanner) previousCover(dir *scannedDir) (string, bool) {
+	relPath, _ := filepath.Rel(dir.musicDir, dir.absPath)
+	left, right := filepath.Split(relPath)
+	var album db.Album
+	err := s.db.
+		Select("cover").
+		Where("root_dir=? AND left_path=? AND right_path=?", dir.musicDir, left, right).
+		First(&album).
+		Error
+	if err != nil {
+		return "", false
+	}
+	return album.Cover, true
+}
+
+// fileUnchanged returns whether any of the file's tracks were written after it was modified
+func fileUnchanged(updatedAt map[trackKey]time.Time, basename string, modTime time.Time) bool {
+	for key, t := range updatedAt {
+		if key.filename == basename && modTime.Before(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scanner) readTrack(dir *scannedDir, basename string, lyricsFiles map[string]string, updatedAt map[trackKey]time.Time) (*scannedTrack, error) {
+	track := &scannedTrack{basename: basename}
+
+	absPath := filepath.Join(dir.absPath, basename)
+	stat, err := os.Stat(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("stating %q: %w", basename, err)
+	}
+	track.stat = stat
+	track.modTime = stat.ModTime()
+
+	if lyricsFile, ok := lyricsFiles[strings.TrimSuffix(basename, filepath.Ext(basename))]; ok {
+		track.lyricsPath = filepath.Join(dir.absPath, lyricsFile)
+		// a new or changed lyrics file should update the track too
+		lyricsStat, err := os.Stat(track.lyricsPath)
+		if err != nil {
+			return nil, fmt.Errorf("stating lyrics %q: %w", track.lyricsPath, err)
+		}
+		if lyricsStat.ModTime().After(track.modTime) {
+			track.modTime = lyricsStat.ModTime()
+		}
+	}
+
+	if t, ok := updatedAt[trackKey{basename, 0}]; ok && track.modTime.Before(t) {
+		return track, nil
+	}
+
+	track.tags, track.tagsErr = s.tagger.Read(absPath)
+	if track.tagsErr != nil {
+		return track, nil
+	}
+
+	track.lyrics = track.tags.Lyrics()
+	if track.lyricsPath != "" {
+		data, err := os.ReadFile(track.lyricsPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading lyrics %q: %w", track.lyricsPath, err)
+		}
+		track.lyrics = string(data)
+	}
+	return track, nil
+}
+
+// readCueTracks reads a track for each of the cue sheet's tracks in a single file. the
+// file's tags are only read once, and only if one of its tracks has changed
+func (s *Scanner) readCueTracks(dir *scannedDir, basename string, cued []*cueTrack, updatedAt map[trackKey]time.Time) ([]*scannedTrack, error) {
+	absPath := filepath.Join(dir.absPath, basename)
+	stat, err := os.Stat(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("stating %q: %w", basename, err)
+	}
+
+	var fileTags tags.Parser
+	var fileTagsErr error
+	var read bool
+	tracks := make([]*scannedTrack, 0, len(cued))
+	for _, cueTrack := range cued {
+		track := &scannedTrack{basename: basename, stat: stat, modTime: stat.ModTime(), cue: cueTrack}
+		if cueTrack.modTime.After(track.modTime) {
+			track.modTime = cueTrack.modTime
+		}
+		tracks = append(tracks, track)
+
+		if t, ok := updatedAt[trackKey{basename, cueTrack.track.Number}]; ok && track.modTime.Before(t) {
+			continue
+		}
+		if !read {
+			fileTags, fileTagsErr = s.tagger.Read(absPath)
+			read = true
+		}
+		if fileTagsErr != nil {
+			track.tagsErr = fileTagsErr
+			continue
+		}
+		track.tags = &cueTags{Parser: fileTags, cue: cueTrack}
+	}
+	return tracks, nil
+}
+
+// trackKey identifies a track in a folder
+type trackKey struct {
+	filename string
+	cueTrack int
+}
+
+func (s *Scanner) trackUpdateTimes(dir *scannedDir) (map[trackKey]time.Time, error) {
+	relPath, _ := filepath.Rel(dir.musicDir, dir.absPath)
+	var prefix string
+	if isDisc, _ := s.discFolder(relPath); isDisc {
+		prefix = filepath.Base(relPath) + "/"
+		relPath = filepath.Dir(relPath)
+	}
+	left, right := filepath.Split(relPath)
+	q := s.db.
+		Select("tracks.filename, tracks.cue_track, tracks.updated_at").
+		Joins("JOIN albums ON albums.id=tracks.album_id").
+		Where("albums.root_dir=? AND albums.left_path=? AND albums.right_path=?", dir.musicDir, left, right)
+	if prefix != "" {
+		q = q.Where("substr(tracks.filename, 1, ?)=?", utf8.RuneCountInString(prefix), prefix)
+	}
+	var tracks []*db.Track
+	if err := q.Find(&tracks).Error; err != nil {
+		return nil, fmt.Errorf("find track update times: %w", err)
+	}
+	ret := make(map[trackKey]time.Time, len(tracks))
+	for _, track := range tracks {
+		ret[trackKey{strings.TrimPrefix(track.Filename, prefix), track.CueTrack}] = track.UpdatedAt
+	}
+	return ret, nil
+}
+
+// writeDir writes a folder read by readDir to the db in a single transaction. only
+// a failed commit is returned, other errors are collected in the Context
+func (s *Scanner) writeDir(c *Context, dir *scannedDir) error {
+	if dir.err != nil {
+		c.errs.Add(dir.err)
+		return nil
+	}
+
+	defer s.progress.addFolder()
+
+	log.Printf("processing folder `%s`", dir.absPath)
+
+	tx := s.db.Begin()
+	if err := s.scanDir(tx, c, dir); err != nil {
+		c.errs.Add(fmt.Errorf("%q: %w", dir.absPath, err))
+		tx.Rollback()
+		return nil
+	}
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Scanner) scanDir(tx *db.DB, c *Context, scanned *scannedDir) error {
+	musicDir := scanned.musicDir
+	relPath, _ := filepath.Rel(musicDir, scanned.absPath)
 	pdir, pbasename := filepath.Split(filepath.Dir(relPath))
 	var parent db.Album
 	if err := tx.Where("root_dir=? AND left_path=? AND right_path=?", musicDir, pdir, pbasename).Assign(db.Album{RootDir: musicDir, LeftPath: pdir, RightPath: pbasename}).FirstOrCreate(&parent).Error; err != nil {
@@ -294,42 +778,80 @@ func (s *Scanner) scanDir(tx *db.DB, c *Context, musicDir string, absPath string
 
 	dir, basename := filepath.Split(relPath)
 	var album db.Album
-	if err := populateAlbumBasics(tx, musicDir, &parent, &album, dir, basename, cover); err != nil {
+	if err := populateAlbumBasics(tx, musicDir, &parent, &album, dir, basename, scanned.cover); err != nil {
 		return fmt.Errorf("populate album basics: %w", err)
 	}
 
 	c.seenAlbums[album.ID] = struct{}{}
 
-	sort.Strings(tracks)
-	for i, basename := range tracks {
-		absPath := filepath.Join(musicDir, relPath, basename)
-		if err := s.populateTrackAndAlbumArtists(tx, c, i, &parent, &album, basename, absPath); err != nil {
-			return fmt.Errorf("populate track %q: %w", basename, err)
+	// the tracks of a disc folder go in the album above, which is in the folder above that
+	trackParent, trackAlbum := &parent, &album
+	var disc disc
+	if isDisc, number := s.discFolder(relPath); isDisc {
+		if err := clearAlbumTags(tx, &album); err != nil {
+			return fmt.Errorf("clear disc folder tags: %w", err)
+		}
+		if parent.Cover == "" && scanned.cover != "" {
+			parent.Cover = basename + "/" + scanned.cover
+			if err := tx.Save(&parent).Error; err != nil {
+				return fmt.Errorf("save parent cover: %w", err)
+			}
+		}
+		var grandparent db.Album
+		if err := tx.Where("id=?", parent.ParentID).Find(&grandparent).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("find grandparent: %w", err)
+		}
+		trackParent, trackAlbum = &grandparent, &parent
+		disc.prefix, disc.number = basename+"/", number
+	}
+
+	for i, track := range scanned.tracks {
+		s.progress.addTrack()
+		if err := s.populateTrackAndAlbumArtists(tx, c, i, trackParent, trackAlbum, scanned.absPath, disc, track); err != nil {
+			return fmt.Errorf("populate track %q: %w", track.basename, err)
 		}
 	}
 
 	return nil
 }
 
-func (s *Scanner) populateTrackAndAlbumArtists(tx *db.DB, c *Context, i int, parent, album *db.Album, basename string, absPath string) error {
-	stat, err := os.Stat(absPath)
-	if err != nil {
-		return fmt.Errorf("stating %q: %w", basename, err)
-	}
+func (s *Scanner) populateTrackAndAlbumArtists(tx *db.DB, c *Context, i int, parent, album *db.Album, dirPath string, disc disc, scanned *scannedTrack) error {
+	basename := scanned.basename
+	filename := disc.prefix + filepath.Base(basename)
 
 	var track db.Track
-	if err := tx.Where("album_id=? AND filename=?", album.ID, filepath.Base(basename)).First(&track).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+	if err := tx.Where("album_id=? AND filename=? AND cue_track=?", album.ID, filename, scanned.cueNumber()).First(&track).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return fmt.Errorf("query track: %w", err)
 	}
 
-	if !c.isFull && track.ID != 0 && stat.ModTime().Before(track.UpdatedAt) {
+	if !c.isFull && track.ID != 0 && scanned.modTime.Before(track.UpdatedAt) {
 		c.seenTracks[track.ID] = struct{}{}
 		return nil
 	}
 
-	trags, err := s.tagger.Read(absPath)
-	if err != nil {
-		return fmt.Errorf("%v: %w", err, ErrReadingTags)
+	if scanned.tags == nil && scanned.tagsErr == nil {
+		// the reader thought this track was unchanged, but it's not in the db. this
+		// shouldn't happen with only one writer, but read it here to be safe
+		if scanned.cue != nil {
+			reread, err := s.readCueTracks(&scannedDir{absPath: dirPath}, basename, []*cueTrack{scanned.cue}, nil)
+			if err != nil {
+				return err
+			}
+			scanned = reread[0]
+		} else {
+			var err error
+			scanned, err = s.readTrack(&scannedDir{absPath: dirPath}, basename, nil, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if scanned.tagsErr != nil {
+		return fmt.Errorf("%v: %w", scanned.tagsErr, ErrReadingTags)
+	}
+	trags := scanned.tags
+	if disc.prefix != "" {
+		trags = &discTags{Parser: trags, number: disc.number}
 	}
 
 	genreNames := strings.Split(trags.SomeGenre(), s.genreSplit)
@@ -338,26 +860,66 @@ func (s *Scanner) populateTrackAndAlbumArtists(tx *db.DB, c *Context, i int, par
 		return fmt.Errorf("populate genres: %w", err)
 	}
 
-	// metadata for the album table comes only from the the first track's tags
-	if i == 0 || album.TagArtist == nil {
-		albumArtist, err := populateAlbumArtist(tx, album, parent, trags.SomeAlbumArtist())
+	// metadata for the album table comes only from the the first track's tags, and of
+	// the first disc if the album is split into disc folders
+	setAlbumTags := i == 0 || album.TagArtist == nil
+	if setAlbumTags && disc.prefix != "" {
+		if setAlbumTags, err = firstDisc(tx, album, disc, trags.DiscNumber()); err != nil {
+			return fmt.Errorf("find first disc: %w", err)
+		}
+		if !setAlbumTags && album.TagArtist == nil {
+			var albumArtist db.Artist
+			if err := tx.Where("id=?", album.TagArtistID).Find(&albumArtist).Error; err != nil {
+				return fmt.Errorf("find album artist: %w", err)
+			}
+			album.TagArtist = &albumArtist
+		}
+	}
+	if setAlbumTags {
+		albumArtistNames := s.albumArtistNames(trags)
+		albumArtist, err := populateAlbumArtist(tx, album, parent, albumArtistNames[0])
 		if err != nil {
 			return fmt.Errorf("populate album artist: %w", err)
 		}
-		if err := populateAlbum(tx, album, albumArtist, trags, stat.ModTime(), statCreateTime(stat)); err != nil {
+		if err := populateAlbum(tx, album, albumArtist, trags, scanned.stat.ModTime(), statCreateTime(scanned.stat)); err != nil {
 			return fmt.Errorf("populate album: %w", err)
 		}
 		if err := populateAlbumGenres(tx, album, genreIDs); err != nil {
 			return fmt.Errorf("populate album genres: %w", err)
 		}
+		albumArtistIDs, err := populateArtists(tx, albumArtistNames)
+		if err != nil {
+			return fmt.Errorf("populate album artists: %w", err)
+		}
+		if err := populateAlbumArtists(tx, album, albumArtistIDs); err != nil {
+			return fmt.Errorf("populate album artists: %w", err)
+		}
 	}
 
-	if err := populateTrack(tx, album, &track, trags, basename, int(stat.Size())); err != nil {
+	size := int(scanned.stat.Size())
+	track.CueTrack, track.CueStart, track.CueEnd = 0, 0, 0
+	if scanned.cue != nil {
+		track.CueTrack = scanned.cue.track.Number
+		track.CueStart = int(scanned.cue.track.Start.Milliseconds())
+		track.CueEnd = int(scanned.cue.end.Milliseconds())
+		// roughly the track's share of the file, for clients that show it
+		if cueTags, ok := scanned.tags.(*cueTags); ok && cueTags.Parser.Length() > 0 {
+			size = int(int64(size) * int64(trags.Length()) / int64(cueTags.Parser.Length()))
+		}
+	}
+	if err := populateTrack(tx, album, &track, trags, filename, scanned.lyrics, size); err != nil {
 		return fmt.Errorf("process %q: %w", basename, err)
 	}
 	if err := populateTrackGenres(tx, &track, genreIDs); err != nil {
 		return fmt.Errorf("populate track genres: %w", err)
 	}
+	trackArtistIDs, err := populateArtists(tx, s.trackArtistNames(trags))
+	if err != nil {
+		return fmt.Errorf("populate track artists: %w", err)
+	}
+	if err := populateTrackArtists(tx, &track, trackArtistIDs); err != nil {
+		return fmt.Errorf("populate track artists: %w", err)
+	}
 
 	c.seenTracks[track.ID] = struct{}{}
 	c.seenTracksNew++
@@ -371,6 +933,10 @@ func populateAlbum(tx *db.DB, album *db.Album, albumArtist *db.Artist, trags tag
 	album.TagTitleUDec = decoded(albumName)
 	album.TagBrainzID = trags.AlbumBrainzID()
 	album.TagYear = trags.Year()
+	album.TagOriginalYear = trags.OriginalYear()
+	album.TagLabel = trags.Label()
+	album.TagCompilation = trags.Compilation()
+	album.TagReleaseType = releaseType(trags.ReleaseType())
 	album.TagArtist = albumArtist
 
 	album.ModifiedAt = modTime
@@ -409,10 +975,9 @@ func populateAlbumBasics(tx *db.DB, musicDir string, parent, album *db.Album, di
 	return nil
 }
 
-func populateTrack(tx *db.DB, album *db.Album, track *db.Track, trags tags.Parser, absPath string, size int) error {
-	basename := filepath.Base(absPath)
-	track.Filename = basename
-	track.FilenameUDec = decoded(basename)
+func populateTrack(tx *db.DB, album *db.Album, track *db.Track, trags tags.Parser, filename string, trackLyrics string, size int) error {
+	track.Filename = filename
+	track.FilenameUDec = decoded(filename)
 	track.Size = size
 	track.AlbumID = album.ID
 	track.ArtistID = album.TagArtist.ID
@@ -423,6 +988,15 @@ func populateTrack(tx *db.DB, album *db.Album, track *db.Track, trags tags.Parse
 	track.TagTrackNumber = trags.TrackNumber()
 	track.TagDiscNumber = trags.DiscNumber()
 	track.TagBrainzID = trags.BrainzID()
+	track.TagComposer = trags.Composer()
+	track.TagBPM = trags.BPM()
+	track.TagComment = trags.Comment()
+	track.Lyrics = strings.TrimSpace(trackLyrics)
+
+	track.ReplayGainTrackGain = trags.ReplayGainTrackGain()
+	track.ReplayGainTrackPeak = trags.ReplayGainTrackPeak()
+	track.ReplayGainAlbumGain = trags.ReplayGainAlbumGain()
+	track.ReplayGainAlbumPeak = trags.ReplayGainAlbumPeak()
 
 	track.Length = trags.Length()   // these two should be calculated
 	track.Bitrate = trags.Bitrate() // ...from the file instead of tags
@@ -448,6 +1022,67 @@ func populateAlbumArtist(tx *db.DB, album, parent *db.Album, artistName string)
 	return &artist, nil
 }
 
+// albumArtistNames returns every artist credited on the album, the first of
+// which is used as the album's main artist. it is never empty
+func (s *Scanner) albumArtistNames(trags tags.Parser) []string {
+	names := trags.AlbumArtists()
+	if len(names) == 0 && trags.AlbumArtist() == "" {
+		names = trags.Artists()
+	}
+	if len(names) == 0 {
+		names = []string{trags.SomeAlbumArtist()}
+	}
+	if names = splitArtistNames(names, s.artistSplit); len(names) == 0 {
+		return []string{trags.SomeAlbumArtist()}
+	}
+	return names
+}
+
+// trackArtistNames returns every artist credited on the track, from its artists tag if it
+// has one. it's empty if the track has no artist tags
+func (s *Scanner) trackArtistNames(trags tags.Parser) []string {
+	names := trags.Artists()
+	if len(names) == 0 && trags.Artist() != "" {
+		names = []string{trags.Artist()}
+	}
+	return splitArtistNames(names, s.artistSplit)
+}
+
+func splitArtistNames(names []string, sep string) []string {
+	seen := map[string]struct{}{}
+	var ret []string
+	for _, name := range names {
+		parts := []string{name}
+		if sep != "" {
+			parts = strings.Split(name, sep)
+		}
+		for _, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			if _, ok := seen[part]; ok {
+				continue
+			}
+			seen[part] = struct{}{}
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
+
+func populateArtists(tx *db.DB, names []string) ([]int, error) {
+	ids := make([]int, 0, len(names))
+	for _, name := range names {
+		var artist db.Artist
+		if err := tx.Where("name=?", name).Attrs(db.Artist{NameUDec: decoded(name)}).FirstOrCreate(&artist, db.Artist{Name: name}).Error; err != nil {
+			return nil, fmt.Errorf("find or create artist: %w", err)
+		}
+		ids = append(ids, artist.ID)
+	}
+	return ids, nil
+}
+
 func populateGenres(tx *db.DB, track *db.Track, names []string) ([]int, error) {
 	var filteredNames []string
 	for _, name := range names {
@@ -491,6 +1126,28 @@ func populateAlbumGenres(tx *db.DB, album *db.Album, genreIDs []int) error {
 	return nil
 }
 
+func populateTrackArtists(tx *db.DB, track *db.Track, artistIDs []int) error {
+	if err := tx.Where("track_id=?", track.ID).Delete(db.TrackArtist{}).Error; err != nil {
+		return fmt.Errorf("delete old track artist records: %w", err)
+	}
+
+	if err := tx.InsertBulkLeftMany("track_artists", []string{"track_id", "artist_id"}, track.ID, artistIDs); err != nil {
+		return fmt.Errorf("insert bulk track artists: %w", err)
+	}
+	return nil
+}
+
+func populateAlbumArtists(tx *db.DB, album *db.Album, artistIDs []int) error {
+	if err := tx.Where("album_id=?", album.ID).Delete(db.AlbumArtist{}).Error; err != nil {
+		return fmt.Errorf("delete old album artist records: %w", err)
+	}
+
+	if err := tx.InsertBulkLeftMany("album_artists", []string{"album_id", "artist_id"}, album.ID, artistIDs); err != nil {
+		return fmt.Errorf("insert bulk album artists: %w", err)
+	}
+	return nil
+}
+
 func (s *Scanner) cleanTracks(c *Context) error {
 	start := time.Now()
 	defer func() { log.Printf("finished clean tracks in %s, %d removed", durSince(start), c.TracksMissing()) }()
@@ -543,7 +1200,9 @@ func (s *Scanner) cleanArtists(c *Context) error {
 		Select("artists.id").
 		Model(&db.Artist{}).
 		Joins("LEFT JOIN albums ON albums.tag_artist_id=artists.id").
-		Where("albums.id IS NULL").
+		Joins("LEFT JOIN album_artists ON album_artists.artist_id=artists.id").
+		Joins("LEFT JOIN track_artists ON track_artists.artist_id=artists.id").
+		Where("albums.id IS NULL AND album_artists.artist_id IS NULL AND track_artists.artist_id IS NULL").
 		SubQuery()
 	q := s.db.
 		Where("artists.id IN ?", sub).
@@ -578,6 +1237,276 @@ func (s *Scanner) cleanGenres(c *Context) error {
 	return nil
 }
 
+// cleanRemoved deletes the albums and tracks at or under the removed paths. before they
+// go, their user data is moved to any matching track created since maxTrackID
+func (s *Scanner) cleanRemoved(c *Context, ex *excluder, removed []string, maxTrackID int) error {
+	start := time.Now()
+	defer func() {
+		log.Printf("finished clean removed in %s, %d tracks %d albums removed", durSince(start), c.TracksMissing(), c.AlbumsMissing())
+	}()
+
+	removedTracks := map[int]*db.Track{}
+	removedAlbums := map[int]struct{}{}
+	for _, absPath := range removed {
+		musicDir := s.musicDirFor(absPath)
+		if musicDir == "" || musicDir == absPath {
+			continue
+		}
+		if stat, err := os.Stat(absPath); err == nil && !ex.excluded(musicDir, absPath, stat.IsDir()) {
+			continue // it's back, and was scanned if it changed
+		}
+		relPath, _ := filepath.Rel(musicDir, absPath)
+		underPath := relPath + string(filepath.Separator)
+
+		var albums []*db.Album
+		err := s.db.
+			Where("root_dir=? AND (left_path || right_path=? OR substr(left_path, 1, ?)=?)",
+				musicDir, relPath, utf8.RuneCountInString(underPath), underPath).
+			Find(&albums).
+			Error
+		if err != nil {
+			return fmt.Errorf("find removed albums: %w", err)
+		}
+		for _, album := range albums {
+			removedAlbums[album.ID] = struct{}{}
+		}
+
+		var tracks []*db.Track
+		err = s.db.
+			Preload("Album").
+			Joins("JOIN albums ON albums.id=tracks.album_id").
+			Where("albums.root_dir=? AND (albums.left_path || albums.right_path=? OR substr(albums.left_path, 1, ?)=? OR (albums.left_path || albums.right_path=? AND tracks.filename=?))",
+				musicDir, relPath, utf8.RuneCountInString(underPath), underPath, filepath.Dir(relPath), filepath.Base(relPath)).
+			Find(&tracks).
+			Error
+		if err != nil {
+			return fmt.Errorf("find removed tracks: %w", err)
+		}
+		if s.discFolders != nil {
+			// tracks in a disc folder belong to the album above, with the folder in their filename
+			discTracks, err := s.removedDiscTracks(musicDir, relPath)
+			if err != nil {
+				return fmt.Errorf("find removed disc tracks: %w", err)
+			}
+			tracks = append(tracks, discTracks...)
+		}
+		for _, track := range tracks {
+			removedTracks[track.ID] = track
+		}
+	}
+	if len(removedTracks) == 0 && len(removedAlbums) == 0 {
+		return nil
+	}
+
+	var newTracks []*db.Track
+	if err := s.db.Preload("Album").Where("id>?", maxTrackID).Find(&newTracks).Error; err != nil {
+		return fmt.Errorf("find new tracks: %w", err)
+	}
+	movedTracks, movedAlbums := matchMoved(removedTracks, newTracks)
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for from, to := range movedAlbums {
+			if err := moveAlbumData(tx, from, to); err != nil {
+				return fmt.Errorf("move album data: %w", err)
+			}
+		}
+		for from, to := range movedTracks {
+			if err := moveTrackData(tx, from, to); err != nil {
+				return fmt.Errorf("move track data: %w", err)
+			}
+		}
+		if err := moveTrackItems(tx, movedTracks); err != nil {
+			return fmt.Errorf("move playlist items: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id := range removedTracks {
+		c.tracksMissing = append(c.tracksMissing, int64(id))
+	}
+	for id := range removedAlbums {
+		c.albumsMissing = append(c.albumsMissing, int64(id))
+	}
+	err = s.db.TransactionChunked(c.tracksMissing, func(tx *gorm.DB, chunk []int64) error {
+		return tx.Where(chunk).Delete(&db.Track{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("delete tracks: %w", err)
+	}
+	err = s.db.TransactionChunked(c.albumsMissing, func(tx *gorm.DB, chunk []int64) error {
+		return tx.Where(chunk).Delete(&db.Album{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("delete albums: %w", err)
+	}
+	return nil
+}
+
+// excludedUnder finds the folders and tracks at or under the roots that are in the db,
+// but are now excluded
+func (s *Scanner) excludedUnder(ex *excluder, roots []scanRoot) ([]string, error) {
+	var ret []string
+	for _, root := range roots {
+		relPath, _ := filepath.Rel(root.musicDir, root.dir)
+		q := s.db.
+			Preload("Tracks").
+			Where("root_dir=?", root.musicDir)
+		if relPath != "." {
+			underPath := relPath + string(filepath.Separator)
+			q = q.Where("left_path || right_path=? OR substr(left_path, 1, ?)=?", relPath, utf8.RuneCountInString(underPath), underPath)
+		}
+		var albums []*db.Album
+		if err := q.Find(&albums).Error; err != nil {
+			return nil, fmt.Errorf("find albums: %w", err)
+		}
+		for _, album := range albums {
+			albumPath := filepath.Join(album.RootDir, album.LeftPath, album.RightPath)
+			if albumPath == root.musicDir {
+				continue
+			}
+			if ex.excluded(root.musicDir, albumPath, true) {
+				ret = append(ret, albumPath)
+				continue
+			}
+			for _, track := range album.Tracks {
+				if trackPath := filepath.Join(albumPath, track.Filename); ex.excluded(root.musicDir, trackPath, false) {
+					ret = append(ret, trackPath)
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// matchMoved pairs removed tracks with new ones, first by folder name and filename, then
+// by tags. it returns maps of old track and album IDs to new ones
+func matchMoved(removed map[int]*db.Track, created []*db.Track) (tracks, albums map[int]int) {
+	candidates := map[string]*db.Track{}
+	for _, track := range created {
+		for _, key := range movedTrackKeys(track) {
+			if _, ok := candidates[key]; ok {
+				candidates[key] = nil // ambiguous, don't guess
+				continue
+			}
+			candidates[key] = track
+		}
+	}
+
+	tracks = map[int]int{}
+	albums = map[int]int{}
+	matched := map[int]struct{}{}
+	for _, track := range removed {
+		for _, key := range movedTrackKeys(track) {
+			match := candidates[key]
+			if match == nil {
+				continue
+			}
+			if _, ok := matched[match.ID]; ok {
+				continue
+			}
+			matched[match.ID] = struct{}{}
+			tracks[track.ID] = match.ID
+			if _, ok := albums[track.AlbumID]; !ok {
+				albums[track.AlbumID] = match.AlbumID
+			}
+			break
+		}
+	}
+	return tracks, albums
+}
+
+func movedTrackKeys(track *db.Track) []string {
+	keys := []string{fmt.Sprintf("path\x00%s\x00%s\x00%d", track.Album.RightPath, track.Filename, track.CueTrack)}
+	if track.TagTitle != "" {
+		keys = append(keys, fmt.Sprintf("tags\x00%s\x00%d\x00%d\x00%s", track.Album.TagTitle, track.TagDiscNumber, track.TagTrackNumber, track.TagTitle))
+	}
+	return keys
+}
+
+func moveAlbumData(tx *gorm.DB, from, to int) error {
+	for _, table := range []string{"album_stars", "album_ratings", "plays"} {
+		if err := tx.Exec(fmt.Sprintf("UPDATE OR IGNORE %s SET album_id=? WHERE album_id=?", table), to, from).Error; err != nil {
+			return fmt.Errorf("update %s: %w", table, err)
+		}
+	}
+	q := `UPDATE albums SET average_rating=(SELECT coalesce(cast(avg(rating)*100 AS int)/100.0, 0) FROM album_ratings WHERE album_id=albums.id) WHERE id=?`
+	if err := tx.Exec(q, to).Error; err != nil {
+		return fmt.Errorf("update average rating: %w", err)
+	}
+	return nil
+}
+
+func moveTrackData(tx *gorm.DB, from, to int) error {
+	for _, table := range []string{"track_stars", "track_ratings", "track_plays", "share_entries"} {
+		if err := tx.Exec(fmt.Sprintf("UPDATE OR IGNORE %s SET track_id=? WHERE track_id=?", table), to, from).Error; err != nil {
+			return fmt.Errorf("update %s: %w", table, err)
+		}
+	}
+	q := `UPDATE tracks SET average_rating=(SELECT coalesce(cast(avg(rating)*100 AS int)/100.0, 0) FROM track_ratings WHERE track_id=tracks.id) WHERE id=?`
+	if err := tx.Exec(q, to).Error; err != nil {
+		return fmt.Errorf("update average rating: %w", err)
+	}
+	if err := tx.Exec("UPDATE bookmarks SET entry_id=? WHERE entry_id_type=? AND entry_id=?", to, specid.Track, from).Error; err != nil {
+		return fmt.Errorf("update bookmarks: %w", err)
+	}
+	return nil
+}
+
+// moveTrackItems updates the track IDs in playlists and play queues
+func moveTrackItems(tx *gorm.DB, moved map[int]int) error {
+	if len(moved) == 0 {
+		return nil
+	}
+	remap := func(items []int) ([]int, bool) {
+		var changed bool
+		for i, id := range items {
+			if to, ok := moved[id]; ok {
+				items[i] = to
+				changed = true
+			}
+		}
+		return items, changed
+	}
+
+	var playlists []*db.Playlist
+	if err := tx.Find(&playlists).Error; err != nil {
+		return fmt.Errorf("find playlists: %w", err)
+	}
+	for _, playlist := range playlists {
+		items, changed := remap(playlist.GetItems())
+		if !changed {
+			continue
+		}
+		playlist.SetItems(items)
+		if err := tx.Model(playlist).UpdateColumn("items", playlist.Items).Error; err != nil {
+			return fmt.Errorf("update playlist: %w", err)
+		}
+	}
+
+	var queues []*db.PlayQueue
+	if err := tx.Find(&queues).Error; err != nil {
+		return fmt.Errorf("find play queues: %w", err)
+	}
+	for _, queue := range queues {
+		items, changed := remap(queue.GetItems())
+		if to, ok := moved[queue.Current]; ok {
+			queue.Current = to
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		queue.SetItems(items)
+		if err := tx.Model(queue).UpdateColumns(map[string]interface{}{"items": queue.Items, "current": queue.Current}).Error; err != nil {
+			return fmt.Errorf("update play queue: %w", err)
+		}
+	}
+	return nil
+}
+
 func isCover(name string) bool {
 	switch path := strings.ToLower(name); path {
 	case
@@ -593,6 +1522,20 @@ func isCover(name string) bool {
 	}
 }
 
+// releaseType normalises release types like "Album; Live" or "album/live" to "album;live"
+func releaseType(in string) string {
+	parts := strings.FieldsFunc(strings.ToLower(in), func(r rune) bool {
+		return r == ';' || r == '/' || r == ','
+	})
+	var types []string
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			types = append(types, part)
+		}
+	}
+	return strings.Join(types, ";")
+}
+
 // decoded converts a string to it's latin equivalent.
 // it will be used by the model's *UDec fields, and is only set if it
 // differs from the original. the fields are used for searching.
@@ -603,6 +1546,15 @@ func decoded(in string) string {
 	return ""
 }
 
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func durSince(t time.Time) time.Duration {
 	return time.Since(t).Truncate(10 * time.Microsecond)
 }
@@ -610,6 +1562,7 @@ func durSince(t time.Time) time.Duration {
 type Context struct {
 	errs   *multierr.Err
 	isFull bool
+	start  time.Time
 
 	seenTracks    map[int]struct{}
 	seenAlbums    map[int]struct{}
@@ -621,6 +1574,16 @@ type Context struct {
 	genresMissing  int
 }
 
+func newContext(isFull bool) *Context {
+	return &Context{
+		errs:       &multierr.Err{},
+		isFull:     isFull,
+		start:      time.Now(),
+		seenTracks: map[int]struct{}{},
+		seenAlbums: map[int]struct{}{},
+	}
+}
+
 func (c *Context) SeenTracks() int    { return len(c.seenTracks) }
 func (c *Context) SeenAlbums() int    { return len(c.seenAlbums) }
 func (c *Context) SeenTracksNew() int { return c.seenTracksNew }
@@ -630,6 +1593,24 @@ func (c *Context) AlbumsMissing() int  { return len(c.albumsMissing) }
 func (c *Context) ArtistsMissing() int { return c.artistsMissing }
 func (c *Context) GenresMissing() int  { return c.genresMissing }
 
+// progress counts what a scan has been through, for reading while it's still running
+type progress struct {
+	folders int64
+	tracks  int64
+}
+
+func (p *progress) reset() {
+	atomic.StoreInt64(&p.folders, 0)
+	atomic.StoreInt64(&p.tracks, 0)
+}
+
+func (p *progress) addFolder() { atomic.AddInt64(&p.folders, 1) }
+func (p *progress) addTrack()  { atomic.AddInt64(&p.tracks, 1) }
+
+func (p *progress) get() (folders, tracks int) {
+	return int(atomic.LoadInt64(&p.folders)), int(atomic.LoadInt64(&p.tracks))
+}
+
 func statCreateTime(info fs.FileInfo) time.Time {
 	stat, ok := info.Sys().(*syscall.Stat_t)
 	if !ok {
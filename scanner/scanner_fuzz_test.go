@@ -65,17 +65,22 @@ func fuzzStruct(taken int, data []byte, seed int64, dest interface{}) {
 		}
 		taken += take
 
+		var first byte
+		if len(b) > 0 {
+			first = b[0]
+		}
+
 		switch f := v.Elem().Field(i); f.Kind() {
 		case reflect.Bool:
-			f.SetBool(b[0] < 128)
+			f.SetBool(first < 128)
 		case reflect.String:
 			f.SetString(string(b))
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			f.SetInt(int64(b[0]))
+			f.SetInt(int64(first))
 		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			f.SetUint(uint64(b[0]))
+			f.SetUint(uint64(first))
 		case reflect.Float32, reflect.Float64:
-			f.SetFloat(float64(b[0]))
+			f.SetFloat(float64(first))
 		case reflect.Struct:
 			fuzzStruct(taken, data, seed, f.Addr().Interface())
 		}
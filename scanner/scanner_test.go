@@ -7,7 +7,11 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
@@ -559,8 +563,12 @@ func TestCompilationAlbumWithoutAlbumArtist(t *testing.T) {
 	is.NoErr(m.DB().Model(&db.Track{}).Count(&trackCount).Error)
 	is.Equal(trackCount, 5)
 
+	var artistCount int
+	is.NoErr(m.DB().Model(&db.Artist{}).Count(&artistCount).Error)
+	is.Equal(artistCount, 5) // every track's artist is credited on the track
+
 	var artists []*db.Artist
-	is.NoErr(m.DB().Preload("Albums").Find(&artists).Error)
+	is.NoErr(m.DB().Preload("Albums").Joins("JOIN album_artists ON album_artists.artist_id=artists.id").Find(&artists).Error)
 	is.Equal(len(artists), 1)             // we only have one album artist
 	is.Equal(artists[0].Name, "artist 0") // it came from the first track's fallback to artist tag
 	is.Equal(len(artists[0].Albums), 1)   // the artist has one album
@@ -610,3 +618,723 @@ func TestAlbumAndArtistSameNameWeirdness(t *testing.T) {
 	is.NoErr(m.DB().Find(&albums).Error)
 	is.Equal(len(albums), 5) // root, 2 artists, 2 albums
 }
+
+func TestMultiAlbumArtists(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	albumArtistNames := func(artist, album string) []string {
+		var names []string
+		is.NoErr(m.DB().
+			Model(&db.Artist{}).
+			Joins("JOIN album_artists ON album_artists.artist_id=artists.id").
+			Joins("JOIN albums ON albums.id=album_artists.album_id").
+			Where("albums.left_path=? AND albums.right_path=?", artist, album).
+			Order("artists.name").
+			Pluck("artists.name", &names).
+			Error)
+		return names
+	}
+
+	m.AddItems()
+	m.SetTags("artist-0/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawAlbumArtist = "artist-a"
+		tags.RawAlbumArtists = []string{"artist-a", "artist-b"}
+		return nil
+	})
+	m.SetTags("artist-0/album-1/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawAlbumArtist = "artist-a;artist-c"
+		return nil
+	})
+
+	m.ScanAndClean()
+
+	is.Equal(albumArtistNames("artist-0/", "album-0"), []string{"artist-a", "artist-b"})
+	is.Equal(albumArtistNames("artist-0/", "album-1"), []string{"artist-a", "artist-c"})
+	is.Equal(albumArtistNames("artist-0/", "album-2"), []string{"artist-0"})
+
+	var album db.Album
+	is.NoErr(m.DB().Preload("TagArtist").Where("left_path=? AND right_path=?", "artist-0/", "album-1").Find(&album).Error)
+	is.Equal(album.TagArtist.Name, "artist-a") // the first credited artist is the main one
+
+	m.SetTags("artist-0/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawAlbumArtist = "artist-0"
+		tags.RawAlbumArtists = nil
+		return nil
+	})
+
+	ctx := m.ScanAndClean()
+	is.Equal(ctx.ArtistsMissing(), 1) // artist-b isn't credited anymore
+	is.Equal(albumArtistNames("artist-0/", "album-0"), []string{"artist-0"})
+}
+
+func TestMultiAlbumArtistsFromTrackArtists(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddTrack("album/track-0.flac")
+	m.SetTags("album/track-0.flac", func(tags *mockfs.Tags) error {
+		// no album artist tags, so fall back to the track's
+		tags.RawArtist = "artist-a feat. artist-b"
+		tags.RawArtists = []string{"artist-a; artist-b"}
+		return nil
+	})
+
+	m.ScanAndClean()
+
+	var artists []*db.Artist
+	is.NoErr(m.DB().Order("name").Find(&artists).Error)
+	is.Equal(len(artists), 2)
+	is.Equal(artists[0].Name, "artist-a")
+	is.Equal(artists[1].Name, "artist-b")
+}
+
+func TestTrackArtists(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	trackArtistNames := func(path string) []string {
+		var names []string
+		is.NoErr(m.DB().
+			Model(&db.Artist{}).
+			Joins("JOIN track_artists ON track_artists.artist_id=artists.id").
+			Joins("JOIN tracks ON tracks.id=track_artists.track_id").
+			Joins("JOIN albums ON albums.id=tracks.album_id").
+			Where("albums.left_path || albums.right_path || '/' || tracks.filename=?", path).
+			Order("artists.name").
+			Pluck("artists.name", &names).
+			Error)
+		return names
+	}
+
+	m.AddItems()
+	m.SetTags("artist-0/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawArtist = "artist-a feat. artist-b"
+		tags.RawArtists = []string{"artist-a", "artist-b"}
+		return nil
+	})
+	m.SetTags("artist-0/album-0/track-1.flac", func(tags *mockfs.Tags) error {
+		tags.RawArtist = "artist-a;artist-c"
+		return nil
+	})
+
+	m.ScanAndClean()
+
+	is.Equal(trackArtistNames("artist-0/album-0/track-0.flac"), []string{"artist-a", "artist-b"}) // from the artists tag
+	is.Equal(trackArtistNames("artist-0/album-0/track-1.flac"), []string{"artist-a", "artist-c"}) // split artist tag
+	is.Equal(trackArtistNames("artist-0/album-0/track-2.flac"), []string{"artist-0"})
+
+	m.SetTags("artist-0/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawArtist = "artist-a"
+		tags.RawArtists = nil
+		return nil
+	})
+
+	ctx := m.ScanAndClean()
+	is.Equal(ctx.ArtistsMissing(), 1) // artist-b isn't credited anymore
+	is.Equal(trackArtistNames("artist-0/album-0/track-0.flac"), []string{"artist-a"})
+}
+
+func TestLyrics(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	trackLyrics := func(path string) string {
+		is.Helper()
+		var track db.Track
+		is.NoErr(m.DB().
+			Joins("JOIN albums ON albums.id=tracks.album_id").
+			Where("albums.left_path || albums.right_path || '/' || tracks.filename=?", path).
+			Find(&track).
+			Error)
+		return track.Lyrics
+	}
+
+	m.AddItems()
+	m.SetTags("artist-0/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawLyrics = "embedded"
+		return nil
+	})
+	m.SetTags("artist-0/album-0/track-1.flac", func(tags *mockfs.Tags) error {
+		tags.RawLyrics = "embedded"
+		return nil
+	})
+	m.AddLyrics("artist-0/album-0/track-1.txt", "from txt\n")
+	m.AddLyrics("artist-0/album-0/track-2.txt", "from txt")
+	m.AddLyrics("artist-0/album-0/track-2.lrc", "[00:01.00]from lrc")
+	m.AddLyrics("artist-0/album-0/notes.txt", "not lyrics")
+
+	m.ScanAndClean()
+
+	is.Equal(trackLyrics("artist-0/album-0/track-0.flac"), "embedded")           // no sidecar, so from the tags
+	is.Equal(trackLyrics("artist-0/album-0/track-1.flac"), "from txt")           // sidecar wins over the tags
+	is.Equal(trackLyrics("artist-0/album-0/track-2.flac"), "[00:01.00]from lrc") // .lrc wins over .txt
+	is.Equal(trackLyrics("artist-0/album-1/track-0.flac"), "")
+
+	m.AddLyrics("artist-0/album-1/track-0.lrc", "[00:01.00]added later")
+
+	ctx := m.ScanAndClean()
+	is.Equal(ctx.SeenTracksNew(), 1) // only the track with the new lyrics file was updated
+	is.Equal(trackLyrics("artist-0/album-1/track-0.flac"), "[00:01.00]added later")
+}
+
+func TestParallelScanWritesInWalkOrder(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddItems()
+	m.AddItemsPrefix("more")
+	ctx := m.ScanAndClean()
+	is.Equal(ctx.SeenTracks(), m.NumTracks())    // all tracks were seen
+	is.Equal(ctx.SeenTracksNew(), m.NumTracks()) // all tracks were new
+
+	var tracks []*db.Track
+	is.NoErr(m.DB().Preload("Album").Order("id").Find(&tracks).Error)
+	is.Equal(len(tracks), m.NumTracks())
+
+	var paths []string
+	for _, track := range tracks {
+		paths = append(paths, filepath.Join(track.Album.LeftPath, track.Album.RightPath, track.Filename))
+	}
+	is.True(sort.StringsAreSorted(paths)) // tracks were written in the same order they were walked, regardless of which worker read them
+
+	ctx = m.ScanAndClean()
+	is.Equal(ctx.SeenTracks(), m.NumTracks()) // all tracks were seen again
+	is.Equal(ctx.SeenTracksNew(), 0)          // but none were updated
+}
+
+func TestWatchRemovedPaths(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddItems()
+	m.ScanAndClean()
+
+	m.RemoveAll("artist-0/album-0/track-0.flac")
+	ctx := m.ScanPaths(nil, []string{"artist-0/album-0/track-0.flac"})
+	is.Equal(ctx.TracksMissing(), 1) // just the one track was removed
+	is.Equal(ctx.AlbumsMissing(), 0) // and its album is still there
+
+	m.RemoveAll("artist-0/album-1")
+	ctx = m.ScanPaths(nil, []string{"artist-0/album-1"})
+	is.Equal(ctx.TracksMissing(), 3) // the album's tracks were removed
+	is.Equal(ctx.AlbumsMissing(), 1) // along with the album
+
+	m.RemoveAll("artist-1")
+	ctx = m.ScanPaths(nil, []string{"artist-1"})
+	is.Equal(ctx.TracksMissing(), 9)  // all the artist's tracks were removed
+	is.Equal(ctx.AlbumsMissing(), 4)  // the artist folder and its albums were removed
+	is.Equal(ctx.ArtistsMissing(), 1) // and the artist itself
+
+	var tracks int
+	is.NoErr(m.DB().Model(&db.Track{}).Count(&tracks).Error)
+	is.Equal(tracks, m.NumTracks()-1-3-9)
+
+	var albums int
+	is.NoErr(m.DB().Model(&db.Album{}).Where("right_path=?", "album-1").Count(&albums).Error)
+	is.Equal(albums, 1) // only artist-2's album-1 is left
+
+	var artists []*db.Artist
+	is.NoErr(m.DB().Find(&artists).Error)
+	is.Equal(len(artists), 2)
+}
+
+func TestWatchRemovedPathStillOnDisk(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddItems()
+	m.ScanAndClean()
+
+	// eg. removed and then put back before the watcher scanned
+	ctx := m.ScanPaths(nil, []string{"artist-0/album-0"})
+	is.Equal(ctx.TracksMissing(), 0)
+	is.Equal(ctx.AlbumsMissing(), 0)
+}
+
+func TestWatchMovedAlbumKeepsUserData(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddItems()
+	m.ScanAndClean()
+
+	var album db.Album
+	is.NoErr(m.DB().Where("left_path=? AND right_path=?", "artist-0/", "album-0").Find(&album).Error)
+	var tracks []*db.Track
+	is.NoErr(m.DB().Where("album_id=?", album.ID).Order("filename").Find(&tracks).Error)
+	is.Equal(len(tracks), 3)
+
+	const userID = 1
+	is.NoErr(m.DB().Save(&db.AlbumStar{UserID: userID, AlbumID: album.ID, StarDate: time.Now()}).Error)
+	is.NoErr(m.DB().Save(&db.AlbumRating{UserID: userID, AlbumID: album.ID, Rating: 4}).Error)
+	is.NoErr(m.DB().Save(&db.Play{UserID: userID, AlbumID: album.ID, Count: 2}).Error)
+	is.NoErr(m.DB().Save(&db.TrackStar{UserID: userID, TrackID: tracks[1].ID, StarDate: time.Now()}).Error)
+	playlist := db.Playlist{UserID: userID, Name: "playlist"}
+	playlist.SetItems([]int{tracks[2].ID, tracks[0].ID})
+	is.NoErr(m.DB().Save(&playlist).Error)
+
+	// a different parent and folder name, matched by tags
+	m.Rename("artist-0/album-0", "moved/album-zero")
+	ctx := m.ScanPaths([]string{"moved/album-zero"}, []string{"artist-0/album-0"})
+	is.Equal(ctx.TracksMissing(), 3)
+	is.Equal(ctx.AlbumsMissing(), 1)
+
+	var moved db.Album
+	is.NoErr(m.DB().Where("left_path=? AND right_path=?", "moved/", "album-zero").Find(&moved).Error)
+	is.True(moved.ID != album.ID)
+	is.Equal(moved.AverageRating, 4.0) // rating moved along with the average
+	var movedTracks []*db.Track
+	is.NoErr(m.DB().Where("album_id=?", moved.ID).Order("filename").Find(&movedTracks).Error)
+	is.Equal(len(movedTracks), 3)
+
+	is.NoErr(m.DB().Where("user_id=? AND album_id=?", userID, moved.ID).Find(&db.AlbumStar{}).Error)
+	is.NoErr(m.DB().Where("user_id=? AND album_id=?", userID, moved.ID).Find(&db.AlbumRating{}).Error)
+	var play db.Play
+	is.NoErr(m.DB().Where("user_id=? AND album_id=?", userID, moved.ID).Find(&play).Error)
+	is.Equal(play.Count, 2)
+	is.NoErr(m.DB().Where("user_id=? AND track_id=?", userID, movedTracks[1].ID).Find(&db.TrackStar{}).Error)
+
+	is.NoErr(m.DB().Find(&playlist, playlist.ID).Error)
+	is.Equal(playlist.GetItems(), []int{movedTracks[2].ID, movedTracks[0].ID}) // playlist points to the moved tracks, in the same order
+
+	// and back again, this time matched by folder and filename
+	m.Rename("moved/album-zero", "artist-0/album-zero")
+	m.RemoveAll("moved")
+	m.ScanPaths([]string{"artist-0/album-zero"}, []string{"moved/album-zero", "moved"})
+
+	var back db.Album
+	is.NoErr(m.DB().Where("left_path=? AND right_path=?", "artist-0/", "album-zero").Find(&back).Error)
+	is.NoErr(m.DB().Where("user_id=? AND album_id=?", userID, back.ID).Find(&db.AlbumStar{}).Error)
+	is.Equal(m.DB().Where("right_path=?", "moved").Find(&db.Album{}).Error, gorm.ErrRecordNotFound) // the empty parent was removed
+}
+
+func TestScanHistory(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddItems()
+	m.SetTags("artist-1/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		return scanner.ErrReadingTags
+	})
+
+	_, err := m.ScanAndCleanErr()
+	is.True(err != nil) // we have a dir error
+
+	folders, tracks := m.Progress()
+	is.Equal(folders, 12)             // every folder was processed, bar the music dir itself
+	is.Equal(tracks, m.NumTracks()-2) // every track was processed, bar the rest of the failed album
+
+	m.RemoveAll("artist-2")
+	_, _ = m.ScanAndCleanErr()
+
+	var scans []*db.Scan
+	is.NoErr(m.DB().Preload("Errors").Order("started_at").Find(&scans).Error)
+	is.Equal(len(scans), 2)
+
+	is.Equal(scans[0].Type, db.ScanTypeIncremental)
+	is.Equal(scans[0].TracksNew, m.NumTracks()-3) // all tracks bar the failed album
+	is.Equal(scans[0].Error, "")                  // the scan itself finished
+	is.Equal(len(scans[0].Errors), 1)             // with one dir error
+	is.True(strings.Contains(scans[0].Errors[0].Error, "artist-1/album-0"))
+
+	is.Equal(scans[1].TracksNew, 0)
+	is.Equal(scans[1].TracksRemoved, 9)
+	is.Equal(scans[1].AlbumsRemoved, 4)
+	is.Equal(scans[1].ArtistsRemoved, 1)
+	is.True(!scans[1].FinishedAt.Before(scans[1].StartedAt))
+}
+
+func TestEmbeddedCover(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddItems()
+	m.AddEmbeddedCover("artist-0/album-0/track-0.flac")
+	m.AddEmbeddedCover("artist-0/album-1/track-2.flac")
+	m.ScanAndClean()
+
+	var album db.Album
+	is.NoErr(m.DB().Where("left_path=? AND right_path=?", "artist-0/", "album-0").Find(&album).Error)
+	is.Equal(album.Cover, "track-0.flac") // the first track's embedded cover is used
+
+	var other db.Album
+	is.NoErr(m.DB().Where("left_path=? AND right_path=?", "artist-0/", "album-1").Find(&other).Error)
+	is.Equal(other.Cover, "") // only the first track is checked
+
+	// an unchanged track isn't opened again in an incremental scan
+	trackPath := filepath.Join(m.TmpDir(), "artist-0/album-0/track-0.flac")
+	is.NoErr(os.WriteFile(trackPath, []byte("fLaC"), 0600))
+	past := time.Now().Add(-time.Hour)
+	is.NoErr(os.Chtimes(trackPath, past, past))
+	m.ScanAndClean()
+	is.NoErr(m.DB().Where("left_path=? AND right_path=?", "artist-0/", "album-0").Find(&album).Error)
+	is.Equal(album.Cover, "track-0.flac")
+
+	// but is once it changes
+	future := time.Now().Add(time.Hour)
+	is.NoErr(os.Chtimes(trackPath, future, future))
+	m.ScanAndClean()
+	is.NoErr(m.DB().Where("left_path=? AND right_path=?", "artist-0/", "album-0").Find(&album).Error)
+	is.Equal(album.Cover, "")
+
+	m.AddCover("artist-0/album-0/cover.jpg")
+	m.ScanAndClean()
+
+	is.NoErr(m.DB().Where("left_path=? AND right_path=?", "artist-0/", "album-0").Find(&album).Error)
+	is.Equal(album.Cover, "cover.jpg") // but a cover file is preferred
+}
+
+func TestCueSheet(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	const sheet = `PERFORMER "Some Orchestra"
+TITLE "Some Album"
+FILE "album.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "First"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Second"
+    PERFORMER "Some Soloist"
+    INDEX 01 01:30:00
+  TRACK 03 AUDIO
+    TITLE "Third"
+    INDEX 01 04:00:00
+`
+	m.AddTrack("album/album.flac")
+	m.SetTags("album/album.flac", func(tags *mockfs.Tags) error {
+		tags.RawArtist = "file artist"
+		tags.RawAlbum = "file album"
+		tags.RawLength = 400
+		tags.RawReplayGainTrackGain = float32Ptr(-3) // of the whole file
+		tags.RawReplayGainAlbumGain = float32Ptr(-3)
+		return nil
+	})
+	m.AddCueSheet("album/album.cue", sheet)
+	m.ScanAndClean()
+
+	var tracks []*db.Track
+	is.NoErr(m.DB().Preload("Album").Order("cue_track").Find(&tracks).Error)
+	is.Equal(len(tracks), 3) // one track for each in the cue sheet, none for the whole file
+	for _, track := range tracks {
+		is.Equal(track.Filename, "album.flac") // a flac with the same name is matched to the sheet's wav
+		is.Equal(track.Album.TagTitle, "Some Album")
+	}
+	is.Equal(tracks[0].TagTitle, "First")
+	is.Equal(tracks[0].TagTrackNumber, 1)
+	is.Equal(tracks[0].TagTrackArtist, "Some Orchestra")
+	is.Equal(tracks[0].CueStart, 0)
+	is.Equal(tracks[0].CueEnd, 90_000)
+	is.Equal(tracks[0].Length, 90)
+	is.Equal(tracks[1].TagTitle, "Second")
+	is.Equal(tracks[1].TagTrackArtist, "Some Soloist")
+	is.Equal(tracks[1].CueStart, 90_000)
+	is.Equal(tracks[1].CueEnd, 240_000)
+	is.Equal(tracks[2].CueStart, 240_000)
+	is.Equal(tracks[2].CueEnd, 0) // plays to the end of the file
+	is.Equal(tracks[2].Length, 160)
+	for _, track := range tracks {
+		is.True(track.ReplayGainTrackGain == nil) // the file's track gain isn't any one track's
+		is.Equal(*track.ReplayGainAlbumGain, float32(-3))
+	}
+
+	var artist db.Artist
+	is.NoErr(m.DB().Where("id=?", tracks[0].Album.TagArtistID).Find(&artist).Error)
+	is.Equal(artist.Name, "Some Orchestra")
+
+	// editing the sheet updates the tracks, and drops the ones no longer in it
+	m.AddCueSheet("album/album.cue", sheet[:strings.Index(sheet, "  TRACK 03")])
+	future := time.Now().Add(time.Minute) // the fs clock can lag behind the last scan
+	is.NoErr(os.Chtimes(filepath.Join(m.TmpDir(), "album/album.cue"), future, future))
+	m.ScanAndClean()
+
+	tracks = nil
+	is.NoErr(m.DB().Order("cue_track").Find(&tracks).Error)
+	is.Equal(len(tracks), 2)
+	is.Equal(tracks[1].CueEnd, 0)
+}
+
+func TestCueSheetFilePerTrack(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	// a file that's all of its only track is a normal track, and bad track numbers in a
+	// file of many don't collide with it, or each other
+	for _, path := range []string{"album/01.flac", "album/02.flac"} {
+		m.AddTrack(path)
+		m.SetTags(path, func(tags *mockfs.Tags) error {
+			tags.RawLength = 120
+			return nil
+		})
+	}
+	m.AddCueSheet("album/album.cue", `FILE "01.flac" WAVE
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
+FILE "02.flac" WAVE
+  TRACK 00 AUDIO
+    INDEX 01 00:00:00
+  TRACK 00 AUDIO
+    INDEX 01 01:00:00
+`)
+	m.ScanAndClean()
+
+	var tracks []*db.Track
+	is.NoErr(m.DB().Order("filename, cue_track").Find(&tracks).Error)
+	is.Equal(len(tracks), 3)
+	is.Equal(tracks[0].Filename, "01.flac")
+	is.Equal(tracks[0].CueTrack, 0)
+	is.Equal(tracks[1].Filename, "02.flac")
+	is.Equal(tracks[1].CueTrack, 1)
+	is.Equal(tracks[2].CueTrack, 2)
+	is.Equal(tracks[2].CueStart, 60_000)
+}
+
+func TestScanExclude(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.NewWithExclude(t, regexp.MustCompile(`@eaDir`))
+
+	m.AddItems()
+	m.AddTrack("artist-0/album-0/@eaDir/track-0.flac")
+	m.AddIgnoreFile("", "/artist-1/\n")
+	m.AddIgnoreFile("artist-2", "*.flac\n!track-0.flac\nalbum-2/\n")
+	m.ScanAndClean()
+
+	var albums []*db.Album
+	is.NoErr(m.DB().Where("right_path IN (?)", []string{"@eaDir", "artist-1", "album-2"}).Find(&albums).Error)
+	is.Equal(len(albums), 1) // only artist-0's album-2 is left
+
+	var tracks int
+	is.NoErr(m.DB().Model(&db.Track{}).Count(&tracks).Error)
+	is.Equal(tracks, 9+2) // all of artist-0, and the first track of artist-2's albums 0 and 1
+
+	// excluding something that was scanned cleans it, like it was removed
+	m.AddIgnoreFile("artist-0", "album-1\n")
+	ctx := m.ScanAndClean()
+	is.Equal(ctx.AlbumsMissing(), 1)
+	is.Equal(ctx.TracksMissing(), 3)
+}
+
+func TestScanExcludeGlob(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	exclude, err := scanner.ParseExclude("glob:@eaDir, artist-1/album-*,*-2.flac")
+	is.NoErr(err)
+	m := mockfs.NewWithExclude(t, exclude)
+
+	m.AddItems()
+	m.AddTrack("artist-0/album-0/@eaDir/track-0.flac")
+	m.ScanAndClean()
+
+	var albums []*db.Album
+	is.NoErr(m.DB().Where("right_path=? OR left_path=?", "@eaDir", "artist-1/").Find(&albums).Error)
+	is.Equal(len(albums), 0) // @eaDir at any depth, and the albums of artist-1
+
+	var tracks int
+	is.NoErr(m.DB().Model(&db.Track{}).Count(&tracks).Error)
+	is.Equal(tracks, 2*2*3) // the first two tracks of artist-0 and artist-2's albums
+
+	_, err = scanner.ParseExclude("glob:!keep.flac")
+	is.True(err != nil)
+	_, err = scanner.ParseExclude(`@eaDir|\.stfolder`) // still a regular expression without the prefix
+	is.NoErr(err)
+}
+
+func TestWatchIgnoreFile(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddItems()
+	m.ScanAndClean()
+
+	m.AddIgnoreFile("artist-0", "album-0/\ntrack-2.flac\n")
+	ctx := m.ScanPaths([]string{"artist-0"}, nil)
+	is.Equal(ctx.AlbumsMissing(), 1)   // album-0 is excluded
+	is.Equal(ctx.TracksMissing(), 3+2) // along with its tracks, and track-2 of the other albums
+
+	var tracks int
+	is.NoErr(m.DB().Model(&db.Track{}).Count(&tracks).Error)
+	is.Equal(tracks, m.NumTracks()-5)
+}
+
+func TestReplayGain(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddItems()
+	m.SetTags("artist-0/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawReplayGainTrackGain = float32Ptr(-6.5)
+		tags.RawReplayGainTrackPeak = float32Ptr(0.95)
+		tags.RawReplayGainAlbumGain = float32Ptr(0) // a real gain, not a missing one
+		return nil
+	})
+	m.ScanAndClean()
+
+	var track db.Track
+	is.NoErr(m.DB().Where("filename=?", "track-0.flac").Joins("JOIN albums ON albums.id=tracks.album_id").Where("albums.left_path=? AND albums.right_path=?", "artist-0/", "album-0").Find(&track).Error)
+	is.Equal(*track.ReplayGainTrackGain, float32(-6.5))
+	is.Equal(*track.ReplayGainTrackPeak, float32(0.95))
+	is.Equal(*track.ReplayGainAlbumGain, float32(0))
+	is.True(track.ReplayGainAlbumPeak == nil)
+}
+
+func float32Ptr(f float32) *float32 { return &f }
+
+func TestRicherTags(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddItems()
+	m.SetTags("artist-0/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawComposer = "composer"
+		tags.RawLabel = "label"
+		tags.RawCompilation = true
+		tags.RawReleaseType = "Album / Live"
+		tags.RawOriginalYear = 1985
+		tags.RawBPM = 120
+		tags.RawComment = "comment"
+		return nil
+	})
+	m.ScanAndClean()
+
+	var album db.Album
+	is.NoErr(m.DB().Where("left_path=? AND right_path=?", "artist-0/", "album-0").Find(&album).Error)
+	is.Equal(album.TagLabel, "label")
+	is.Equal(album.TagCompilation, true)
+	is.Equal(album.TagReleaseType, "album;live")
+	is.Equal(album.TagOriginalYear, 1985)
+
+	var track db.Track
+	is.NoErr(m.DB().Where("album_id=? AND filename=?", album.ID, "track-0.flac").Find(&track).Error)
+	is.Equal(track.TagComposer, "composer")
+	is.Equal(track.TagBPM, 120)
+	is.Equal(track.TagComment, "comment")
+}
+
+func TestDiscFolders(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.NewWithDiscFolders(t, regexp.MustCompile(`(?i)^cd ?\d+$`))
+
+	noDiscNumber := 0
+	for _, path := range []string{"album/CD1/track-0.flac", "album/CD1/track-1.flac", "album/CD 2/track-0.flac", "album/CD 2/track-1.flac", "single/track-0.flac"} {
+		m.AddTrack(path)
+		m.SetTags(path, func(tags *mockfs.Tags) error {
+			tags.RawArtist = "artist"
+			tags.RawAlbum = "album"
+			tags.RawDiscNumber = &noDiscNumber
+			tags.RawLabel = "label"
+			if strings.HasPrefix(path, "album/CD 2/") {
+				tags.RawLabel = "bonus disc label" // a disc that was added later
+			}
+			return nil
+		})
+	}
+	m.AddCover("album/CD1/cover.jpg")
+	m.ScanAndClean()
+
+	var discAlbums int
+	is.NoErr(m.DB().Model(&db.Album{}).Where("right_path IN (?) AND tag_artist_id IS NOT NULL", []string{"CD1", "CD 2"}).Count(&discAlbums).Error)
+	is.Equal(discAlbums, 0) // the disc folders are still folders, but not albums
+
+	var album db.Album
+	is.NoErr(m.DB().Where("right_path=?", "album").Preload("Tracks", func(db *gorm.DB) *gorm.DB { return db.Order("filename") }).Find(&album).Error)
+	is.True(album.TagArtistID != 0)
+	is.Equal(album.TagTitle, "album")
+	is.Equal(album.Cover, "CD1/cover.jpg")
+	is.Equal(len(album.Tracks), 4)
+	is.Equal(album.Tracks[0].Filename, "CD 2/track-0.flac")
+	is.Equal(album.Tracks[0].TagDiscNumber, 2) // from the folder name
+	is.Equal(album.Tracks[2].Filename, "CD1/track-0.flac")
+	is.Equal(album.Tracks[2].TagDiscNumber, 1)
+	is.Equal(album.TagLabel, "label") // from the first disc, though "CD 2" sorts first
+
+	// the album's tags still come from the first disc when only another has changed
+	m.SetTags("album/CD 2/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawLabel = "other label"
+		return nil
+	})
+	is.Equal(m.ScanAndClean().SeenTracksNew(), 1)
+	is.NoErr(m.DB().Where("right_path=?", "album").Find(&album).Error)
+	is.Equal(album.TagLabel, "label")
+
+	// nothing changed, so nothing is written
+	ctx := m.ScanAndClean()
+	is.Equal(ctx.SeenTracks(), 5)
+	is.Equal(ctx.SeenTracksNew(), 0)
+	is.Equal(ctx.TracksMissing(), 0)
+
+	m.RemoveAll("album/CD 2")
+	ctx = m.ScanPaths(nil, []string{"album/CD 2"})
+	is.Equal(ctx.TracksMissing(), 2)
+	is.Equal(ctx.AlbumsMissing(), 1)
+
+	m.RemoveAll("album/CD1/track-1.flac")
+	ctx = m.ScanPaths(nil, []string{"album/CD1/track-1.flac"})
+	is.Equal(ctx.TracksMissing(), 1)
+}
+
+func TestDryRun(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+
+	m.AddItems()
+	m.ScanAndClean()
+
+	m.RemoveAll("artist-2/album-2")
+	m.AddTrack("artist-3/album-0/track-0.flac")
+	m.SetTags("artist-3/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawArtist = "artist-3"
+		tags.RawAlbum = "album-0"
+		tags.RawTitle = "title-0"
+		return nil
+	})
+	m.SetTags("artist-0/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		tags.RawTitle = "new title"
+		return nil
+	})
+	m.SetTags("artist-1/album-0/track-0.flac", func(tags *mockfs.Tags) error {
+		return errors.New("can't read")
+	})
+	for _, path := range []string{"artist-0/album-0/track-0.flac", "artist-1/album-0/track-0.flac"} {
+		later := time.Now().Add(time.Minute)
+		is.NoErr(os.Chtimes(filepath.Join(m.TmpDir(), path), later, later))
+	}
+
+	changes := m.DryRun()
+	dir := filepath.ToSlash(m.TmpDir())
+	is.Equal(changes.Tracks.Added, []string{dir + "/artist-3/album-0/track-0.flac"})
+	is.Equal(changes.Tracks.Updated, []string{dir + "/artist-0/album-0/track-0.flac"})
+	is.Equal(len(changes.Tracks.Removed), 3+3) // album-2's, and the folder that couldn't be read
+	is.Equal(changes.Albums.Added, []string{dir + "/artist-3", dir + "/artist-3/album-0"})
+	is.Equal(changes.Albums.Removed, []string{dir + "/artist-2/album-2"})
+	is.Equal(changes.Artists.Added, []string{"artist-3"})
+	is.Equal(len(changes.Errors), 1)
+
+	// and nothing was written
+	var tracks int
+	is.NoErr(m.DB().Model(&db.Track{}).Count(&tracks).Error)
+	is.Equal(tracks, 9*3)
+	var renamed int
+	is.NoErr(m.DB().Model(&db.Track{}).Where("tag_title=?", "new title").Count(&renamed).Error)
+	is.Equal(renamed, 0)
+}
@@ -0,0 +1,107 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var ErrNoCover = errors.New("no embedded cover")
+
+// CoverReader finds the covers embedded in audio files
+type CoverReader interface {
+	// HasCover returns whether the file has an embedded cover, without reading it
+	HasCover(absPath string) (bool, error)
+	// ReadCover returns the embedded cover's image data, preferring the front cover if
+	// there are many, or ErrNoCover
+	ReadCover(absPath string) ([]byte, error)
+}
+
+// FFmpegCoverReader finds embedded covers with ffprobe and extracts them with ffmpeg,
+// so it finds them in anything ffmpeg can read
+type FFmpegCoverReader struct {
+	FFprobePath string // looked up in $PATH if empty
+	FFmpegPath  string // looked up in $PATH if empty
+}
+
+func (r *FFmpegCoverReader) HasCover(absPath string) (bool, error) {
+	if _, err := r.coverStream(absPath); err != nil {
+		if errors.Is(err, ErrNoCover) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *FFmpegCoverReader) ReadCover(absPath string) ([]byte, error) {
+	index, err := r.coverStream(absPath)
+	if err != nil {
+		return nil, err
+	}
+	name := r.FFmpegPath
+	if name == "" {
+		name = "ffmpeg"
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, "-v", "error", "-i", absPath, "-map", fmt.Sprintf("0:%d", index), "-an", "-c:v", "copy", "-f", "image2pipe", "-") //nolint:gosec
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, ErrNoCover
+	}
+	return stdout.Bytes(), nil
+}
+
+// coverStream returns the index of the stream with the embedded cover. pictures are
+// attached as video streams, with the picture type as their comment
+func (r *FFmpegCoverReader) coverStream(absPath string) (int, error) {
+	name := r.FFprobePath
+	if name == "" {
+		name = "ffprobe"
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, "-v", "error", "-print_format", "json", "-show_streams", "-select_streams", "v", absPath) //nolint:gosec
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("running ffprobe: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var probe struct {
+		Streams []struct {
+			Index       int               `json:"index"`
+			Tags        map[string]string `json:"tags"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil {
+		return 0, fmt.Errorf("decoding ffprobe output: %w", err)
+	}
+	index := -1
+	for _, stream := range probe.Streams {
+		if stream.Disposition.AttachedPic == 0 {
+			continue
+		}
+		if strings.EqualFold(stream.Tags["comment"], "Cover (front)") {
+			return stream.Index, nil
+		}
+		if index == -1 {
+			index = stream.Index
+		}
+	}
+	if index == -1 {
+		return 0, ErrNoCover
+	}
+	return index, nil
+}
@@ -0,0 +1,105 @@
+package tags
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const ffprobeCoversJSON = `{
+  "streams": [
+    { "index": 1, "codec_type": "video", "disposition": { "attached_pic": 1 }, "tags": { "comment": "Cover (back)" } },
+    { "index": 2, "codec_type": "video", "disposition": { "attached_pic": 1 }, "tags": { "comment": "Cover (front)" } }
+  ]
+}`
+
+const ffprobeBackCoverJSON = `{
+  "streams": [
+    { "index": 1, "codec_type": "video", "disposition": { "attached_pic": 1 }, "tags": { "comment": "Cover (back)" } }
+  ]
+}`
+
+const ffprobeVideoJSON = `{
+  "streams": [
+    { "index": 0, "codec_type": "video", "disposition": { "attached_pic": 0 } }
+  ]
+}`
+
+// fakeCoverCommands writes an ffprobe script that prints the output for the file with the
+// same name, and an ffmpeg script that prints the stream it was asked to copy
+func fakeCoverCommands(t *testing.T, outputs map[string]string) *FFmpegCoverReader {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffprobe and ffmpeg are shell scripts")
+	}
+	dir := t.TempDir()
+	for name, output := range outputs {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(output), 0o600); err != nil {
+			t.Fatalf("write output: %v", err)
+		}
+	}
+	ffprobe := "#!/bin/sh\n" +
+		"for last; do :; done\n" +
+		`output="` + dir + `/$(basename "$last").json"` + "\n" +
+		`[ -f "$output" ] || { echo "invalid data" >&2; exit 1; }` + "\n" +
+		`cat "$output"` + "\n"
+	ffmpeg := "#!/bin/sh\n" +
+		`while [ $# -gt 0 ]; do [ "$1" = "-map" ] && printf "picture %s" "$2"; shift; done` + "\n"
+	reader := &FFmpegCoverReader{
+		FFprobePath: filepath.Join(dir, "ffprobe"),
+		FFmpegPath:  filepath.Join(dir, "ffmpeg"),
+	}
+	if err := os.WriteFile(reader.FFprobePath, []byte(ffprobe), 0o700); err != nil { //nolint:gosec
+		t.Fatalf("write ffprobe: %v", err)
+	}
+	if err := os.WriteFile(reader.FFmpegPath, []byte(ffmpeg), 0o700); err != nil { //nolint:gosec
+		t.Fatalf("write ffmpeg: %v", err)
+	}
+	return reader
+}
+
+func TestFFmpegCoverReader(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	reader := fakeCoverCommands(t, map[string]string{
+		"covers.mp3":    ffprobeCoversJSON,
+		"back.flac":     ffprobeBackCoverJSON,
+		"video.mp4":     ffprobeVideoJSON,
+		"no-cover.opus": `{ "streams": [] }`,
+	})
+
+	cases := []struct {
+		name     string
+		hasCover bool
+		cover    string
+		err      error
+	}{
+		{"covers.mp3", true, "picture 0:2", nil}, // the front cover, even if it's not first
+		{"back.flac", true, "picture 0:1", nil},
+		{"video.mp4", false, "", ErrNoCover}, // a video stream, not a picture
+		{"no-cover.opus", false, "", ErrNoCover},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			is := is.New(t)
+
+			hasCover, err := reader.HasCover("/music/" + tc.name)
+			is.NoErr(err)
+			is.Equal(hasCover, tc.hasCover)
+
+			cover, err := reader.ReadCover("/music/" + tc.name)
+			is.True(errors.Is(err, tc.err))
+			is.Equal(string(cover), tc.cover)
+		})
+	}
+
+	_, err := reader.HasCover("/music/unreadable.mp3")
+	is.True(err != nil)
+}
@@ -0,0 +1,181 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/nicksellen/audiotags"
+)
+
+// FFProbeReader reads tags with ffprobe, which can read formats that taglib can't,
+// or files with headers it doesn't like
+type FFProbeReader struct {
+	Path string // to the ffprobe binary, looked up in $PATH if empty
+}
+
+func (r *FFProbeReader) Read(abspath string) (Parser, error) {
+	name := r.Path
+	if name == "" {
+		name = "ffprobe"
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", abspath) //nolint:gosec
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running ffprobe: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil {
+		return nil, fmt.Errorf("decoding ffprobe output: %w", err)
+	}
+	stream := probe.audioStream()
+	if stream == nil {
+		return nil, fmt.Errorf("no audio stream in %q", abspath)
+	}
+
+	// some containers keep tags on the stream rather than the file, eg. ogg
+	raw := map[string][]string{}
+	for _, tags := range []ffprobeTags{stream.Tags, probe.Format.Tags} {
+		for k, v := range tags {
+			raw[strings.ToLower(k)] = v
+		}
+	}
+	for alias, key := range ffprobeAliases {
+		if _, ok := raw[key]; !ok && len(raw[alias]) > 0 {
+			raw[key] = raw[alias]
+		}
+	}
+
+	props := &audiotags.AudioProperties{
+		Length:     int(parseFloat(probe.Format.Duration, stream.Duration)),
+		Bitrate:    int(parseFloat(probe.Format.BitRate, stream.BitRate) / 1000),
+		Samplerate: int(parseFloat(stream.SampleRate)),
+		Channels:   stream.Channels,
+	}
+	return &Tagger{raw, props}, nil
+}
+
+// ffprobeAliases maps the names ffprobe gives some id3 and mp4 tags to the names
+// used by taglib's property map
+var ffprobeAliases = map[string]string{ //nolint:gochecknoglobals
+	"album_artist": "albumartist",
+	"track":        "tracknumber",
+	"disc":         "discnumber",
+	"tbpm":         "bpm",
+	"tcmp":         "compilation",
+	"lyrics-eng":   "lyrics",
+}
+
+type ffprobeOutput struct {
+	Streams []*ffprobeStream `json:"streams"`
+	Format  struct {
+		Duration string      `json:"duration"`
+		BitRate  string      `json:"bit_rate"`
+		Tags     ffprobeTags `json:"tags"`
+	} `json:"format"`
+}
+
+type ffprobeStream struct {
+	CodecType  string      `json:"codec_type"`
+	SampleRate string      `json:"sample_rate"`
+	Channels   int         `json:"channels"`
+	Duration   string      `json:"duration"`
+	BitRate    string      `json:"bit_rate"`
+	Tags       ffprobeTags `json:"tags"`
+}
+
+// ffprobeTags are the values of each tag. ffmpeg keeps every value of a multi-valued tag,
+// eg. an id3v2.4 text frame with many artists, which ffprobe prints as the same key
+// many times
+type ffprobeTags map[string][]string
+
+func (t *ffprobeTags) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("tags aren't an object")
+	}
+	tags := ffprobeTags{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return fmt.Errorf("tag %q: %w", key, err)
+		}
+		tags[key] = append(tags[key], value)
+	}
+	*t = tags
+	return nil
+}
+
+func (o *ffprobeOutput) audioStream() *ffprobeStream {
+	for _, stream := range o.Streams {
+		if stream.CodecType == "audio" {
+			return stream
+		}
+	}
+	return nil
+}
+
+func parseFloat(strs ...string) float64 {
+	for _, str := range strs {
+		if f, err := strconv.ParseFloat(str, 64); err == nil && f > 0 {
+			return f
+		}
+	}
+	return 0
+}
+
+// ChainReader tries each of its readers in order, returning the tags from the first
+// that can read the file
+type ChainReader []Reader
+
+func (c ChainReader) Read(abspath string) (Parser, error) {
+	var errs []string
+	for _, reader := range c {
+		tags, err := reader.Read(abspath)
+		if err == nil {
+			return tags, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("no reader could read tags: %s", strings.Join(errs, "; "))
+}
+
+// ReaderFromNames returns a reader for the named backends, "taglib" or "ffprobe".
+// if there's more than one, they're tried in order
+func ReaderFromNames(names []string) (Reader, error) {
+	var chain ChainReader
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "taglib":
+			chain = append(chain, &TagReader{})
+		case "ffprobe":
+			path, err := exec.LookPath("ffprobe")
+			if err != nil {
+				return nil, fmt.Errorf("find ffprobe: %w", err)
+			}
+			chain = append(chain, &FFProbeReader{Path: path})
+		default:
+			return nil, fmt.Errorf("unknown tag reader %q", name)
+		}
+	}
+	switch len(chain) {
+	case 0:
+		return nil, fmt.Errorf("no tag readers given")
+	case 1:
+		return chain[0], nil
+	default:
+		return chain, nil
+	}
+}
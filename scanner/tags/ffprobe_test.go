@@ -0,0 +1,86 @@
+package tags
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const ffprobeOutputJSON = `{
+  "streams": [
+    { "codec_type": "video", "tags": { "comment": "Cover (front)" } },
+    { "codec_type": "audio", "sample_rate": "44100", "channels": 2, "duration": "245.5", "tags": { "TITLE": "stream title", "ARTIST": "artist", "ARTISTS": "artist", "ARTISTS": "other artist" } }
+  ],
+  "format": {
+    "duration": "245.493000",
+    "bit_rate": "912000",
+    "tags": { "album_artist": "album artist", "track": "3/10", "disc": "2", "date": "2006-04-01", "BPM": "128" }
+  }
+}`
+
+// fakeFFProbe writes a script that prints the output for any file ending in "good", and
+// fails for anything else
+func fakeFFProbe(t *testing.T, output string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffprobe is a shell script")
+	}
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "output.json")
+	if err := os.WriteFile(outputPath, []byte(output), 0o600); err != nil {
+		t.Fatalf("write output: %v", err)
+	}
+	script := "#!/bin/sh\n" +
+		"for last; do :; done\n" +
+		`case "$last" in *good) cat "` + outputPath + `" ;; *) echo "invalid data" >&2; exit 1 ;; esac` + "\n"
+	scriptPath := filepath.Join(dir, "ffprobe")
+	if err := os.WriteFile(scriptPath, []byte(script), 0o700); err != nil { //nolint:gosec
+		t.Fatalf("write script: %v", err)
+	}
+	return scriptPath
+}
+
+func TestFFProbeReader(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	reader := &FFProbeReader{Path: fakeFFProbe(t, ffprobeOutputJSON)}
+	tags, err := reader.Read("/music/track.wv.good")
+	is.NoErr(err)
+	is.Equal(tags.Title(), "stream title")
+	is.Equal(tags.Artist(), "artist")
+	is.Equal(tags.Artists(), []string{"artist", "other artist"}) // every value of a repeated tag
+	is.Equal(tags.AlbumArtist(), "album artist")
+	is.Equal(tags.TrackNumber(), 3)
+	is.Equal(tags.DiscNumber(), 2)
+	is.Equal(tags.Year(), 2006)
+	is.Equal(tags.BPM(), 128)
+	is.Equal(tags.Length(), 245)
+	is.Equal(tags.Bitrate(), 912)
+	is.Equal(tags.Comment(), "") // from the cover art stream, not the audio
+
+	_, err = reader.Read("/music/track.wv.bad")
+	is.True(err != nil)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(string) (Parser, error) { return nil, errors.New("can't read") }
+
+func TestChainReader(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	ffprobe := &FFProbeReader{Path: fakeFFProbe(t, ffprobeOutputJSON)}
+
+	tags, err := ChainReader{failingReader{}, ffprobe}.Read("/music/track.dsf.good")
+	is.NoErr(err)
+	is.Equal(tags.Title(), "stream title")
+
+	_, err = ChainReader{failingReader{}, ffprobe}.Read("/music/track.dsf.bad")
+	is.True(err != nil)
+}
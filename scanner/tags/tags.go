@@ -10,27 +10,59 @@ import (
 type TagReader struct{}
 
 func (*TagReader) Read(abspath string) (Parser, error) {
-	raw, props, err := audiotags.Read(abspath)
+	tags, props, err := audiotags.Read(abspath)
+	// taglib's property map can hold many values per key, but audiotags only gives us
+	// the last one. the ffprobe reader returns them all
+	raw := make(map[string][]string, len(tags))
+	for k, v := range tags {
+		raw[k] = []string{v}
+	}
 	return &Tagger{raw, props}, err
 }
 
 type Tagger struct {
-	raw   map[string]string
+	raw   map[string][]string // every value for each key, eg. many "artists"
 	props *audiotags.AudioProperties
 }
 
+func (t *Tagger) value(key string) string {
+	for _, v := range t.raw[key] {
+		if v := strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 func (t *Tagger) first(keys ...string) string {
 	for _, key := range keys {
-		if v := strings.TrimSpace(t.raw[key]); v != "" {
+		if v := t.value(key); v != "" {
 			return v
 		}
 	}
 	return ""
 }
 
+// all returns every value of the first key that has any. tags written as one value with
+// a separator are split later
+func (t *Tagger) all(keys ...string) []string {
+	for _, key := range keys {
+		var ret []string
+		for _, v := range t.raw[key] {
+			if v := strings.TrimSpace(v); v != "" {
+				ret = append(ret, v)
+			}
+		}
+		if len(ret) > 0 {
+			return ret
+		}
+	}
+	return nil
+}
+
 func (t *Tagger) firstInt(sep string, keys ...string) int {
 	for _, key := range keys {
-		if v := intSep(t.raw[key], sep); v > 0 {
+		if v := intSep(t.value(key), sep); v > 0 {
 			return v
 		}
 	}
@@ -39,18 +71,38 @@ func (t *Tagger) firstInt(sep string, keys ...string) int {
 
 // https://picard-docs.musicbrainz.org/downloads/MusicBrainz_Picard_Tag_Map.html
 
-func (t *Tagger) Title() string         { return t.first("title") }
-func (t *Tagger) BrainzID() string      { return t.first("musicbrainz_trackid") } // musicbrainz recording ID
-func (t *Tagger) Artist() string        { return t.first("artist") }
-func (t *Tagger) Album() string         { return t.first("album") }
-func (t *Tagger) AlbumArtist() string   { return t.first("albumartist", "album artist") }
-func (t *Tagger) AlbumBrainzID() string { return t.first("musicbrainz_albumid") } // musicbrainz release ID
-func (t *Tagger) Genre() string         { return t.first("genre") }
-func (t *Tagger) TrackNumber() int      { return t.firstInt("/" /* eg. 5/12 */, "tracknumber") }
-func (t *Tagger) DiscNumber() int       { return t.firstInt("/" /* eg. 1/2  */, "discnumber") }
-func (t *Tagger) Length() int           { return t.props.Length }
-func (t *Tagger) Bitrate() int          { return t.props.Bitrate }
-func (t *Tagger) Year() int             { return t.firstInt("-", "originaldate", "date", "year") }
+func (t *Tagger) Title() string          { return t.first("title") }
+func (t *Tagger) BrainzID() string       { return t.first("musicbrainz_trackid") } // musicbrainz recording ID
+func (t *Tagger) Artist() string         { return t.first("artist") }
+func (t *Tagger) Artists() []string      { return t.all("artists") }
+func (t *Tagger) Album() string          { return t.first("album") }
+func (t *Tagger) AlbumArtist() string    { return t.first("albumartist", "album artist") }
+func (t *Tagger) AlbumArtists() []string { return t.all("albumartists", "album_artists") }
+func (t *Tagger) AlbumBrainzID() string  { return t.first("musicbrainz_albumid") } // musicbrainz release ID
+func (t *Tagger) Genre() string          { return t.first("genre") }
+func (t *Tagger) TrackNumber() int       { return t.firstInt("/" /* eg. 5/12 */, "tracknumber") }
+func (t *Tagger) DiscNumber() int        { return t.firstInt("/" /* eg. 1/2  */, "discnumber") }
+func (t *Tagger) Length() int            { return t.props.Length }
+func (t *Tagger) Bitrate() int           { return t.props.Bitrate }
+func (t *Tagger) Year() int              { return t.firstInt("-", "originaldate", "date", "year") }
+func (t *Tagger) Lyrics() string         { return t.first("lyrics", "unsyncedlyrics", "unsynced lyrics") }
+
+func (t *Tagger) Composer() string    { return t.first("composer") }
+func (t *Tagger) Label() string       { return t.first("label", "organization", "publisher") }
+func (t *Tagger) Compilation() bool   { return t.bool("compilation", "itunescompilation") }
+func (t *Tagger) ReleaseType() string { return t.first("releasetype", "musicbrainz_albumtype") }
+func (t *Tagger) OriginalYear() int   { return t.firstInt("-", "originalyear", "originaldate") }
+func (t *Tagger) BPM() int            { return t.firstInt(".", "bpm") }
+func (t *Tagger) Comment() string     { return t.first("comment", "description") }
+
+func (t *Tagger) ReplayGainTrackGain() *float32 {
+	return t.gain("replaygain_track_gain", "r128_track_gain")
+}
+func (t *Tagger) ReplayGainTrackPeak() *float32 { return t.peak("replaygain_track_peak") }
+func (t *Tagger) ReplayGainAlbumGain() *float32 {
+	return t.gain("replaygain_album_gain", "r128_album_gain")
+}
+func (t *Tagger) ReplayGainAlbumPeak() *float32 { return t.peak("replaygain_album_peak") }
 
 func (t *Tagger) SomeAlbum() string  { return first("Unknown Album", t.Album()) }
 func (t *Tagger) SomeArtist() string { return first("Unknown Artist", t.Artist()) }
@@ -59,6 +111,44 @@ func (t *Tagger) SomeAlbumArtist() string {
 }
 func (t *Tagger) SomeGenre() string { return first("Unknown Genre", t.Genre()) }
 
+func (t *Tagger) bool(keys ...string) bool {
+	switch strings.ToLower(t.first(keys...)) {
+	case "1", "true", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// gain reads a ReplayGain gain in dB (eg. "-6.54 dB"), falling back to an R128 gain,
+// which is a Q7.8 fixed point number relative to -23 LUFS rather than -18 LUFS. it's
+// nil if the file isn't tagged with either, since a gain of 0 dB is a real gain
+func (t *Tagger) gain(rgKey, r128Key string) *float32 {
+	if v := t.value(rgKey); v != "" {
+		v = strings.TrimSpace(strings.TrimSuffix(strings.ToLower(v), "db"))
+		if gain, err := strconv.ParseFloat(v, 32); err == nil {
+			ret := float32(gain)
+			return &ret
+		}
+	}
+	if v := t.value(r128Key); v != "" {
+		if gain, err := strconv.Atoi(v); err == nil {
+			ret := float32(gain)/256 + 5
+			return &ret
+		}
+	}
+	return nil
+}
+
+func (t *Tagger) peak(key string) *float32 {
+	peak, err := strconv.ParseFloat(t.value(key), 32)
+	if err != nil {
+		return nil
+	}
+	ret := float32(peak)
+	return &ret
+}
+
 type Reader interface {
 	Read(abspath string) (Parser, error)
 }
@@ -67,8 +157,10 @@ type Parser interface {
 	Title() string
 	BrainzID() string
 	Artist() string
+	Artists() []string
 	Album() string
 	AlbumArtist() string
+	AlbumArtists() []string
 	AlbumBrainzID() string
 	Genre() string
 	TrackNumber() int
@@ -76,6 +168,20 @@ type Parser interface {
 	Length() int
 	Bitrate() int
 	Year() int
+	Lyrics() string
+
+	Composer() string
+	Label() string
+	Compilation() bool
+	ReleaseType() string
+	OriginalYear() int
+	BPM() int
+	Comment() string
+
+	ReplayGainTrackGain() *float32
+	ReplayGainTrackPeak() *float32
+	ReplayGainAlbumGain() *float32
+	ReplayGainAlbumPeak() *float32
 
 	SomeAlbum() string
 	SomeArtist() string
@@ -0,0 +1,63 @@
+package tags
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestReplayGain(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	rg := &Tagger{raw: map[string][]string{
+		"replaygain_track_gain": {"-6.54 dB"},
+		"replaygain_track_peak": {"0.988"},
+		"replaygain_album_gain": {"+1.5 DB"},
+		"r128_album_gain":       {"-512"},
+	}}
+	is.Equal(*rg.ReplayGainTrackGain(), float32(-6.54))
+	is.Equal(*rg.ReplayGainTrackPeak(), float32(0.988))
+	is.Equal(*rg.ReplayGainAlbumGain(), float32(1.5)) // replaygain is preferred over r128
+	is.True(rg.ReplayGainAlbumPeak() == nil)          // untagged
+
+	r128 := &Tagger{raw: map[string][]string{
+		"r128_track_gain": {"-512"},
+		"r128_album_gain": {"garbage"},
+	}}
+	is.Equal(*r128.ReplayGainTrackGain(), float32(3)) // -2dB relative to -23 LUFS is +3dB relative to -18
+	is.True(r128.ReplayGainAlbumGain() == nil)
+
+	zero := &Tagger{raw: map[string][]string{
+		"replaygain_track_gain": {"0.00 dB"},
+		"replaygain_track_peak": {"0"},
+	}}
+	is.Equal(*zero.ReplayGainTrackGain(), float32(0)) // tagged, so kept
+	is.Equal(*zero.ReplayGainTrackPeak(), float32(0))
+}
+
+func TestRicherTags(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	tags := &Tagger{raw: map[string][]string{
+		"composer":          {"J. S. Bach"},
+		"organization":      {"Deutsche Grammophon"},
+		"itunescompilation": {"1"},
+		"releasetype":       {"album; live"},
+		"originaldate":      {"1985-02-01"},
+		"bpm":               {"120.5"},
+		"description":       {"remastered"},
+	}}
+	is.Equal(tags.Composer(), "J. S. Bach")
+	is.Equal(tags.Label(), "Deutsche Grammophon")
+	is.Equal(tags.Compilation(), true)
+	is.Equal(tags.ReleaseType(), "album; live")
+	is.Equal(tags.OriginalYear(), 1985)
+	is.Equal(tags.BPM(), 120)
+	is.Equal(tags.Comment(), "remastered")
+
+	empty := &Tagger{raw: map[string][]string{"compilation": {"0"}}}
+	is.Equal(empty.Compilation(), false)
+	is.Equal(empty.BPM(), 0)
+}
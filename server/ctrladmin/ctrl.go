@@ -9,6 +9,7 @@ import (
 	"html/template"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
@@ -23,6 +24,9 @@ import (
 
 	"go.senan.xyz/gonic"
 	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/loginlimit"
+	"go.senan.xyz/gonic/oidc"
+	"go.senan.xyz/gonic/paths"
 	"go.senan.xyz/gonic/podcasts"
 	"go.senan.xyz/gonic/server/assets"
 	"go.senan.xyz/gonic/server/ctrlbase"
@@ -48,7 +52,10 @@ func funcMap() template.FuncMap {
 			return strings.ToLower(in.Format("Jan 02, 2006"))
 		},
 		"dateHuman": humanize.Time,
-		"base64":    base64.StdEncoding.EncodeToString,
+		"duration": func(in time.Duration) string {
+			return in.Round(time.Millisecond).String()
+		},
+		"base64": base64.StdEncoding.EncodeToString,
 	}
 }
 
@@ -58,6 +65,32 @@ type Controller struct {
 	templates map[string]*template.Template
 	sessDB    *gormstore.Store
 	Podcasts  *podcasts.Podcasts
+
+	// single sign-on, both optional
+	ProxyAuth       *ProxyAuth
+	OIDC            *oidc.Provider
+	AutoCreateUsers bool // for users who sign on but don't exist yet
+}
+
+// ProxyAuth logs users in with a header set by a reverse proxy that has already
+// authenticated them, eg. Authelia's Remote-User. the header is only trusted from
+// the trusted proxies, since anyone else could set it
+type ProxyAuth struct {
+	Header string
+}
+
+// proxyAuthUsername returns the user the proxy logged in, or "" if there isn't one or
+// the request isn't from a trusted proxy
+func (c *Controller) proxyAuthUsername(r *http.Request) string {
+	username := strings.TrimSpace(r.Header.Get(c.ProxyAuth.Header))
+	if username == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || !c.IsTrustedProxy(host) {
+		return ""
+	}
+	return username
 }
 
 func New(b *ctrlbase.Controller, sessDB *gormstore.Store, podcasts *podcasts.Podcasts) (*Controller, error) {
@@ -115,20 +148,40 @@ type templateData struct {
 	AllUsers             []*db.User
 	LastScanTime         time.Time
 	IsScanning           bool
+	ScanFolderCount      int
+	ScanTrackCount       int
+	Scans                []*db.Scan
 	Playlists            []*db.Playlist
 	TranscodePreferences []*db.TranscodePreference
 	TranscodeProfiles    []string
+	APIKeys              []*db.APIKey
+	OIDCEnabled          bool
 
 	CurrentLastFMAPIKey    string
 	CurrentLastFMAPISecret string
 	DefaultListenBrainzURL string
 	SelectedUser           *db.User
+	MusicPaths             paths.MusicPaths
+	LoginFailures          []*loginlimit.Failures
 
 	Podcasts              []*db.Podcast
 	InternetRadioStations []*db.InternetRadioStation
 
 	// avatar
 	Avatar []byte
+
+	// totp
+	TOTPQR                []byte // png
+	TOTPSecret            string
+	TOTPRecoveryCodes     []string
+	TOTPRecoveryCodesLeft int
+
+	// share
+	Share       *db.Share
+	ShareTracks []*db.Track
+
+	// smart playlist
+	SmartPlaylistSorts []string
 }
 
 type Response struct {
@@ -236,8 +289,7 @@ type Flash struct {
 	Type    FlashType
 }
 
-//nolint:gochecknoinits // for now I think it's nice that our types and their
-// gob registrations are next to each other, in case there's more added later)
+//nolint:gochecknoinits // for now I think it's nice that our types and their gob registrations are next to each other, in case there's more added later)
 func init() {
 	gob.Register(&Flash{})
 }
@@ -275,29 +327,7 @@ func sessLogSave(s *sessions.Session, w http.ResponseWriter, r *http.Request) {
 // ## begin validation
 // ## begin validation
 
-var (
-	errValiNoUsername        = errors.New("please enter a username")
-	errValiPasswordAllFields = errors.New("please enter the password twice")
-	errValiPasswordsNotSame  = errors.New("passwords entered were not the same")
-	errValiKeysAllFields     = errors.New("please enter the api key and secret")
-)
-
-func validateUsername(username string) error {
-	if username == "" {
-		return errValiNoUsername
-	}
-	return nil
-}
-
-func validatePasswords(pOne, pTwo string) error {
-	if pOne == "" || pTwo == "" {
-		return errValiPasswordAllFields
-	}
-	if !(pOne == pTwo) {
-		return errValiPasswordsNotSame
-	}
-	return nil
-}
+var errValiKeysAllFields = errors.New("please enter the api key and secret")
 
 func validateAPIKey(apiKey, secret string) error {
 	if apiKey == "" || secret == "" {
@@ -17,9 +17,11 @@ import (
 	"github.com/nfnt/resize"
 
 	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/loginlimit"
 	"go.senan.xyz/gonic/scanner"
 	"go.senan.xyz/gonic/scrobble/lastfm"
 	"go.senan.xyz/gonic/scrobble/listenbrainz"
+	"go.senan.xyz/gonic/server/ctrlbase"
 	"go.senan.xyz/gonic/transcode"
 )
 
@@ -36,10 +38,14 @@ func (c *Controller) ServeNotFound(r *http.Request) *Response {
 }
 
 func (c *Controller) ServeLogin(r *http.Request) *Response {
-	return &Response{template: "login.tmpl"}
+	return &Response{
+		template: "login.tmpl",
+		data:     &templateData{OIDCEnabled: c.OIDC != nil},
+	}
 }
 
 func (c *Controller) ServeHome(r *http.Request) *Response {
+	user := r.Context().Value(CtxUser).(*db.User)
 	data := &templateData{}
 	// stats box
 	c.DB.Model(&db.Artist{}).Count(&data.ArtistCount)
@@ -49,22 +55,30 @@ func (c *Controller) ServeHome(r *http.Request) *Response {
 	data.RequestRoot = c.BaseURL(r)
 	data.CurrentLastFMAPIKey, _ = c.DB.GetSetting("lastfm_api_key")
 	data.DefaultListenBrainzURL = listenbrainz.BaseURL
+	// single sign-on box
+	data.OIDCEnabled = c.OIDC != nil
 	// users box
 	c.DB.Find(&data.AllUsers)
 	// recent folders box
-	c.DB.
+	recentQ := c.DB.
 		Where("tag_artist_id IS NOT NULL").
 		Order("created_at DESC").
-		Limit(8).
-		Find(&data.RecentFolders)
+		Limit(8)
+	if user.IsRestricted() {
+		var rootDirs []string
+		for _, folder := range user.MusicFolders {
+			rootDirs = append(rootDirs, folder.Path)
+		}
+		recentQ = recentQ.Where("root_dir IN (?)", rootDirs)
+	}
+	recentQ.Find(&data.RecentFolders)
 	data.IsScanning = c.Scanner.IsScanning()
+	data.ScanFolderCount, data.ScanTrackCount = c.Scanner.Progress()
 	if tStr, err := c.DB.GetSetting("last_scan_time"); err != nil {
 		i, _ := strconv.ParseInt(tStr, 10, 64)
 		data.LastScanTime = time.Unix(i, 0)
 	}
 
-	user := r.Context().Value(CtxUser).(*db.User)
-
 	// playlists box
 	c.DB.
 		Where("user_id=?", user.ID).
@@ -77,6 +91,17 @@ func (c *Controller) ServeHome(r *http.Request) *Response {
 	for profile := range transcode.UserProfiles {
 		data.TranscodeProfiles = append(data.TranscodeProfiles, profile)
 	}
+	// api keys box
+	c.DB.
+		Where("user_id=?", user.ID).
+		Order("created_at").
+		Find(&data.APIKeys)
+	// scan history box
+	c.DB.
+		Preload("Errors").
+		Order("started_at DESC").
+		Limit(10).
+		Find(&data.Scans)
 	// podcasts box
 	c.DB.Find(&data.Podcasts)
 
@@ -95,7 +120,7 @@ func (c *Controller) ServeChangeOwnUsername(r *http.Request) *Response {
 
 func (c *Controller) ServeChangeOwnUsernameDo(r *http.Request) *Response {
 	username := r.FormValue("username")
-	if err := validateUsername(username); err != nil {
+	if err := ctrlbase.ValidateUsername(username); err != nil {
 		return &Response{
 			redirect: r.Referer(),
 			flashW:   []string{err.Error()},
@@ -114,14 +139,18 @@ func (c *Controller) ServeChangeOwnPassword(r *http.Request) *Response {
 func (c *Controller) ServeChangeOwnPasswordDo(r *http.Request) *Response {
 	passwordOne := r.FormValue("password_one")
 	passwordTwo := r.FormValue("password_two")
-	if err := validatePasswords(passwordOne, passwordTwo); err != nil {
+	if err := ctrlbase.ValidatePasswords(passwordOne, passwordTwo); err != nil {
 		return &Response{
 			redirect: r.Referer(),
 			flashW:   []string{err.Error()},
 		}
 	}
+	encrypted, err := c.PasswordKey.Encrypt(passwordOne)
+	if err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("encrypt password: %v", err)}
+	}
 	user := r.Context().Value(CtxUser).(*db.User)
-	user.Password = passwordOne
+	user.Password = encrypted
 	c.DB.Save(user)
 	return &Response{redirect: "/admin/home"}
 }
@@ -183,6 +212,17 @@ func (c *Controller) ServeLinkLastFMDo(r *http.Request) *Response {
 	return &Response{redirect: "/admin/home"}
 }
 
+func (c *Controller) ServeUnlinkOIDCDo(r *http.Request) *Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	if err := c.DB.SetUserOIDCSubject(user, ""); err != nil {
+		return &Response{
+			redirect: "/admin/home",
+			flashW:   []string{fmt.Sprintf("couldn't unlink single sign-on: %v", err)},
+		}
+	}
+	return &Response{redirect: "/admin/home"}
+}
+
 func (c *Controller) ServeUnlinkLastFMDo(r *http.Request) *Response {
 	user := r.Context().Value(CtxUser).(*db.User)
 	user.LastFMSession = ""
@@ -234,7 +274,7 @@ func (c *Controller) ServeChangeUsername(r *http.Request) *Response {
 func (c *Controller) ServeChangeUsernameDo(r *http.Request) *Response {
 	username := r.URL.Query().Get("user")
 	usernameNew := r.FormValue("username")
-	if err := validateUsername(usernameNew); err != nil {
+	if err := ctrlbase.ValidateUsername(usernameNew); err != nil {
 		return &Response{
 			redirect: r.Referer(),
 			flashW:   []string{err.Error()},
@@ -267,18 +307,122 @@ func (c *Controller) ServeChangePasswordDo(r *http.Request) *Response {
 	username := r.URL.Query().Get("user")
 	passwordOne := r.FormValue("password_one")
 	passwordTwo := r.FormValue("password_two")
-	if err := validatePasswords(passwordOne, passwordTwo); err != nil {
+	if err := ctrlbase.ValidatePasswords(passwordOne, passwordTwo); err != nil {
 		return &Response{
 			redirect: r.Referer(),
 			flashW:   []string{err.Error()},
 		}
 	}
+	encrypted, err := c.PasswordKey.Encrypt(passwordOne)
+	if err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("encrypt password: %v", err)}
+	}
+	user := c.DB.GetUserByName(username)
+	user.Password = encrypted
+	c.DB.Save(user)
+	return &Response{redirect: "/admin/home"}
+}
+
+func (c *Controller) ServeChangeRoles(r *http.Request) *Response {
+	username := r.URL.Query().Get("user")
+	if username == "" {
+		return &Response{code: 400, err: "please provide a username"}
+	}
+	user := c.DB.GetUserByName(username)
+	if user == nil {
+		return &Response{code: 400, err: "couldn't find a user with that name"}
+	}
+	data := &templateData{}
+	data.SelectedUser = user
+	return &Response{
+		template: "change_roles.tmpl",
+		data:     data,
+	}
+}
+
+func (c *Controller) ServeChangeRolesDo(r *http.Request) *Response {
+	username := r.URL.Query().Get("user")
 	user := c.DB.GetUserByName(username)
-	user.Password = passwordOne
+	if user == nil {
+		return &Response{code: 400, err: "couldn't find a user with that name"}
+	}
+	// unchecked boxes aren't sent at all
+	user.DownloadRole = r.FormValue("download_role") == "on"
+	user.JukeboxRole = r.FormValue("jukebox_role") == "on"
+	user.PodcastRole = r.FormValue("podcast_role") == "on"
+	user.ShareRole = r.FormValue("share_role") == "on"
+	user.ScanRole = r.FormValue("scan_role") == "on"
+	user.PlaylistRole = r.FormValue("playlist_role") == "on"
 	c.DB.Save(user)
 	return &Response{redirect: "/admin/home"}
 }
 
+func (c *Controller) ServeChangeMusicFolders(r *http.Request) *Response {
+	username := r.URL.Query().Get("user")
+	if username == "" {
+		return &Response{code: 400, err: "please provide a username"}
+	}
+	user := c.DB.GetUserByName(username)
+	if user == nil {
+		return &Response{code: 400, err: "couldn't find a user with that name"}
+	}
+	data := &templateData{}
+	data.SelectedUser = user
+	data.MusicPaths = c.MusicPaths
+	return &Response{
+		template: "change_music_folders.tmpl",
+		data:     data,
+	}
+}
+
+func (c *Controller) ServeChangeMusicFoldersDo(r *http.Request) *Response {
+	username := r.URL.Query().Get("user")
+	user := c.DB.GetUserByName(username)
+	if user == nil {
+		return &Response{code: 400, err: "couldn't find a user with that name"}
+	}
+	if err := r.ParseForm(); err != nil {
+		return &Response{code: 400, err: fmt.Sprintf("couldn't parse form: %v", err)}
+	}
+	folders := r.PostForm["music_folder"]
+	if len(folders) == 0 {
+		return &Response{
+			redirect: r.Referer(),
+			flashW:   []string{"please choose at least one folder"},
+		}
+	}
+	// with every folder checked, the user can see folders added later too
+	if len(folders) == len(c.MusicPaths) {
+		folders = nil
+	}
+	if err := c.DB.SetUserMusicFolders(user, folders); err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("couldn't set music folders: %v", err)}
+	}
+	return &Response{redirect: "/admin/home"}
+}
+
+func (c *Controller) ServeFailedLogins(r *http.Request) *Response {
+	data := &templateData{}
+	data.LoginFailures = c.LoginLimiter.Failures()
+	return &Response{
+		template: "failed_logins.tmpl",
+		data:     data,
+	}
+}
+
+func (c *Controller) ServeClearFailedLoginsDo(r *http.Request) *Response {
+	kind := loginlimit.Kind(r.URL.Query().Get("kind"))
+	name := r.URL.Query().Get("name")
+	if (kind != loginlimit.KindIP && kind != loginlimit.KindUsername) || name == "" {
+		return &Response{code: 400, err: "please provide a kind and name"}
+	}
+	c.LoginLimiter.Clear(kind, name)
+	return &Response{
+		redirect: "/admin/failed_logins",
+		flashN:   []string{fmt.Sprintf("cleared failed logins of %s %s", kind, name)},
+	}
+}
+
 func (c *Controller) ServeChangeAvatar(r *http.Request) *Response {
 	username := r.URL.Query().Get("user")
 	if username == "" {
@@ -355,7 +499,7 @@ func (c *Controller) ServeCreateUser(r *http.Request) *Response {
 
 func (c *Controller) ServeCreateUserDo(r *http.Request) *Response {
 	username := r.FormValue("username")
-	if err := validateUsername(username); err != nil {
+	if err := ctrlbase.ValidateUsername(username); err != nil {
 		return &Response{
 			redirect: r.Referer(),
 			flashW:   []string{err.Error()},
@@ -363,16 +507,21 @@ func (c *Controller) ServeCreateUserDo(r *http.Request) *Response {
 	}
 	passwordOne := r.FormValue("password_one")
 	passwordTwo := r.FormValue("password_two")
-	if err := validatePasswords(passwordOne, passwordTwo); err != nil {
+	if err := ctrlbase.ValidatePasswords(passwordOne, passwordTwo); err != nil {
 		return &Response{
 			redirect: r.Referer(),
 			flashW:   []string{err.Error()},
 		}
 	}
+	encrypted, err := c.PasswordKey.Encrypt(passwordOne)
+	if err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("encrypt password: %v", err)}
+	}
 	user := db.User{
 		Name:     username,
-		Password: passwordOne,
+		Password: encrypted,
 	}
+	user.SetDefaultRoles()
 	if err := c.DB.Create(&user).Error; err != nil {
 		return &Response{
 			redirect: r.Referer(),
@@ -469,6 +618,42 @@ func (c *Controller) ServeDeleteTranscodePrefDo(r *http.Request) *Response {
 	}
 }
 
+func (c *Controller) ServeCreateAPIKeyDo(r *http.Request) *Response {
+	label := r.FormValue("label")
+	if label == "" {
+		return &Response{
+			redirect: "/admin/home",
+			flashW:   []string{"please provide a label"},
+		}
+	}
+	user := r.Context().Value(CtxUser).(*db.User)
+	key, _, err := c.DB.CreateAPIKey(user, label)
+	if err != nil {
+		return &Response{
+			redirect: "/admin/home",
+			flashW:   []string{fmt.Sprintf("could not create api key: %v", err)},
+		}
+	}
+	return &Response{
+		redirect: "/admin/home",
+		flashN:   []string{fmt.Sprintf("your new api key is %s. copy it now, it won't be shown again", key)},
+	}
+}
+
+func (c *Controller) ServeDeleteAPIKeyDo(r *http.Request) *Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		return &Response{code: 400, err: "please provide a valid id"}
+	}
+	c.DB.
+		Where("user_id=? AND id=?", user.ID, id).
+		Delete(db.APIKey{})
+	return &Response{
+		redirect: "/admin/home",
+	}
+}
+
 func (c *Controller) ServePodcastAddDo(r *http.Request) *Response {
 	rssURL := r.FormValue("feed")
 	fp := gofeed.NewParser()
@@ -4,15 +4,20 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 
 	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/smartplaylist"
 )
 
 var (
@@ -46,6 +51,7 @@ func playlistCheckContentType(contentType string) bool {
 		"audio/x-mpegurl",
 		"audio/mpegurl",
 		"application/x-mpegurl",
+		"application/json",
 		"application/octet-stream":
 		return true
 	}
@@ -57,14 +63,18 @@ func playlistParseUpload(c *Controller, userID int, header *multipart.FileHeader
 	if err != nil {
 		return []string{fmt.Sprintf("couldn't open file %q", header.Filename)}, false
 	}
-	playlistName := strings.TrimSuffix(header.Filename, ".m3u8")
-	if playlistName == "" {
-		return []string{fmt.Sprintf("invalid filename %q", header.Filename)}, false
-	}
 	contentType := header.Header.Get("Content-Type")
 	if !playlistCheckContentType(contentType) {
 		return []string{fmt.Sprintf("invalid content-type %q", contentType)}, false
 	}
+	switch ext := strings.ToLower(filepath.Ext(header.Filename)); ext {
+	case ".json", ".nsp":
+		return playlistParseSmartUpload(c, userID, header.Filename, file)
+	}
+	playlistName := strings.TrimSuffix(header.Filename, ".m3u8")
+	if playlistName == "" {
+		return []string{fmt.Sprintf("invalid filename %q", header.Filename)}, false
+	}
 	var trackIDs []int
 	var errors []string
 	scanner := bufio.NewScanner(file)
@@ -91,6 +101,55 @@ func playlistParseUpload(c *Controller, userID int, header *multipart.FileHeader
 	return errors, true
 }
 
+// playlistParseSmartUpload creates a smart playlist from a definition, named by the
+// definition or else by the file
+func playlistParseSmartUpload(c *Controller, userID int, filename string, file io.Reader) ([]string, bool) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return []string{fmt.Sprintf("reading %q: %v", filename, err)}, false
+	}
+	def, err := smartplaylist.Parse(data)
+	if err != nil {
+		return []string{fmt.Sprintf("%q: %.100s", filename, err)}, false
+	}
+	if def.Name == "" {
+		def.Name = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	if err := playlistSaveSmart(c, userID, def); err != nil {
+		return []string{err.Error()}, false
+	}
+	return nil, true
+}
+
+func playlistSaveSmart(c *Controller, userID int, def *smartplaylist.Definition) error {
+	rules, err := def.Marshal()
+	if err != nil {
+		return err
+	}
+	playlist := &db.Playlist{}
+	c.DB.FirstOrCreate(playlist, db.Playlist{
+		Name:   def.Name,
+		UserID: userID,
+	})
+	playlist.Comment = def.Comment
+	playlist.Rules = rules
+	playlist.SetItems(nil)
+	// the count for playlist lists, until the tracks are found again when it's viewed
+	var owner db.User
+	if err := c.DB.Preload("MusicFolders").First(&owner, userID).Error; err != nil {
+		return fmt.Errorf("find owner: %w", err)
+	}
+	trackIDs, err := smartplaylist.TrackIDs(c.DB, &owner, &def.Rules, time.Now())
+	if err != nil {
+		return fmt.Errorf("find tracks: %w", err)
+	}
+	playlist.TrackCount = len(trackIDs)
+	if err := c.DB.Save(playlist).Error; err != nil {
+		return fmt.Errorf("save playlist: %w", err)
+	}
+	return nil
+}
+
 func (c *Controller) ServeUploadPlaylist(r *http.Request) *Response {
 	return &Response{template: "upload_playlist.tmpl"}
 }
@@ -131,3 +190,97 @@ func (c *Controller) ServeDeletePlaylistDo(r *http.Request) *Response {
 		redirect: "/admin/home",
 	}
 }
+
+func (c *Controller) ServeCreateSmartPlaylist(r *http.Request) *Response {
+	sorts := make([]string, 0, len(smartplaylist.Sorts))
+	for s := range smartplaylist.Sorts {
+		sorts = append(sorts, s)
+	}
+	sort.Strings(sorts)
+	return &Response{
+		template: "create_smart_playlist.tmpl",
+		data:     &templateData{SmartPlaylistSorts: sorts},
+	}
+}
+
+func (c *Controller) ServeCreateSmartPlaylistDo(r *http.Request) *Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	def := &smartplaylist.Definition{
+		Name:    strings.TrimSpace(r.FormValue("name")),
+		Comment: r.FormValue("comment"),
+	}
+	if def.Name == "" {
+		return &Response{
+			redirect: "/admin/create_smart_playlist",
+			flashW:   []string{"please provide a name"},
+		}
+	}
+	rules, err := smartPlaylistParseForm(r)
+	if err == nil {
+		def.Rules = *rules
+		err = def.Validate()
+	}
+	if err != nil {
+		return &Response{
+			redirect: "/admin/create_smart_playlist",
+			flashW:   []string{err.Error()},
+		}
+	}
+	if err := playlistSaveSmart(c, user.ID, def); err != nil {
+		return &Response{code: 500, err: err.Error()}
+	}
+	return &Response{
+		redirect: "/admin/home",
+		flashN:   []string{fmt.Sprintf("smart playlist %q created", def.Name)},
+	}
+}
+
+// smartPlaylistParseForm reads the rules of the create page, where empty fields match
+// any track
+func smartPlaylistParseForm(r *http.Request) (*smartplaylist.Rules, error) {
+	var rules smartplaylist.Rules
+	for _, genre := range strings.Split(r.FormValue("genres"), ",") {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			rules.Genres = append(rules.Genres, genre)
+		}
+	}
+	rules.Artist = strings.TrimSpace(r.FormValue("artist"))
+	rules.Starred = r.FormValue("starred") == "on"
+	rules.PathGlob = strings.TrimSpace(r.FormValue("path_glob"))
+	rules.Sort = r.FormValue("sort")
+	if rules.Sort != "" && r.FormValue("sort_desc") == "on" {
+		rules.Sort = "-" + rules.Sort
+	}
+	ints := []struct {
+		field string
+		dest  *int
+	}{
+		{"year_from", &rules.YearFrom},
+		{"year_to", &rules.YearTo},
+		{"min_rating", &rules.MinRating},
+		{"min_plays", &rules.MinPlays},
+		{"played_days", &rules.PlayedDays},
+		{"not_played_days", &rules.NotPlayedDays},
+		{"added_days", &rules.AddedDays},
+		{"limit", &rules.Limit},
+	}
+	for _, i := range ints {
+		val := strings.TrimSpace(r.FormValue(i.field))
+		if val == "" {
+			continue
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", strings.ReplaceAll(i.field, "_", " "), val)
+		}
+		*i.dest = n
+	}
+	if val := strings.TrimSpace(r.FormValue("max_plays")); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max plays %q", val)
+		}
+		rules.MaxPlays = &n
+	}
+	return &rules, nil
+}
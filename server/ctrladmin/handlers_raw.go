@@ -1,9 +1,17 @@
 package ctrladmin
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/gorilla/sessions"
+
+	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/oidc"
 )
 
 func (c *Controller) ServeLoginDo(w http.ResponseWriter, r *http.Request) {
@@ -16,13 +24,32 @@ func (c *Controller) ServeLoginDo(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, r.Referer(), http.StatusSeeOther)
 		return
 	}
+	ip := c.ClientIP(r)
+	if wait := c.LoginLimiter.Wait(ip, username); wait > 0 {
+		sessAddFlashW(session, []string{fmt.Sprintf("too many failed logins, please try again in %s", wait)})
+		sessLogSave(session, w, r)
+		http.Redirect(w, r, r.Referer(), http.StatusSeeOther)
+		return
+	}
 	user := c.DB.GetUserByName(username)
-	if user == nil || password != user.Password {
+	if user == nil || !c.checkPassword(user, password) {
+		if user != nil {
+			c.LoginLimiter.Fail(ip, username)
+		} else {
+			c.LoginLimiter.Fail(ip, "")
+		}
 		sessAddFlashW(session, []string{"invalid username / password"})
 		sessLogSave(session, w, r)
 		http.Redirect(w, r, r.Referer(), http.StatusSeeOther)
 		return
 	}
+	if user.HasTOTP() {
+		// the password was right, but they aren't logged in until they've given a code
+		// from their app too
+		c.askTOTP(w, r, session, user)
+		return
+	}
+	c.LoginLimiter.Succeed(username)
 	// put the user name into the session. future endpoints after this one
 	// are wrapped with WithUserSession() which will get the name from the
 	// session and put the row into the request context
@@ -31,9 +58,179 @@ func (c *Controller) ServeLoginDo(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, c.Path("/admin/home"), http.StatusSeeOther)
 }
 
+func (c *Controller) checkPassword(user *db.User, password string) bool {
+	decrypted, err := c.PasswordKey.Decrypt(user.Password)
+	if err != nil {
+		log.Printf("error decrypting password of %q: %v\n", user.Name, err)
+		return false
+	}
+	return password == decrypted
+}
+
 func (c *Controller) ServeLogout(w http.ResponseWriter, r *http.Request) {
 	session := r.Context().Value(CtxSession).(*sessions.Session)
 	session.Options.MaxAge = -1
 	sessLogSave(session, w, r)
 	http.Redirect(w, r, c.Path("/admin/login"), http.StatusSeeOther)
 }
+
+func (c *Controller) ServeLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(CtxSession).(*sessions.Session)
+	state, errState := randomString()
+	nonce, errNonce := randomString()
+	if errState != nil || errNonce != nil {
+		http.Error(w, "couldn't make login state", http.StatusInternalServerError)
+		return
+	}
+	authURL, err := c.OIDC.AuthCodeURL(r.Context(), c.oidcRedirectURL(r), state, nonce)
+	if err != nil {
+		log.Printf("error starting single sign-on: %v\n", err)
+		sessAddFlashW(session, []string{"couldn't reach the single sign-on provider"})
+		sessLogSave(session, w, r)
+		http.Redirect(w, r, c.Path("/admin/login"), http.StatusSeeOther)
+		return
+	}
+	// checked when the provider sends the user back, so that nobody else can
+	// finish the login
+	session.Values["oidc_state"] = state
+	session.Values["oidc_nonce"] = nonce
+	sessLogSave(session, w, r)
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+func (c *Controller) ServeLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(CtxSession).(*sessions.Session)
+	state, _ := session.Values["oidc_state"].(string)
+	nonce, _ := session.Values["oidc_nonce"].(string)
+	linkUserID, _ := session.Values["oidc_link"].(int)
+	delete(session.Values, "oidc_state")
+	delete(session.Values, "oidc_nonce")
+	delete(session.Values, "oidc_link")
+	// linking only counts for whoever started it, in case they logged out in between
+	linking := linkUserID != 0 && session.Values["user"] == linkUserID
+	fail := func(message string) {
+		sessAddFlashW(session, []string{message})
+		sessLogSave(session, w, r)
+		if linking {
+			http.Redirect(w, r, c.Path("/admin/home"), http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, c.Path("/admin/login"), http.StatusSeeOther)
+	}
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		fail(fmt.Sprintf("single sign-on failed: %s", errCode))
+		return
+	}
+	if state == "" || query.Get("state") != state {
+		fail("single sign-on expired, please try again")
+		return
+	}
+	identity, err := c.OIDC.Exchange(r.Context(), c.oidcRedirectURL(r), query.Get("code"), nonce)
+	if err != nil {
+		log.Printf("error finishing single sign-on: %v\n", err)
+		fail("single sign-on failed")
+		return
+	}
+	if linking {
+		user := c.DB.GetUserByID(linkUserID)
+		if user == nil {
+			fail("couldn't find your user")
+			return
+		}
+		if err := c.DB.SetUserOIDCSubject(user, identity.Subject); err != nil {
+			fail(fmt.Sprintf("couldn't link single sign-on: %v", err))
+			return
+		}
+		sessLogSave(session, w, r)
+		http.Redirect(w, r, c.Path("/admin/home"), http.StatusSeeOther)
+		return
+	}
+	user, err := c.oidcUser(identity)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	if user.HasTOTP() {
+		c.askTOTP(w, r, session, user)
+		return
+	}
+	session.Values["user"] = user.ID
+	sessLogSave(session, w, r)
+	http.Redirect(w, r, c.Path("/admin/home"), http.StatusSeeOther)
+}
+
+// ServeLinkOIDCDo logs the user in to the OpenID provider, so that the account they log
+// in to can be used to log in to gonic afterwards
+func (c *Controller) ServeLinkOIDCDo(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(CtxSession).(*sessions.Session)
+	user := r.Context().Value(CtxUser).(*db.User)
+	session.Values["oidc_link"] = user.ID
+	c.ServeLoginOIDC(w, r)
+}
+
+// oidcRedirectURL is where the provider sends users back to. only the trusted proxies can
+// change its host, otherwise anyone could have the provider send the code to them
+func (c *Controller) oidcRedirectURL(r *http.Request) string {
+	return c.TrustedBaseURL(r) + c.Path("/admin/login_oidc_callback")
+}
+
+var errOIDCNotLinked = errors.New("single sign-on isn't linked to a user. log in with your password, then link it from the home page")
+
+// oidcUser finds the user who linked the OpenID provider's account. usernames at the
+// provider can often be changed, so without a link they're only used to create new users
+func (c *Controller) oidcUser(identity *oidc.Identity) (*db.User, error) {
+	if user := c.DB.GetUserByOIDCSubject(identity.Subject); user != nil {
+		return user, nil
+	}
+	if !c.AutoCreateUsers || identity.Username == "" {
+		return nil, errOIDCNotLinked
+	}
+	if user := c.DB.GetUserByName(identity.Username); user != nil {
+		return nil, errOIDCNotLinked
+	}
+	return c.createSSOUser(identity.Username, identity.Subject)
+}
+
+// proxyAuthUser finds the user that the proxy logged in, creating them if they don't
+// exist yet and that's allowed. the proxies are trusted to say who that is
+func (c *Controller) proxyAuthUser(username string) (*db.User, error) {
+	if user := c.DB.GetUserByName(username); user != nil {
+		return user, nil
+	}
+	if !c.AutoCreateUsers {
+		return nil, fmt.Errorf("user %q doesn't exist", username)
+	}
+	return c.createSSOUser(username, "")
+}
+
+// createSSOUser creates a user for single sign-on. they can't log in with a password
+// until an admin gives them one
+func (c *Controller) createSSOUser(username, oidcSubject string) (*db.User, error) {
+	password, err := randomString()
+	if err != nil {
+		return nil, fmt.Errorf("make password: %w", err)
+	}
+	encrypted, err := c.PasswordKey.Encrypt(password)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt password: %w", err)
+	}
+	user := db.User{
+		Name:        username,
+		Password:    encrypted,
+		OIDCSubject: oidcSubject,
+	}
+	user.SetDefaultRoles()
+	if err := c.DB.Create(&user).Error; err != nil {
+		return nil, fmt.Errorf("create user %q: %w", username, err)
+	}
+	return c.DB.GetUserByID(user.ID), nil
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,49 @@
+package ctrladmin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	"go.senan.xyz/gonic/db"
+)
+
+// ServeShare shows a public share to anyone with the link, with a player for its tracks
+func (c *Controller) ServeShare(r *http.Request) *Response {
+	var share db.Share
+	err := c.DB.
+		Where("uuid=?", mux.Vars(r)["uuid"]).
+		Preload("User").
+		Preload("User.MusicFolders").
+		Preload("Entries", func(db *gorm.DB) *gorm.DB {
+			return db.Order("share_entries.id")
+		}).
+		First(&share).
+		Error
+	now := time.Now()
+	if err != nil || share.IsExpired(now) || !share.User.HasRole(db.RoleShare) {
+		return &Response{template: "not_found.tmpl", code: 404}
+	}
+	tracks, err := c.DB.GetShareTracks(&share)
+	if err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("couldn't find share tracks: %v", err)}
+	}
+
+	share.LastVisited = &now
+	share.VisitCount++
+	err = c.DB.
+		Model(&share).
+		UpdateColumns(map[string]interface{}{"last_visited": now, "visit_count": share.VisitCount}).
+		Error
+	if err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("couldn't update share visits: %v", err)}
+	}
+
+	data := &templateData{}
+	data.Share = &share
+	data.ShareTracks = tracks
+	return &Response{template: "share.tmpl", data: data}
+}
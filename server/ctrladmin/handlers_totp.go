@@ -0,0 +1,214 @@
+package ctrladmin
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/skip2/go-qrcode"
+
+	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/totp"
+)
+
+const (
+	totpIssuer = "gonic"
+	// how long after the password someone has to give their code
+	totpLoginTimeout = 5 * time.Minute
+)
+
+func (c *Controller) ServeTOTP(r *http.Request) *Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	data := &templateData{}
+	if user.HasTOTP() {
+		c.DB.
+			Model(db.TOTPRecoveryCode{}).
+			Where("user_id=?", user.ID).
+			Count(&data.TOTPRecoveryCodesLeft)
+		return &Response{
+			template: "totp.tmpl",
+			data:     data,
+		}
+	}
+	// a new secret is kept in the session until the user shows that their app has
+	// it, and kept if they get the code wrong so that they don't have to scan again
+	session := r.Context().Value(CtxSession).(*sessions.Session)
+	secret, _ := session.Values["totp_secret"].(string)
+	if secret == "" {
+		var err error
+		if secret, err = totp.NewSecret(); err != nil {
+			return &Response{code: 500, err: err.Error()}
+		}
+		session.Values["totp_secret"] = secret
+	}
+	qr, err := qrcode.Encode(totp.URL(totpIssuer, user.Name, secret), qrcode.Medium, 256)
+	if err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("make qr code: %v", err)}
+	}
+	data.TOTPQR = qr
+	data.TOTPSecret = secret
+	return &Response{
+		template: "totp.tmpl",
+		data:     data,
+	}
+}
+
+func (c *Controller) ServeEnableTOTPDo(r *http.Request) *Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	session := r.Context().Value(CtxSession).(*sessions.Session)
+	secret, _ := session.Values["totp_secret"].(string)
+	if secret == "" || user.HasTOTP() {
+		return &Response{redirect: "/admin/totp"}
+	}
+	step, ok := totp.Validate(secret, r.FormValue("code"), time.Now(), 0)
+	if !ok {
+		return &Response{
+			redirect: "/admin/totp",
+			flashW:   []string{"invalid code, please check your app's clock and try again"},
+		}
+	}
+	encrypted, err := c.PasswordKey.Encrypt(secret)
+	if err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("encrypt secret: %v", err)}
+	}
+	user.TOTPSecret = encrypted
+	user.TOTPLastStep = step
+	if err := c.DB.Model(user).Updates(map[string]interface{}{"totp_secret": user.TOTPSecret, "totp_last_step": user.TOTPLastStep}).Error; err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("save secret: %v", err)}
+	}
+	delete(session.Values, "totp_secret")
+	codes, err := c.DB.CreateTOTPRecoveryCodes(user)
+	if err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("create recovery codes: %v", err)}
+	}
+	return &Response{
+		template: "totp.tmpl",
+		data:     &templateData{TOTPRecoveryCodes: codes},
+		flashN:   []string{"two-factor authentication enabled"},
+	}
+}
+
+func (c *Controller) ServeDisableTOTPDo(r *http.Request) *Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	if resp := c.requireTOTP(user, r.FormValue("code")); resp != nil {
+		return resp
+	}
+	if err := c.DB.DisableTOTP(user); err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("disable two-factor authentication: %v", err)}
+	}
+	return &Response{
+		redirect: "/admin/home",
+		flashN:   []string{"two-factor authentication disabled"},
+	}
+}
+
+func (c *Controller) ServeCreateTOTPRecoveryCodesDo(r *http.Request) *Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	if resp := c.requireTOTP(user, r.FormValue("code")); resp != nil {
+		return resp
+	}
+	codes, err := c.DB.CreateTOTPRecoveryCodes(user)
+	if err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("create recovery codes: %v", err)}
+	}
+	return &Response{
+		template: "totp.tmpl",
+		data:     &templateData{TOTPRecoveryCodes: codes},
+	}
+}
+
+// requireTOTP returns a response back to the totp page if the code isn't right, so that
+// someone with a logged in session can't change the user's second factor without it
+func (c *Controller) requireTOTP(user *db.User, code string) *Response {
+	if !user.HasTOTP() {
+		return &Response{redirect: "/admin/totp"}
+	}
+	ok, err := c.checkTOTP(user, code)
+	if err != nil {
+		return &Response{code: 500, err: fmt.Sprintf("check code: %v", err)}
+	}
+	if !ok {
+		return &Response{
+			redirect: "/admin/totp",
+			flashW:   []string{"invalid code"},
+		}
+	}
+	return nil
+}
+
+// checkTOTP checks a code from the user's app, or one of their recovery codes. either
+// can only be used once
+func (c *Controller) checkTOTP(user *db.User, code string) (bool, error) {
+	secret, err := c.PasswordKey.Decrypt(user.TOTPSecret)
+	if err != nil {
+		return false, fmt.Errorf("decrypt secret: %w", err)
+	}
+	if step, ok := totp.Validate(secret, code, time.Now(), user.TOTPLastStep); ok {
+		return c.DB.UseTOTPStep(user, step)
+	}
+	return c.DB.UseTOTPRecoveryCode(user, code)
+}
+
+// askTOTP sends a user who has two-factor authentication to give a code from their app,
+// however else they logged in. they aren't logged in until they do, see ServeLoginTOTPDo
+func (c *Controller) askTOTP(w http.ResponseWriter, r *http.Request, session *sessions.Session, user *db.User) {
+	delete(session.Values, "user")
+	session.Values["totp_user"] = user.ID
+	session.Values["totp_user_at"] = time.Now().Unix()
+	sessLogSave(session, w, r)
+	http.Redirect(w, r, c.Path("/admin/login_totp"), http.StatusSeeOther)
+}
+
+func (c *Controller) ServeLoginTOTP(r *http.Request) *Response {
+	session := r.Context().Value(CtxSession).(*sessions.Session)
+	if _, ok := session.Values["totp_user"].(int); !ok {
+		return &Response{redirect: "/admin/login"}
+	}
+	return &Response{template: "login_totp.tmpl"}
+}
+
+func (c *Controller) ServeLoginTOTPDo(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(CtxSession).(*sessions.Session)
+	fail := func(to, message string) {
+		sessAddFlashW(session, []string{message})
+		sessLogSave(session, w, r)
+		http.Redirect(w, r, c.Path(to), http.StatusSeeOther)
+	}
+	// set by askTOTP once the password or single sign-on was right
+	userID, _ := session.Values["totp_user"].(int)
+	passwordAt, _ := session.Values["totp_user_at"].(int64)
+	var user *db.User
+	if userID != 0 && time.Since(time.Unix(passwordAt, 0)) < totpLoginTimeout {
+		user = c.DB.GetUserByID(userID)
+	}
+	if user == nil || !user.HasTOTP() {
+		delete(session.Values, "totp_user")
+		delete(session.Values, "totp_user_at")
+		fail("/admin/login", "please log in again")
+		return
+	}
+	ip := c.ClientIP(r)
+	if wait := c.LoginLimiter.Wait(ip, user.Name); wait > 0 {
+		fail("/admin/login_totp", fmt.Sprintf("too many failed logins, please try again in %s", wait))
+		return
+	}
+	ok, err := c.checkTOTP(user, r.FormValue("code"))
+	if err != nil {
+		log.Printf("error checking code of %q: %v\n", user.Name, err)
+		fail("/admin/login_totp", "couldn't check code")
+		return
+	}
+	if !ok {
+		c.LoginLimiter.Fail(ip, user.Name)
+		fail("/admin/login_totp", "invalid code")
+		return
+	}
+	c.LoginLimiter.Succeed(user.Name)
+	delete(session.Values, "totp_user")
+	delete(session.Values, "totp_user_at")
+	session.Values["user"] = user.ID
+	sessLogSave(session, w, r)
+	http.Redirect(w, r, c.Path("/admin/home"), http.StatusSeeOther)
+}
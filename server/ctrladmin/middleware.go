@@ -27,6 +27,24 @@ func (c *Controller) WithUserSession(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// session exists at this point
 		session := r.Context().Value(CtxSession).(*sessions.Session)
+		// a trusted proxy says who's logged in, whoever was in the session before
+		if c.ProxyAuth != nil {
+			if username := c.proxyAuthUsername(r); username != "" {
+				user, err := c.proxyAuthUser(username)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("proxy auth: %v", err), http.StatusForbidden)
+					return
+				}
+				if session.Values["user"] != user.ID {
+					if user.HasTOTP() {
+						c.askTOTP(w, r, session, user)
+						return
+					}
+					session.Values["user"] = user.ID
+					sessLogSave(session, w, r)
+				}
+			}
+		}
 		userID, ok := session.Values["user"].(int)
 		if !ok {
 			sessAddFlashW(session, []string{"you are not authenticated"})
@@ -63,3 +81,20 @@ func (c *Controller) WithAdminSession(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (c *Controller) WithRoleSession(role db.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// session and user exist at this point
+			session := r.Context().Value(CtxSession).(*sessions.Session)
+			user := r.Context().Value(CtxUser).(*db.User)
+			if !user.HasRole(role) {
+				sessAddFlashW(session, []string{fmt.Sprintf("you don't have the %s role", role)})
+				sessLogSave(session, w, r)
+				http.Redirect(w, r, c.Path("/admin/home"), http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,12 +1,17 @@
 package ctrlbase
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"path"
+	"strings"
 
 	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/loginlimit"
+	"go.senan.xyz/gonic/paths"
 	"go.senan.xyz/gonic/scanner"
 )
 
@@ -48,6 +53,11 @@ type Controller struct {
 	DB          *db.DB
 	Scanner     *scanner.Scanner
 	ProxyPrefix string
+	MusicPaths  paths.MusicPaths
+	PasswordKey *db.PasswordKey // users' passwords are encrypted with it in the db
+
+	LoginLimiter   *loginlimit.Limiter
+	TrustedProxies []*net.IPNet // whose X-Forwarded-For is believed
 }
 
 // Path returns a URL path with the proxy prefix included
@@ -55,20 +65,80 @@ func (c *Controller) Path(rel string) string {
 	return path.Join(c.ProxyPrefix, rel)
 }
 
+// ClientIP returns the address of the client, following X-Forwarded-For through the
+// trusted proxies only. anyone else could set it to anything
+func (c *Controller) ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !c.IsTrustedProxy(ip) {
+		return ip
+	}
+	// each proxy appends who it got the request from, so the last untrusted address
+	// is the client
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(header, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				forwarded = append(forwarded, addr)
+			}
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip = forwarded[i]
+		if !c.IsTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// IsTrustedProxy returns whether the address is one of the trusted reverse proxies
+func (c *Controller) IsTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	for _, trusted := range c.TrustedProxies {
+		if trusted.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Controller) BaseURL(r *http.Request) string {
+	return baseURL(r, true)
+}
+
+// TrustedBaseURL is like BaseURL, but only believes X-Forwarded-Host and -Proto from
+// the trusted proxies. use it when a client setting them could send a user somewhere else
+func (c *Controller) TrustedBaseURL(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return baseURL(r, c.IsTrustedProxy(ip))
+}
+
+func baseURL(r *http.Request, forwarded bool) string {
 	protocol := "http"
 	if r.TLS != nil {
 		protocol = "https"
 	}
+	var forwardedProto, forwardedScheme, forwardedHost string
+	if forwarded {
+		forwardedProto = r.Header.Get("X-Forwarded-Proto")
+		forwardedScheme = r.Header.Get("X-Forwarded-Scheme")
+		forwardedHost = r.Header.Get("X-Forwarded-Host")
+	}
 	scheme := firstExisting(
 		protocol, // fallback
-		r.Header.Get("X-Forwarded-Proto"),
-		r.Header.Get("X-Forwarded-Scheme"),
+		forwardedProto,
+		forwardedScheme,
 		r.URL.Scheme,
 	)
 	host := firstExisting(
 		"localhost:4747", // fallback
-		r.Header.Get("X-Forwarded-Host"),
+		forwardedHost,
 		r.Host,
 	)
 	return fmt.Sprintf("%s://%s", scheme, host)
@@ -115,3 +185,26 @@ func firstExisting(or string, strings ...string) string {
 	}
 	return or
 }
+
+var (
+	errValiNoUsername        = errors.New("please enter a username")
+	errValiPasswordAllFields = errors.New("please enter the password twice")
+	errValiPasswordsNotSame  = errors.New("passwords entered were not the same")
+)
+
+func ValidateUsername(username string) error {
+	if username == "" {
+		return errValiNoUsername
+	}
+	return nil
+}
+
+func ValidatePasswords(pOne, pTwo string) error {
+	if pOne == "" || pTwo == "" {
+		return errValiPasswordAllFields
+	}
+	if !(pOne == pTwo) {
+		return errValiPasswordsNotSame
+	}
+	return nil
+}
@@ -10,8 +10,8 @@ import (
 	"net/http"
 
 	"go.senan.xyz/gonic/jukebox"
-	"go.senan.xyz/gonic/paths"
 	"go.senan.xyz/gonic/podcasts"
+	"go.senan.xyz/gonic/scanner/tags"
 	"go.senan.xyz/gonic/scrobble"
 	"go.senan.xyz/gonic/server/ctrlbase"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/params"
@@ -32,11 +32,11 @@ type Controller struct {
 	CachePath      string
 	CoverCachePath string
 	PodcastsPath   string
-	MusicPaths     paths.MusicPaths
 	Jukebox        *jukebox.Jukebox
 	Scrobblers     []scrobble.Scrobbler
 	Podcasts       *podcasts.Podcasts
 	Transcoder     transcode.Transcoder
+	CoverReader    tags.CoverReader // for the covers embedded in tracks
 }
 
 type metaResponse struct {
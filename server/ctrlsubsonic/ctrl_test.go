@@ -18,6 +18,7 @@ import (
 	jd "github.com/josephburnett/jd/lib"
 
 	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/loginlimit"
 	"go.senan.xyz/gonic/mockfs"
 	"go.senan.xyz/gonic/paths"
 	"go.senan.xyz/gonic/server/ctrlbase"
@@ -164,10 +165,9 @@ func makec(t *testing.T, roots []string, audio bool) *Controller {
 		absRoots = append(absRoots, paths.MusicPath{Alias: "", Path: filepath.Join(m.TmpDir(), root)})
 	}
 
-	base := &ctrlbase.Controller{DB: m.DB()}
+	base := &ctrlbase.Controller{DB: m.DB(), MusicPaths: absRoots, PasswordKey: m.PasswordKey(), LoginLimiter: loginlimit.New()}
 	contr := &Controller{
 		Controller: base,
-		MusicPaths: absRoots,
 		Transcoder: transcode.NewFFmpegTranscoder(),
 	}
 
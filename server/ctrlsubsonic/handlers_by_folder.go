@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/jinzhu/gorm"
 
@@ -25,9 +26,9 @@ func (c *Controller) ServeGetIndexes(r *http.Request) *spec.Response {
 		Select("id").
 		Model(&db.Album{}).
 		Where("parent_id IS NULL")
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
 		rootQ = rootQ.
-			Where("root_dir=?", m)
+			Where("root_dir IN (?)", m)
 	}
 	var folders []*db.Album
 	c.DB.
@@ -74,6 +75,9 @@ func (c *Controller) ServeGetMusicDirectory(r *http.Request) *spec.Response {
 		Preload("AlbumStar", "user_id=?", user.ID).
 		Preload("AlbumRating", "user_id=?", user.ID).
 		First(folder, id.Value)
+	if !user.HasMusicFolder(folder.RootDir) {
+		return spec.NewError(70, "couldn't find a folder with that id")
+	}
 	// start looking for child childFolders in the current dir
 	var childFolders []*db.Album
 	c.DB.
@@ -85,16 +89,22 @@ func (c *Controller) ServeGetMusicDirectory(r *http.Request) *spec.Response {
 	for _, ch := range childFolders {
 		childrenObj = append(childrenObj, spec.NewTCAlbumByFolder(ch))
 	}
-	// start looking for child childTracks in the current dir
+	// start looking for child childTracks in the current dir. the tracks of a disc
+	// folder belong to the album above, with the folder's name in their filename
+	discPrefix := folder.RightPath + "/"
 	var childTracks []*db.Track
 	c.DB.
-		Where("album_id=?", id.Value).
+		Where("(album_id=? AND filename NOT LIKE '%/%') OR (album_id=? AND substr(filename, 1, ?)=?)",
+			id.Value, folder.ParentID, utf8.RuneCountInString(discPrefix), discPrefix).
 		Preload("Album").
 		Preload("Album.TagArtist").
 		Preload("TrackStar", "user_id=?", user.ID).
 		Preload("TrackRating", "user_id=?", user.ID).
 		Order("filename").
 		Find(&childTracks)
+	if err := c.DB.LoadTrackPlays(user.ID, childTracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 
 	transcodeMIME, transcodeSuffix := streamGetTransPrefProfile(c.DB, user.ID, params.GetOr("c", ""))
 
@@ -146,21 +156,15 @@ func (c *Controller) ServeGetAlbumList(r *http.Request) *spec.Response {
 		q = q.Joins("JOIN genres ON genres.id=album_genres.genre_id AND genres.name=?", genre)
 		q = q.Order("right_path")
 	case "frequent":
-		q = q.Joins(`
-			JOIN plays
-			ON albums.id=plays.album_id AND plays.user_id=?`,
-			user.ID)
-		q = q.Order("plays.count DESC")
+		q = q.Joins("JOIN ("+albumTrackPlays+") album_plays ON albums.id=album_plays.album_id", user.ID)
+		q = q.Order("album_plays.play_count DESC")
 	case "newest":
 		q = q.Order("created_at DESC")
 	case "random":
 		q = q.Order(gorm.Expr("random()"))
 	case "recent":
-		q = q.Joins(`
-			JOIN plays
-			ON albums.id=plays.album_id AND plays.user_id=?`,
-			user.ID)
-		q = q.Order("plays.time DESC")
+		q = q.Joins("JOIN ("+albumTrackPlays+") album_plays ON albums.id=album_plays.album_id", user.ID)
+		q = q.Order("album_plays.played DESC")
 	case "starred":
 		q = q.Joins("JOIN album_stars ON albums.id=album_stars.album_id AND album_stars.user_id=?", user.ID)
 		q = q.Order("right_path")
@@ -168,8 +172,8 @@ func (c *Controller) ServeGetAlbumList(r *http.Request) *spec.Response {
 		return spec.NewError(10, "unknown value `%s` for parameter 'type'", v)
 	}
 
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where("root_dir IN (?)", m)
 	}
 	var folders []*db.Album
 	// TODO: think about removing this extra join to count number
@@ -211,8 +215,8 @@ func (c *Controller) ServeSearchTwo(r *http.Request) *spec.Response {
 		Select("id").
 		Model(&db.Album{}).
 		Where("parent_id IS NULL")
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		rootQ = rootQ.Where("root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		rootQ = rootQ.Where("root_dir IN (?)", m)
 	}
 
 	var artists []*db.Album
@@ -237,8 +241,8 @@ func (c *Controller) ServeSearchTwo(r *http.Request) *spec.Response {
 		Preload("AlbumRating", "user_id=?", user.ID).
 		Offset(params.GetOrInt("albumOffset", 0)).
 		Limit(params.GetOrInt("albumCount", 20))
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where("root_dir IN (?)", m)
 	}
 	if err := q.Find(&albums).Error; err != nil {
 		return spec.NewError(0, "find albums: %v", err)
@@ -256,14 +260,17 @@ func (c *Controller) ServeSearchTwo(r *http.Request) *spec.Response {
 		Preload("TrackRating", "user_id=?", user.ID).
 		Offset(params.GetOrInt("songOffset", 0)).
 		Limit(params.GetOrInt("songCount", 20))
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
 		q = q.
 			Joins("JOIN albums ON albums.id=tracks.album_id").
-			Where("albums.root_dir=?", m)
+			Where("albums.root_dir IN (?)", m)
 	}
 	if err := q.Find(&tracks).Error; err != nil {
 		return spec.NewError(0, "find tracks: %v", err)
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 
 	transcodeMIME, transcodeSuffix := streamGetTransPrefProfile(c.DB, user.ID, params.GetOr("c", ""))
 
@@ -294,8 +301,8 @@ func (c *Controller) ServeGetStarred(r *http.Request) *spec.Response {
 		Select("id").
 		Model(&db.Album{}).
 		Where("parent_id IS NULL")
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		rootQ = rootQ.Where("root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		rootQ = rootQ.Where("root_dir IN (?)", m)
 	}
 
 	var artists []*db.Album
@@ -320,8 +327,8 @@ func (c *Controller) ServeGetStarred(r *http.Request) *spec.Response {
 		Where("album_stars.user_id=?", user.ID).
 		Preload("AlbumStar", "user_id=?", user.ID).
 		Preload("AlbumRating", "user_id=?", user.ID)
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where("root_dir IN (?)", m)
 	}
 	if err := q.Find(&albums).Error; err != nil {
 		return spec.NewError(0, "find albums: %v", err)
@@ -338,14 +345,17 @@ func (c *Controller) ServeGetStarred(r *http.Request) *spec.Response {
 		Where("track_stars.user_id=?", user.ID).
 		Preload("TrackStar", "user_id=?", user.ID).
 		Preload("TrackRating", "user_id=?", user.ID)
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
 		q = q.
 			Joins("JOIN albums ON albums.id=tracks.album_id").
-			Where("albums.root_dir=?", m)
+			Where("albums.root_dir IN (?)", m)
 	}
 	if err := q.Find(&tracks).Error; err != nil {
 		return spec.NewError(0, "find tracks: %v", err)
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 
 	transcodeMIME, transcodeSuffix := streamGetTransPrefProfile(c.DB, user.ID, params.GetOr("c", ""))
 
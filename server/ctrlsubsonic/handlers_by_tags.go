@@ -24,14 +24,15 @@ func (c *Controller) ServeGetArtists(r *http.Request) *spec.Response {
 	user := r.Context().Value(CtxUser).(*db.User)
 	var artists []*db.Artist
 	q := c.DB.
-		Select("*, count(sub.id) album_count").
-		Joins("LEFT JOIN albums sub ON artists.id=sub.tag_artist_id").
+		Select("artists.*, count(sub.id) album_count").
+		Joins("JOIN "+artistAlbums+" ON artist_albums.artist_id=artists.id").
+		Joins("JOIN albums sub ON sub.id=artist_albums.album_id").
 		Preload("ArtistStar", "user_id=?", user.ID).
 		Preload("ArtistRating", "user_id=?", user.ID).
 		Group("artists.id").
 		Order("artists.name COLLATE NOCASE")
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("sub.root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where("sub.root_dir IN (?)", m)
 	}
 	if err := q.Find(&artists).Error; err != nil {
 		return spec.NewError(10, "error finding artists: %v", err)
@@ -66,18 +67,24 @@ func (c *Controller) ServeGetArtist(r *http.Request) *spec.Response {
 	}
 	artist := &db.Artist{}
 	c.DB.
-		Preload("Albums", func(db *gorm.DB) *gorm.DB {
-			return db.
-				Select("*, count(sub.id) child_count, sum(sub.length) duration").
-				Joins("LEFT JOIN tracks sub ON albums.id=sub.album_id").
-				Preload("AlbumStar", "user_id=?", user.ID).
-				Preload("AlbumRating", "user_id=?", user.ID).
-				Order("albums.right_path").
-				Group("albums.id")
-		}).
 		Preload("ArtistStar", "user_id=?", user.ID).
 		Preload("ArtistRating", "user_id=?", user.ID).
 		First(artist, id.Value)
+	q := c.DB.
+		Select("albums.*, count(sub.id) child_count, sum(sub.length) duration").
+		Joins("JOIN "+artistAlbums+" ON artist_albums.album_id=albums.id AND artist_albums.artist_id=?", artist.ID).
+		Joins("LEFT JOIN tracks sub ON albums.id=sub.album_id").
+		Preload("AlbumStar", "user_id=?", user.ID).
+		Preload("AlbumRating", "user_id=?", user.ID).
+		Order("albums.right_path").
+		Group("albums.id")
+	if m := musicFolders(c.MusicPaths, user, nil); m != nil {
+		q = q.Where("albums.root_dir IN (?)", m)
+	}
+	q.Find(&artist.Albums)
+	if user.IsRestricted() && len(artist.Albums) == 0 {
+		return spec.NewError(70, "couldn't find an artist with that id")
+	}
 	sub := spec.NewResponse()
 	sub.Artist = spec.NewArtistByTags(artist)
 	sub.Artist.Albums = make([]*spec.Album, len(artist.Albums))
@@ -111,9 +118,12 @@ func (c *Controller) ServeGetAlbum(r *http.Request) *spec.Response {
 		Preload("AlbumRating", "user_id=?", user.ID).
 		First(album, id.Value).
 		Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
+	if errors.Is(err, gorm.ErrRecordNotFound) || !user.HasMusicFolder(album.RootDir) {
 		return spec.NewError(10, "couldn't find an album with that id")
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, album.Tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 	sub := spec.NewResponse()
 	sub.Album = spec.NewAlbumByTags(album, album.TagArtist)
 	sub.Album.Tracks = make([]*spec.TrackChild, len(album.Tracks))
@@ -128,6 +138,15 @@ func (c *Controller) ServeGetAlbum(r *http.Request) *spec.Response {
 	return sub
 }
 
+// albumTrackPlays sums the user's track plays by album, for the frequent and recent lists of
+// getAlbumList and getAlbumList2
+const albumTrackPlays = `
+	SELECT tracks.album_id, count(*) play_count, max(track_plays.time) played
+	FROM track_plays
+	JOIN tracks ON tracks.id=track_plays.track_id
+	WHERE track_plays.user_id=?
+	GROUP BY tracks.album_id`
+
 // ServeGetAlbumListTwo handles the getAlbumList2 view.
 // changes to this function should be reflected in in _by_folder.go's
 // getAlbumList() function
@@ -161,25 +180,25 @@ func (c *Controller) ServeGetAlbumListTwo(r *http.Request) *spec.Response {
 		q = q.Joins("JOIN genres ON genres.id=album_genres.genre_id AND genres.name=?", genre)
 		q = q.Order("tag_title")
 	case "frequent":
-		user := r.Context().Value(CtxUser).(*db.User)
-		q = q.Joins("JOIN plays ON albums.id=plays.album_id AND plays.user_id=?", user.ID)
-		q = q.Order("plays.count DESC")
+		q = q.Joins("JOIN ("+albumTrackPlays+") album_plays ON albums.id=album_plays.album_id", user.ID)
+		q = q.Order("album_plays.play_count DESC")
 	case "newest":
 		q = q.Order("created_at DESC")
 	case "random":
 		q = q.Order(gorm.Expr("random()"))
 	case "recent":
-		q = q.Joins("JOIN plays ON albums.id=plays.album_id AND plays.user_id=?", user.ID)
-		q = q.Order("plays.time DESC")
+		q = q.Joins("JOIN ("+albumTrackPlays+") album_plays ON albums.id=album_plays.album_id", user.ID)
+		q = q.Order("album_plays.played DESC")
 	case "starred":
 		q = q.Joins("JOIN album_stars ON albums.id=album_stars.album_id AND album_stars.user_id=?", user.ID)
 		q = q.Order("tag_title")
 	default:
 		return spec.NewError(10, "unknown value `%s` for parameter 'type'", listType)
 	}
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where("root_dir IN (?)", m)
 	}
+	q = albumListFilters(q, params)
 	var albums []*db.Album
 	// TODO: think about removing this extra join to count number
 	// of children. it might make sense to store that in the db
@@ -204,6 +223,27 @@ func (c *Controller) ServeGetAlbumListTwo(r *http.Request) *spec.Response {
 	return sub
 }
 
+// albumListFilters narrows down an album list with the optional params that can go
+// with any list type
+func albumListFilters(q *gorm.DB, params params.Params) *gorm.DB {
+	if composer, err := params.Get("composer"); err == nil {
+		q = q.Where("albums.id IN (SELECT album_id FROM tracks WHERE tag_composer=?)", composer)
+	}
+	if label, err := params.Get("label"); err == nil {
+		q = q.Where("albums.tag_label=?", label)
+	}
+	if compilation, err := params.GetBool("compilation"); err == nil {
+		q = q.Where("albums.tag_compilation=?", compilation)
+	}
+	for _, releaseType := range params.GetOrList("releaseType", nil) {
+		q = q.Where("';' || albums.tag_release_type || ';' LIKE ?", "%;"+strings.ToLower(releaseType)+";%")
+	}
+	for _, releaseType := range params.GetOrList("excludeReleaseType", nil) {
+		q = q.Where("';' || coalesce(albums.tag_release_type, '') || ';' NOT LIKE ?", "%;"+strings.ToLower(releaseType)+";%")
+	}
+	return q
+}
+
 func (c *Controller) ServeSearchThree(r *http.Request) *spec.Response {
 	params := r.Context().Value(CtxParams).(params.Params)
 	user := r.Context().Value(CtxUser).(*db.User)
@@ -218,16 +258,17 @@ func (c *Controller) ServeSearchThree(r *http.Request) *spec.Response {
 	// search artists
 	var artists []*db.Artist
 	q := c.DB.
-		Select("*, count(albums.id) album_count").
+		Select("artists.*, count(albums.id) album_count").
 		Group("artists.id").
 		Where("name LIKE ? OR name_u_dec LIKE ?", query, query).
-		Joins("JOIN albums ON albums.tag_artist_id=artists.id").
+		Joins("JOIN "+artistAlbums+" ON artist_albums.artist_id=artists.id").
+		Joins("JOIN albums ON albums.id=artist_albums.album_id").
 		Preload("ArtistStar", "user_id=?", user.ID).
 		Preload("ArtistRating", "user_id=?", user.ID).
 		Offset(params.GetOrInt("artistOffset", 0)).
 		Limit(params.GetOrInt("artistCount", 20))
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("albums.root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where("albums.root_dir IN (?)", m)
 	}
 	if err := q.Find(&artists).Error; err != nil {
 		return spec.NewError(0, "find artists: %v", err)
@@ -246,8 +287,8 @@ func (c *Controller) ServeSearchThree(r *http.Request) *spec.Response {
 		Where("tag_title LIKE ? OR tag_title_u_dec LIKE ?", query, query).
 		Offset(params.GetOrInt("albumOffset", 0)).
 		Limit(params.GetOrInt("albumCount", 20))
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where("root_dir IN (?)", m)
 	}
 	if err := q.Find(&albums).Error; err != nil {
 		return spec.NewError(0, "find albums: %v", err)
@@ -264,17 +305,20 @@ func (c *Controller) ServeSearchThree(r *http.Request) *spec.Response {
 		Preload("Genres").
 		Preload("TrackStar", "user_id=?", user.ID).
 		Preload("TrackRating", "user_id=?", user.ID).
-		Where("tag_title LIKE ? OR tag_title_u_dec LIKE ?", query, query).
+		Where("tracks.tag_title LIKE ? OR tracks.tag_title_u_dec LIKE ?", query, query).
 		Offset(params.GetOrInt("songOffset", 0)).
 		Limit(params.GetOrInt("songCount", 20))
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
 		q = q.
 			Joins("JOIN albums ON albums.id=tracks.album_id").
-			Where("albums.root_dir=?", m)
+			Where("albums.root_dir IN (?)", m)
 	}
 	if err := q.Find(&tracks).Error; err != nil {
 		return spec.NewError(0, "find tracks: %v", err)
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 
 	transcodeMIME, transcodeSuffix := streamGetTransPrefProfile(c.DB, user.ID, params.GetOr("c", ""))
 
@@ -292,6 +336,7 @@ func (c *Controller) ServeSearchThree(r *http.Request) *spec.Response {
 
 func (c *Controller) ServeGetArtistInfoTwo(r *http.Request) *spec.Response {
 	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
 	id, err := params.GetID("id")
 	if err != nil {
 		return spec.NewError(10, "please provide an `id` parameter")
@@ -305,6 +350,9 @@ func (c *Controller) ServeGetArtistInfoTwo(r *http.Request) *spec.Response {
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return spec.NewError(70, "artist with id `%s` not found", id)
 	}
+	if ok, err := c.userHasArtist(user, artist.ID); err != nil || !ok {
+		return spec.NewError(70, "artist with id `%s` not found", id)
+	}
 
 	sub := spec.NewResponse()
 	sub.ArtistInfoTwo = &spec.ArtistInfo{}
@@ -377,11 +425,27 @@ func (c *Controller) ServeGetArtistInfoTwo(r *http.Request) *spec.Response {
 }
 
 func (c *Controller) ServeGetGenres(r *http.Request) *spec.Response {
-	var genres []*db.Genre
-	c.DB.
+	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
+	q := c.DB.
 		Select(`*,
 			(SELECT count(1) FROM album_genres WHERE genre_id=genres.id) album_count,
-			(SELECT count(1) FROM track_genres WHERE genre_id=genres.id) track_count`).
+			(SELECT count(1) FROM track_genres WHERE genre_id=genres.id) track_count`)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		// only count what the user can see, and leave out genres they can't see at all
+		q = c.DB.
+			Select(`*,
+				(SELECT count(1) FROM album_genres
+					JOIN albums ON albums.id=album_genres.album_id
+					WHERE genre_id=genres.id AND albums.root_dir IN (?)) album_count,
+				(SELECT count(1) FROM track_genres
+					JOIN tracks ON tracks.id=track_genres.track_id
+					JOIN albums ON albums.id=tracks.album_id
+					WHERE genre_id=genres.id AND albums.root_dir IN (?)) track_count`, m, m).
+			Having("track_count > 0")
+	}
+	var genres []*db.Genre
+	q.
 		Group("genres.id").
 		Find(&genres)
 	sub := spec.NewResponse()
@@ -412,12 +476,15 @@ func (c *Controller) ServeGetSongsByGenre(r *http.Request) *spec.Response {
 		Preload("TrackRating", "user_id=?", user.ID).
 		Offset(params.GetOrInt("offset", 0)).
 		Limit(params.GetOrInt("count", 10))
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("albums.root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where("albums.root_dir IN (?)", m)
 	}
 	if err := q.Find(&tracks).Error; err != nil {
 		return spec.NewError(0, "error finding tracks: %v", err)
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 	sub := spec.NewResponse()
 	sub.TracksByGenre = &spec.TracksByGenre{
 		List: make([]*spec.TrackChild, len(tracks)),
@@ -448,8 +515,11 @@ func (c *Controller) ServeGetStarredTwo(r *http.Request) *spec.Response {
 		Where("artist_stars.user_id=?", user.ID).
 		Preload("ArtistStar", "user_id=?", user.ID).
 		Preload("ArtistRating", "user_id=?", user.ID)
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("albums.root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where(`artists.id IN (
+			SELECT artist_albums.artist_id FROM `+artistAlbums+`
+			JOIN albums ON albums.id=artist_albums.album_id
+			WHERE albums.root_dir IN (?))`, m)
 	}
 	if err := q.Find(&artists).Error; err != nil {
 		return spec.NewError(0, "find artists: %v", err)
@@ -466,8 +536,8 @@ func (c *Controller) ServeGetStarredTwo(r *http.Request) *spec.Response {
 		Preload("TagArtist").
 		Preload("AlbumStar", "user_id=?", user.ID).
 		Preload("AlbumRating", "user_id=?", user.ID)
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("albums.root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where("albums.root_dir IN (?)", m)
 	}
 	if err := q.Find(&albums).Error; err != nil {
 		return spec.NewError(0, "find albums: %v", err)
@@ -484,14 +554,17 @@ func (c *Controller) ServeGetStarredTwo(r *http.Request) *spec.Response {
 		Preload("Album").
 		Preload("TrackStar", "user_id=?", user.ID).
 		Preload("TrackRating", "user_id=?", user.ID)
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
 		q = q.
 			Joins("JOIN albums ON albums.id=tracks.album_id").
-			Where("albums.root_dir=?", m)
+			Where("albums.root_dir IN (?)", m)
 	}
 	if err := q.Find(&tracks).Error; err != nil {
 		return spec.NewError(0, "find tracks: %v", err)
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 
 	transcodeMIME, transcodeSuffix := streamGetTransPrefProfile(c.DB, user.ID, params.GetOr("c", ""))
 
@@ -550,17 +623,26 @@ func (c *Controller) ServeGetTopSongs(r *http.Request) *spec.Response {
 	}
 
 	var tracks []*db.Track
-	err = c.DB.
+	q := c.DB.
 		Preload("Album").
 		Where("artist_id=? AND tracks.tag_title IN (?)", artist.ID, topTrackNames).
 		Limit(count).
 		Preload("TrackStar", "user_id=?", user.ID).
-		Preload("TrackRating", "user_id=?", user.ID).
+		Preload("TrackRating", "user_id=?", user.ID)
+	if m := musicFolders(c.MusicPaths, user, nil); m != nil {
+		q = q.
+			Joins("JOIN albums ON albums.id=tracks.album_id").
+			Where("albums.root_dir IN (?)", m)
+	}
+	err = q.
 		Find(&tracks).
 		Error
 	if err != nil {
 		return spec.NewError(0, "error finding tracks: %v", err)
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 	if len(tracks) == 0 {
 		return spec.NewError(70, "no tracks found matching last fm top songs for artist: %s", artist.Name)
 	}
@@ -600,7 +682,7 @@ func (c *Controller) ServeGetSimilarSongs(r *http.Request) *spec.Response {
 		Where("id=?", id.Value).
 		First(&track).
 		Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !user.HasMusicFolder(track.Album.RootDir)) {
 		return spec.NewError(10, "couldn't find a track with that id")
 	}
 
@@ -618,7 +700,7 @@ func (c *Controller) ServeGetSimilarSongs(r *http.Request) *spec.Response {
 	}
 
 	var tracks []*db.Track
-	err = c.DB.
+	q := c.DB.
 		Preload("Artist").
 		Preload("Album").
 		Preload("TrackStar", "user_id=?", user.ID).
@@ -626,12 +708,21 @@ func (c *Controller) ServeGetSimilarSongs(r *http.Request) *spec.Response {
 		Select("tracks.*").
 		Where("tracks.tag_title IN (?)", similarTrackNames).
 		Order(gorm.Expr("random()")).
-		Limit(count).
+		Limit(count)
+	if m := musicFolders(c.MusicPaths, user, nil); m != nil {
+		q = q.
+			Joins("JOIN albums ON albums.id=tracks.album_id").
+			Where("albums.root_dir IN (?)", m)
+	}
+	err = q.
 		Find(&tracks).
 		Error
 	if err != nil {
 		return spec.NewError(0, "error finding tracks: %v", err)
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 	if len(tracks) == 0 {
 		return spec.NewError(70, "no similar song could be match with collection in database: %v", track.TagTitle)
 	}
@@ -673,6 +764,9 @@ func (c *Controller) ServeGetSimilarSongsTwo(r *http.Request) *spec.Response {
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return spec.NewError(0, "artist with id `%s` not found", id)
 	}
+	if ok, err := c.userHasArtist(user, artist.ID); err != nil || !ok {
+		return spec.NewError(0, "artist with id `%s` not found", id)
+	}
 
 	similarArtists, err := lastfm.ArtistGetSimilar(apiKey, artist.Name)
 	if err != nil {
@@ -688,19 +782,28 @@ func (c *Controller) ServeGetSimilarSongsTwo(r *http.Request) *spec.Response {
 	}
 
 	var tracks []*db.Track
-	err = c.DB.
+	q := c.DB.
 		Preload("Album").
 		Preload("TrackStar", "user_id=?", user.ID).
 		Preload("TrackRating", "user_id=?", user.ID).
 		Joins("JOIN artists on tracks.artist_id=artists.id").
 		Where("artists.name IN (?)", artistNames).
 		Order(gorm.Expr("random()")).
-		Limit(count).
+		Limit(count)
+	if m := musicFolders(c.MusicPaths, user, nil); m != nil {
+		q = q.
+			Joins("JOIN albums ON albums.id=tracks.album_id").
+			Where("albums.root_dir IN (?)", m)
+	}
+	err = q.
 		Find(&tracks).
 		Error
 	if err != nil {
 		return spec.NewError(0, "error finding tracks: %v", err)
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 	if len(tracks) == 0 {
 		return spec.NewError(70, "no similar song could be match with collection in database: %v", artist.Name)
 	}
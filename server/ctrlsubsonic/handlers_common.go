@@ -3,6 +3,7 @@ package ctrlsubsonic
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"math"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 	"github.com/jinzhu/gorm"
 
 	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/lyrics"
 	"go.senan.xyz/gonic/multierr"
 	"go.senan.xyz/gonic/paths"
 	"go.senan.xyz/gonic/scanner"
@@ -30,15 +32,46 @@ func lowerUDecOrHash(in string) string {
 	return string(lower)
 }
 
-func getMusicFolder(musicPaths paths.MusicPaths, p params.Params) string {
-	idx, err := p.GetInt("musicFolderId")
-	if err != nil {
-		return ""
+// musicFolders returns the music paths a request can see, from the user's music folders
+// and the musicFolderId param if there is one. nil means every path
+func musicFolders(musicPaths paths.MusicPaths, user *db.User, p params.Params) []string {
+	if idx, err := p.GetInt("musicFolderId"); err == nil && idx >= 0 && idx < len(musicPaths) {
+		musicPaths = musicPaths[idx : idx+1]
+	} else if !user.IsRestricted() {
+		return nil
 	}
-	if idx < 0 || idx >= len(musicPaths) {
-		return ""
+	ret := []string{}
+	for _, musicPath := range musicPaths {
+		if user.HasMusicFolder(musicPath.Path) {
+			ret = append(ret, musicPath.Path)
+		}
 	}
-	return musicPaths[idx].Path
+	return ret
+}
+
+// artistAlbums is every album credited to an artist, as one of its album artists or as an
+// artist of any of its tracks, so that an album is listed under every artist on it
+const artistAlbums = `(
+	SELECT album_id, artist_id FROM album_artists
+	UNION
+	SELECT tracks.album_id, track_artists.artist_id FROM track_artists
+	JOIN tracks ON tracks.id=track_artists.track_id
+) artist_albums`
+
+// userHasArtist returns whether any of the artist's albums are in the user's music folders
+func (c *Controller) userHasArtist(user *db.User, artistID int) (bool, error) {
+	q := c.DB.
+		Model(db.Album{}).
+		Joins("JOIN "+artistAlbums+" ON artist_albums.album_id=albums.id").
+		Where("artist_albums.artist_id=?", artistID)
+	if m := musicFolders(c.MusicPaths, user, nil); m != nil {
+		q = q.Where("albums.root_dir IN (?)", m)
+	}
+	var count int
+	if err := q.Count(&count).Error; err != nil {
+		return false, fmt.Errorf("count artist albums: %w", err)
+	}
+	return count > 0, nil
 }
 
 func (c *Controller) ServeGetLicence(r *http.Request) *spec.Response {
@@ -66,6 +99,9 @@ func (c *Controller) ServeScrobble(r *http.Request) *spec.Response {
 	if err := c.DB.Preload("Album").Preload("Artist").First(track, id.Value).Error; err != nil {
 		return spec.NewError(0, "error finding track: %v", err)
 	}
+	if !user.HasMusicFolder(track.Album.RootDir) {
+		return spec.NewError(70, "couldn't find a track with that id")
+	}
 
 	optStamp := params.GetOrTime("time", time.Now())
 	optSubmission := params.GetOrBool("submission", true)
@@ -73,6 +109,14 @@ func (c *Controller) ServeScrobble(r *http.Request) *spec.Response {
 	if err := streamUpdateStats(c.DB, user.ID, track.Album.ID, optStamp); err != nil {
 		return spec.NewError(0, "error updating stats: %v", err)
 	}
+	if optSubmission {
+		if err := streamUpdateTrackStats(c.DB, user.ID, track, optStamp); err != nil {
+			return spec.NewError(0, "error updating track play history: %v", err)
+		}
+	}
+	if err := updateNowPlaying(c.DB, user.ID, params.GetOr("c", ""), track, optStamp, optSubmission); err != nil {
+		return spec.NewError(0, "error updating now playing: %v", err)
+	}
 
 	var scrobbleErrs multierr.Err
 	for _, scrobbler := range c.Scrobblers {
@@ -87,12 +131,104 @@ func (c *Controller) ServeScrobble(r *http.Request) *spec.Response {
 	return spec.NewResponse()
 }
 
+// updateNowPlaying sets the track the user's client is playing until it would have finished,
+// or clears it once the client submits the track as played
+func updateNowPlaying(dbc *db.DB, userID int, client string, track *db.Track, playTime time.Time, submission bool) error {
+	now := time.Now()
+	if err := dbc.Where("expires<?", now).Delete(db.NowPlayingEntry{}).Error; err != nil {
+		return fmt.Errorf("delete expired: %w", err)
+	}
+	if submission {
+		err := dbc.
+			Where("user_id=? AND client=? AND track_id=?", userID, client, track.ID).
+			Delete(db.NowPlayingEntry{}).
+			Error
+		if err != nil {
+			return fmt.Errorf("delete finished: %w", err)
+		}
+		return nil
+	}
+
+	entry := db.NowPlayingEntry{
+		UserID:  userID,
+		Client:  client,
+		TrackID: track.ID,
+		Time:    playTime,
+		Expires: playTime.Add(time.Duration(track.Length)*time.Second + trackPlayMergeGrace),
+	}
+	if !entry.Expires.After(now) {
+		return nil
+	}
+	if err := dbc.Where("user_id=? AND client=?", userID, client).Delete(db.NowPlayingEntry{}).Error; err != nil {
+		return fmt.Errorf("delete previous: %w", err)
+	}
+	if err := dbc.Create(&entry).Error; err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	return nil
+}
+
+func (c *Controller) ServeGetNowPlaying(r *http.Request) *spec.Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	var entries []*db.NowPlayingEntry
+	err := c.DB.
+		Where("expires>?", time.Now()).
+		Preload("User").
+		Preload("Track").
+		Preload("Track.Album").
+		Preload("Track.Album.TagArtist").
+		Preload("Track.TrackStar", "user_id=?", user.ID).
+		Preload("Track.TrackRating", "user_id=?", user.ID).
+		Order("time DESC").
+		Find(&entries).
+		Error
+	if err != nil {
+		return spec.NewError(0, "error finding now playing: %v", err)
+	}
+	tracks := make([]*db.Track, 0, len(entries))
+	for _, entry := range entries {
+		tracks = append(tracks, entry.Track)
+	}
+	if err := c.DB.LoadTrackPlays(user.ID, tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
+	sub := spec.NewResponse()
+	sub.NowPlaying = &spec.NowPlaying{
+		List: []*spec.NowPlayingEntry{},
+	}
+	for _, entry := range entries {
+		if !user.HasMusicFolder(entry.Track.Album.RootDir) {
+			continue
+		}
+		sub.NowPlaying.List = append(sub.NowPlaying.List, &spec.NowPlayingEntry{
+			TrackChild: spec.NewTrackByTags(entry.Track, entry.Track.Album),
+			Username:   entry.User.Name,
+			MinutesAgo: int(time.Since(entry.Time).Minutes()),
+			PlayerID:   nowPlayingPlayerID(entry.UserID, entry.Client),
+			PlayerName: entry.Client,
+		})
+	}
+	return sub
+}
+
+// nowPlayingPlayerID is an id for the user's client that stays the same from track to track,
+// since an entry is replaced with each one, and the client only has a name
+func nowPlayingPlayerID(userID int, client string) int {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d/%s", userID, client)
+	return int(h.Sum32() & math.MaxInt32)
+}
+
 func (c *Controller) ServeGetMusicFolders(r *http.Request) *spec.Response {
+	user := r.Context().Value(CtxUser).(*db.User)
 	sub := spec.NewResponse()
 	sub.MusicFolders = &spec.MusicFolders{}
-	sub.MusicFolders.List = make([]*spec.MusicFolder, len(c.MusicPaths))
+	sub.MusicFolders.List = []*spec.MusicFolder{}
 	for i, path := range c.MusicPaths {
-		sub.MusicFolders.List[i] = &spec.MusicFolder{ID: i, Name: path.DisplayAlias()}
+		if !user.HasMusicFolder(path.Path) {
+			continue
+		}
+		sub.MusicFolders.List = append(sub.MusicFolders.List, &spec.MusicFolder{ID: i, Name: path.DisplayAlias()})
 	}
 	return sub
 }
@@ -107,33 +243,26 @@ func (c *Controller) ServeStartScan(r *http.Request) *spec.Response {
 }
 
 func (c *Controller) ServeGetScanStatus(r *http.Request) *spec.Response {
-	var trackCount int
-	if err := c.DB.Model(db.Track{}).Count(&trackCount).Error; err != nil {
-		return spec.NewError(0, "error finding track count: %v", err)
-	}
-
 	sub := spec.NewResponse()
 	sub.ScanStatus = &spec.ScanStatus{
 		Scanning: c.Scanner.IsScanning(),
-		Count:    trackCount,
 	}
-	return sub
-}
-
-func (c *Controller) ServeGetUser(r *http.Request) *spec.Response {
-	user := r.Context().Value(CtxUser).(*db.User)
-	hasLastFM := user.LastFMSession != ""
-	hasListenBrainz := user.ListenBrainzToken != ""
+	if sub.ScanStatus.Scanning {
+		// count what the running scan has been through so far
+		sub.ScanStatus.FolderCount, sub.ScanStatus.Count = c.Scanner.Progress()
+	} else {
+		if err := c.DB.Model(db.Track{}).Count(&sub.ScanStatus.Count).Error; err != nil {
+			return spec.NewError(0, "error finding track count: %v", err)
+		}
+	}
 
-	sub := spec.NewResponse()
-	sub.User = &spec.User{
-		Username:          user.Name,
-		AdminRole:         user.IsAdmin,
-		JukeboxRole:       c.Jukebox != nil,
-		PodcastRole:       c.Podcasts != nil,
-		DownloadRole:      true,
-		ScrobblingEnabled: hasLastFM || hasListenBrainz,
-		Folder:            []int{1},
+	var lastScan db.Scan
+	err := c.DB.Order("started_at DESC").First(&lastScan).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return spec.NewError(0, "error finding last scan: %v", err)
+	}
+	if err == nil {
+		sub.ScanStatus.LastScan = &lastScan.FinishedAt
 	}
 	return sub
 }
@@ -162,11 +291,11 @@ func (c *Controller) ServeGetPlayQueue(r *http.Request) *spec.Response {
 	sub.PlayQueue.ChangedBy = queue.ChangedBy
 
 	trackIDs := queue.GetItems()
-	sub.PlayQueue.List = make([]*spec.TrackChild, len(trackIDs))
+	sub.PlayQueue.List = make([]*spec.TrackChild, 0, len(trackIDs))
 
 	transcodeMIME, transcodeSuffix := streamGetTransPrefProfile(c.DB, user.ID, params.GetOr("c", ""))
 
-	for i, id := range trackIDs {
+	for _, id := range trackIDs {
 		track := db.Track{}
 		c.DB.
 			Where("id=?", id).
@@ -174,9 +303,16 @@ func (c *Controller) ServeGetPlayQueue(r *http.Request) *spec.Response {
 			Preload("TrackStar", "user_id=?", user.ID).
 			Preload("TrackRating", "user_id=?", user.ID).
 			Find(&track)
-		sub.PlayQueue.List[i] = spec.NewTCTrackByFolder(&track, track.Album)
-		sub.PlayQueue.List[i].TranscodedContentType = transcodeMIME
-		sub.PlayQueue.List[i].TranscodedSuffix = transcodeSuffix
+		if track.Album == nil || !user.HasMusicFolder(track.Album.RootDir) {
+			continue
+		}
+		if err := c.DB.LoadTrackPlays(user.ID, &track); err != nil {
+			return spec.NewError(0, "load track plays: %v", err)
+		}
+		trackChild := spec.NewTCTrackByFolder(&track, track.Album)
+		trackChild.TranscodedContentType = transcodeMIME
+		trackChild.TranscodedSuffix = transcodeSuffix
+		sub.PlayQueue.List = append(sub.PlayQueue.List, trackChild)
 	}
 	return sub
 }
@@ -225,9 +361,12 @@ func (c *Controller) ServeGetSong(r *http.Request) *spec.Response {
 		Preload("TrackRating", "user_id=?", user.ID).
 		First(&track).
 		Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !user.HasMusicFolder(track.Album.RootDir)) {
 		return spec.NewError(10, "couldn't find a track with that id")
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, &track); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 	sub := spec.NewResponse()
 	sub.Track = spec.NewTrackByTags(&track, track.Album)
 	return sub
@@ -255,12 +394,15 @@ func (c *Controller) ServeGetRandomSongs(r *http.Request) *spec.Response {
 		q = q.Joins("JOIN track_genres ON track_genres.track_id=tracks.id")
 		q = q.Joins("JOIN genres ON genres.id=track_genres.genre_id AND genres.name=?", genre)
 	}
-	if m := getMusicFolder(c.MusicPaths, params); m != "" {
-		q = q.Where("albums.root_dir=?", m)
+	if m := musicFolders(c.MusicPaths, user, params); m != nil {
+		q = q.Where("albums.root_dir IN (?)", m)
 	}
 	if err := q.Find(&tracks).Error; err != nil {
 		return spec.NewError(10, "get random songs: %v", err)
 	}
+	if err := c.DB.LoadTrackPlays(user.ID, tracks...); err != nil {
+		return spec.NewError(0, "load track plays: %v", err)
+	}
 	sub := spec.NewResponse()
 	sub.RandomTracks = &spec.RandomTracks{}
 	sub.RandomTracks.List = make([]*spec.TrackChild, len(tracks))
@@ -285,6 +427,9 @@ func (c *Controller) ServeJukebox(r *http.Request) *spec.Response { // nolint:go
 			if err := c.DB.Preload("Album").Preload("TrackStar", "user_id=?", user.ID).Preload("TrackRating", "user_id=?", user.ID).First(&track, id.Value).Error; err != nil {
 				return nil, fmt.Errorf("find track by id: %w", err)
 			}
+			if !user.HasMusicFolder(track.Album.RootDir) {
+				return nil, fmt.Errorf("find track by id: %w", gorm.ErrRecordNotFound)
+			}
 			paths = append(paths, track.AbsPath())
 		}
 		return paths, nil
@@ -409,7 +554,92 @@ func (c *Controller) ServeJukebox(r *http.Request) *spec.Response { // nolint:go
 }
 
 func (c *Controller) ServeGetLyrics(r *http.Request) *spec.Response {
+	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
+	artist, _ := params.Get("artist")
+	title, _ := params.Get("title")
 	sub := spec.NewResponse()
 	sub.Lyrics = &spec.Lyrics{}
+	if artist == "" && title == "" {
+		return sub
+	}
+	q := c.DB.
+		Select("tracks.*").
+		Joins("JOIN albums ON albums.id=tracks.album_id").
+		Joins("LEFT JOIN artists ON artists.id=albums.tag_artist_id").
+		Where("tracks.lyrics IS NOT NULL AND tracks.lyrics != ''").
+		Preload("Album").
+		Preload("Album.TagArtist")
+	if title != "" {
+		q = q.Where("tracks.tag_title=? COLLATE NOCASE", title)
+	}
+	if artist != "" {
+		q = q.Where("tracks.tag_track_artist=? COLLATE NOCASE OR artists.name=? COLLATE NOCASE", artist, artist)
+	}
+	if m := musicFolders(c.MusicPaths, user, nil); m != nil {
+		q = q.Where("albums.root_dir IN (?)", m)
+	}
+	var track db.Track
+	err := q.First(&track).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return sub
+	}
+	if err != nil {
+		return spec.NewError(0, "find track: %v", err)
+	}
+	sub.Lyrics.Artist = trackDisplayArtist(&track)
+	sub.Lyrics.Title = track.TagTitle
+	sub.Lyrics.Value = lyrics.Parse(track.Lyrics).Text()
+	return sub
+}
+
+func (c *Controller) ServeGetLyricsBySongID(r *http.Request) *spec.Response {
+	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
+	id, err := params.GetID("id")
+	if err != nil || id.Type != specid.Track {
+		return spec.NewError(10, "please provide a valid track id")
+	}
+	var track db.Track
+	err = c.DB.
+		Where("id=?", id.Value).
+		Preload("Album").
+		Preload("Album.TagArtist").
+		First(&track).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !user.HasMusicFolder(track.Album.RootDir)) {
+		return spec.NewError(70, "couldn't find a track with that id")
+	}
+	if err != nil {
+		return spec.NewError(0, "find track: %v", err)
+	}
+	sub := spec.NewResponse()
+	sub.LyricsList = &spec.LyricsList{
+		StructuredLyrics: []*spec.StructuredLyrics{},
+	}
+	if track.Lyrics == "" {
+		return sub
+	}
+	structured := spec.NewStructuredLyrics(lyrics.Parse(track.Lyrics), trackDisplayArtist(&track), track.TagTitle)
+	sub.LyricsList.StructuredLyrics = append(sub.LyricsList.StructuredLyrics, structured)
+	return sub
+}
+
+func (c *Controller) ServeGetOpenSubsonicExtensions(r *http.Request) *spec.Response {
+	sub := spec.NewResponse()
+	sub.OpenSubsonicExtensions = []*spec.OpenSubsonicExtension{
+		{Name: "apiKeyAuthentication", Versions: []int{1}},
+		{Name: "songLyrics", Versions: []int{1}},
+	}
 	return sub
 }
+
+func trackDisplayArtist(track *db.Track) string {
+	if track.TagTrackArtist != "" {
+		return track.TagTrackArtist
+	}
+	if track.Album != nil && track.Album.TagArtist != nil {
+		return track.Album.TagArtist.Name
+	}
+	return ""
+}
@@ -0,0 +1,309 @@
+package ctrlsubsonic
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/server/ctrlsubsonic/spec"
+)
+
+func TestGetLyrics(t *testing.T) {
+	t.Parallel()
+	contr := makeController(t)
+	setTrackLyrics(t, contr, 1, "[ar:lrc artist]\n[00:01.00]line one\n[00:02.50]line two")
+	setTrackLyrics(t, contr, 4, "plain line one\nplain line two")
+
+	runQueryCases(t, contr, contr.ServeGetLyrics, []*queryCase{
+		{url.Values{"artist": {"artist-0"}, "title": {"title-0"}}, "synced", false},
+		{url.Values{"artist": {"ARTIST-0"}, "title": {"Title-0"}}, "case_insensitive", false},
+		{url.Values{"artist": {"artist-0"}, "title": {"title-2"}}, "missing", false},
+		{url.Values{}, "no_args", false},
+	})
+}
+
+func TestGetLyricsBySongID(t *testing.T) {
+	t.Parallel()
+	contr := makeController(t)
+	setTrackLyrics(t, contr, 1, "[ar:lrc artist]\n[offset:-100]\n[00:01.00]line one\n[00:02.50]line two")
+	setTrackLyrics(t, contr, 2, "plain line one\nplain line two")
+
+	runQueryCases(t, contr, contr.ServeGetLyricsBySongID, []*queryCase{
+		{url.Values{"id": {"tr-1"}}, "synced", false},
+		{url.Values{"id": {"tr-2"}}, "unsynced", false},
+		{url.Values{"id": {"tr-3"}}, "none", false},
+	})
+}
+
+func setTrackLyrics(t *testing.T, contr *Controller, trackID int, lyrics string) {
+	t.Helper()
+	if err := contr.DB.Model(&db.Track{}).Where("id=?", trackID).Update("lyrics", lyrics).Error; err != nil {
+		t.Fatalf("set lyrics: %v", err)
+	}
+}
+
+func TestTrackPlays(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	contr := makeController(t)
+
+	var user db.User
+	is.NoErr(contr.DB.First(&user).Error)
+
+	serve := func(h handlerSubsonic, query url.Values) *spec.Response {
+		t.Helper()
+		return serveAsUser(t, h, &user, query)
+	}
+	scrobble := func(id string, at time.Time, submission bool) {
+		t.Helper()
+		serve(contr.ServeScrobble, url.Values{
+			"id":         {id},
+			"time":       {strconv.FormatInt(at.UnixMilli(), 10)},
+			"submission": {strconv.FormatBool(submission)},
+		})
+	}
+
+	start := time.Date(2022, time.October, 24, 12, 0, 0, 0, time.UTC)
+	scrobble("tr-1", start, true)
+	scrobble("tr-1", start.Add(30*time.Second), true) // the same listen
+	scrobble("tr-1", start.Add(2*time.Hour), false)   // now playing, not a listen
+	scrobble("tr-4", start.Add(-2*time.Hour), true)
+	scrobble("tr-4", start.Add(-time.Hour), true)
+
+	song := serve(contr.ServeGetSong, url.Values{"id": {"tr-1"}}).Track
+	is.Equal(song.PlayCount, 1)
+	is.True(song.Played != nil && song.Played.Equal(start))
+
+	song = serve(contr.ServeGetSong, url.Values{"id": {"tr-4"}}).Track
+	is.Equal(song.PlayCount, 2)
+	is.True(song.Played != nil && song.Played.Equal(start.Add(-time.Hour)))
+
+	song = serve(contr.ServeGetSong, url.Values{"id": {"tr-2"}}).Track
+	is.Equal(song.PlayCount, 0)
+	is.True(song.Played == nil)
+
+	var albumOne, albumFour db.Track
+	is.NoErr(contr.DB.First(&albumOne, 1).Error)
+	is.NoErr(contr.DB.First(&albumFour, 4).Error)
+	is.True(albumOne.AlbumID != albumFour.AlbumID)
+
+	// counted for each track of a list
+	album := serve(contr.ServeGetAlbum, url.Values{"id": {"al-" + strconv.Itoa(albumFour.AlbumID)}}).Album
+	is.True(len(album.Tracks) > 1)
+	for _, track := range album.Tracks {
+		if track.ID.Value == 4 {
+			is.Equal(track.PlayCount, 2)
+			is.True(track.Played.Equal(start.Add(-time.Hour)))
+			continue
+		}
+		is.Equal(track.PlayCount, 0)
+		is.True(track.Played == nil)
+	}
+
+	frequent := serve(contr.ServeGetAlbumListTwo, url.Values{"type": {"frequent"}}).AlbumsTwo.List
+	is.Equal(len(frequent), 2)
+	is.Equal(frequent[0].ID.Value, albumFour.AlbumID)
+	is.Equal(frequent[1].ID.Value, albumOne.AlbumID)
+
+	recent := serve(contr.ServeGetAlbumListTwo, url.Values{"type": {"recent"}}).AlbumsTwo.List
+	is.Equal(len(recent), 2)
+	is.Equal(recent[0].ID.Value, albumOne.AlbumID)
+	is.Equal(recent[1].ID.Value, albumFour.AlbumID)
+
+	// the same for browsing by folder
+	frequent = serve(contr.ServeGetAlbumList, url.Values{"type": {"frequent"}}).Albums.List
+	is.Equal(len(frequent), 2)
+	is.Equal(frequent[0].ID.Value, albumFour.AlbumID)
+	is.Equal(frequent[1].ID.Value, albumOne.AlbumID)
+
+	recent = serve(contr.ServeGetAlbumList, url.Values{"type": {"recent"}}).Albums.List
+	is.Equal(len(recent), 2)
+	is.Equal(recent[0].ID.Value, albumOne.AlbumID)
+	is.Equal(recent[1].ID.Value, albumFour.AlbumID)
+}
+
+func TestNowPlaying(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	contr := makeController(t)
+
+	var admin db.User
+	is.NoErr(contr.DB.First(&admin).Error)
+	other := db.User{Name: "other", Password: "other"}
+	is.NoErr(contr.DB.Create(&other).Error)
+
+	var track db.Track
+	is.NoErr(contr.DB.First(&track, 1).Error)
+	is.NoErr(contr.DB.Model(&track).Update("length", 200).Error)
+
+	scrobble := func(user *db.User, id, client string, at time.Time, submission bool) {
+		t.Helper()
+		serveAsUser(t, contr.ServeScrobble, user, url.Values{
+			"id":         {id},
+			"c":          {client},
+			"time":       {strconv.FormatInt(at.UnixMilli(), 10)},
+			"submission": {strconv.FormatBool(submission)},
+		})
+	}
+	nowPlaying := func() []*spec.NowPlayingEntry {
+		t.Helper()
+		return serveAsUser(t, contr.ServeGetNowPlaying, &other, url.Values{}).NowPlaying.List
+	}
+
+	now := time.Now()
+	scrobble(&admin, "tr-1", "phone", now.Add(-2*time.Minute), false)
+	scrobble(&admin, "tr-2", "phone", now.Add(-time.Hour), false) // finished long ago
+	scrobble(&other, "tr-3", "laptop", now.Add(-10*time.Second), false)
+
+	entries := nowPlaying()
+	is.Equal(len(entries), 2)
+	is.Equal(entries[0].Username, "other")
+	is.Equal(entries[0].PlayerName, "laptop")
+	is.Equal(entries[0].ID.Value, 3)
+	is.Equal(entries[0].MinutesAgo, 0)
+	is.Equal(entries[1].Username, admin.Name)
+	is.Equal(entries[1].PlayerName, "phone")
+	is.Equal(entries[1].ID.Value, 1)
+	is.Equal(entries[1].MinutesAgo, 2)
+	is.True(entries[0].PlayerID != entries[1].PlayerID) // each user's client is its own player
+
+	// the player stays the same for the client's next track
+	phoneID := entries[1].PlayerID
+	scrobble(&admin, "tr-2", "phone", now.Add(-time.Minute), false)
+	entries = nowPlaying()
+	is.Equal(entries[1].ID.Value, 2)
+	is.Equal(entries[1].PlayerID, phoneID)
+
+	// submitting the track clears it
+	scrobble(&admin, "tr-2", "phone", now, true)
+	entries = nowPlaying()
+	is.Equal(len(entries), 1)
+	is.Equal(entries[0].Username, "other")
+}
+
+func TestGetArtistByTrackArtist(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	contr := makeController(t)
+
+	var admin db.User
+	is.NoErr(contr.DB.First(&admin).Error)
+	var track db.Track
+	is.NoErr(contr.DB.First(&track).Error)
+
+	// an artist only featured on one track, not credited on the album
+	featured := db.Artist{Name: "featured"}
+	is.NoErr(contr.DB.Create(&featured).Error)
+	is.NoErr(contr.DB.Create(&db.TrackArtist{TrackID: track.ID, ArtistID: featured.ID}).Error)
+
+	artist := serveAsUser(t, contr.ServeGetArtist, &admin, url.Values{"id": {featured.SID().String()}}).Artist
+	is.Equal(artist.AlbumCount, 1)
+	is.Equal(artist.Albums[0].ID.Value, track.AlbumID)
+
+	hasArtist, err := contr.userHasArtist(&admin, featured.ID)
+	is.NoErr(err)
+	is.True(hasArtist)
+}
+
+func TestMusicFolderAccess(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	contr := makeControllerRoots(t, []string{"m-0", "m-1"})
+
+	var admin db.User
+	is.NoErr(contr.DB.First(&admin).Error)
+	serveAsUser(t, contr.ServeCreateUser, &admin, url.Values{"username": {"kid"}, "password": {"pass"}, "musicFolderId": {"0"}})
+	kid := contr.DB.GetUserByName("kid")
+	is.True(kid.IsRestricted())
+
+	serveErr := func(h handlerSubsonic, query url.Values) *spec.Error {
+		t.Helper()
+		_, req := makeHTTPMock(query)
+		return h(withUser(req, kid)).Error
+	}
+
+	var allowed, hidden db.Album
+	is.NoErr(contr.DB.Where("root_dir=? AND tag_artist_id IS NOT NULL", contr.MusicPaths[0].Path).First(&allowed).Error)
+	is.NoErr(contr.DB.Where("root_dir=? AND tag_artist_id IS NOT NULL", contr.MusicPaths[1].Path).First(&hidden).Error)
+	var hiddenAlbumIDs []int
+	is.NoErr(contr.DB.Model(db.Album{}).Where("root_dir=?", contr.MusicPaths[1].Path).Pluck("id", &hiddenAlbumIDs).Error)
+	isHidden := func(albumID int) bool {
+		for _, id := range hiddenAlbumIDs {
+			if id == albumID {
+				return true
+			}
+		}
+		return false
+	}
+	var allowedTrack, hiddenTrack db.Track
+	is.NoErr(contr.DB.Where("album_id=?", allowed.ID).First(&allowedTrack).Error)
+	is.NoErr(contr.DB.Where("album_id=?", hidden.ID).First(&hiddenTrack).Error)
+
+	folders := serveAsUser(t, contr.ServeGetMusicFolders, kid, url.Values{}).MusicFolders.List
+	is.Equal(len(folders), 1)
+	is.Equal(folders[0].ID, 0)
+	is.Equal(serveAsUser(t, contr.ServeGetUser, kid, url.Values{}).User.Folder, []int{0})
+
+	// lists only have the user's folders, even when asking for another
+	albums := serveAsUser(t, contr.ServeGetAlbumListTwo, kid, url.Values{"type": {"alphabeticalByName"}, "size": {"100"}}).AlbumsTwo.List
+	is.True(len(albums) > 0)
+	for _, album := range albums {
+		is.True(!isHidden(album.ID.Value))
+	}
+	is.Equal(len(serveAsUser(t, contr.ServeGetAlbumListTwo, kid, url.Values{"type": {"alphabeticalByName"}, "musicFolderId": {"1"}}).AlbumsTwo.List), 0)
+	songs := serveAsUser(t, contr.ServeSearchThree, kid, url.Values{"query": {"title"}, "songCount": {"100"}}).SearchResultThree.Tracks
+	is.True(len(songs) > 0)
+	for _, song := range songs {
+		is.True(!isHidden(song.AlbumID.Value))
+	}
+
+	for _, h := range []handlerSubsonic{
+		contr.ServeGetIndexes, contr.ServeGetArtists, contr.ServeGetGenres, contr.ServeGetStarred,
+		contr.ServeGetStarredTwo, contr.ServeGetRandomSongs, contr.ServeGetNowPlaying, contr.ServeGetLyrics,
+	} {
+		serveAsUser(t, h, kid, url.Values{"musicFolderId": {"1"}})
+	}
+
+	// and guessing ids doesn't help
+	serveAsUser(t, contr.ServeGetAlbum, kid, url.Values{"id": {allowed.SID().String()}})
+	is.True(serveErr(contr.ServeGetAlbum, url.Values{"id": {hidden.SID().String()}}) != nil)
+	is.True(serveErr(contr.ServeGetMusicDirectory, url.Values{"id": {hidden.SID().String()}}) != nil)
+	is.True(serveErr(contr.ServeGetSong, url.Values{"id": {hiddenTrack.SID().String()}}) != nil)
+	is.True(serveErr(contr.ServeCreateShare, url.Values{"id": {hiddenTrack.SID().String()}}) != nil)
+	rr, req := makeHTTPMock(url.Values{"id": {hiddenTrack.SID().String()}})
+	is.True(contr.ServeStream(rr, withUser(req, kid)).Error != nil)
+	rr, req = makeHTTPMock(url.Values{"id": {hidden.SID().String()}})
+	is.True(contr.ServeGetCoverArt(rr, withUser(req, kid)).Error != nil)
+
+	// playlists leave out the tracks the user can't see
+	playlist := db.Playlist{UserID: admin.ID, IsPublic: true}
+	playlist.SetItems([]int{allowedTrack.ID, hiddenTrack.ID})
+	is.NoErr(contr.DB.Save(&playlist).Error)
+	playlistResp := serveAsUser(t, contr.ServeGetPlaylist, kid, url.Values{"id": {strconv.Itoa(playlist.ID)}}).Playlist
+	is.Equal(len(playlistResp.List), 1)
+	is.Equal(playlistResp.SongCount, 1)
+
+	// every folder is the same as no restriction
+	serveAsUser(t, contr.ServeUpdateUser, &admin, url.Values{"username": {"kid"}, "musicFolderId": {"0", "1"}})
+	is.True(!contr.DB.GetUserByName("kid").IsRestricted())
+}
+
+func serveAsUser(t *testing.T, h handlerSubsonic, user *db.User, query url.Values) *spec.Response {
+	t.Helper()
+	_, req := makeHTTPMock(query)
+	resp := h(withUser(req, user))
+	if resp.Error != nil {
+		t.Fatalf("response error: %s", resp.Error.Message)
+	}
+	return resp
+}
+
+func withUser(req *http.Request, user *db.User) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), CtxUser, user))
+}
@@ -5,55 +5,92 @@ import (
 	"log"
 	"net/http"
 	"sort"
+	"time"
 
 	"github.com/jinzhu/gorm"
 
 	"go.senan.xyz/gonic/db"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/params"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/spec"
+	"go.senan.xyz/gonic/smartplaylist"
 )
 
-func playlistRender(c *Controller, playlist *db.Playlist, params params.Params) *spec.Playlist {
-	user := &db.User{}
-	c.DB.Where("id=?", playlist.UserID).Find(user)
+// playlistRender leaves out the tracks that the user viewing the playlist can't see.
+// finding the tracks of a smart playlist can be slow, so without withSmartTracks it only
+// has the count from the last time they were found
+func playlistRender(c *Controller, user *db.User, playlist *db.Playlist, params params.Params, withSmartTracks bool) *spec.Playlist {
+	owner := &db.User{}
+	c.DB.Where("id=?", playlist.UserID).Preload("MusicFolders").Find(owner)
 
 	resp := &spec.Playlist{
-		ID:        playlist.ID,
-		Name:      playlist.Name,
-		Comment:   playlist.Comment,
-		Created:   playlist.CreatedAt,
-		SongCount: playlist.TrackCount,
-		Public:    playlist.IsPublic,
-		Owner:     user.Name,
+		ID:       playlist.ID,
+		Name:     playlist.Name,
+		Comment:  playlist.Comment,
+		Created:  playlist.CreatedAt,
+		Public:   playlist.IsPublic,
+		Owner:    owner.Name,
+		Readonly: playlist.IsSmart(),
 	}
 
 	trackIDs := playlist.GetItems()
-	resp.List = make([]*spec.TrackChild, len(trackIDs))
+	if playlist.IsSmart() {
+		if !withSmartTracks {
+			resp.SongCount = playlist.TrackCount
+			return resp
+		}
+		var err error
+		if trackIDs, err = smartPlaylistTrackIDs(c, owner, playlist); err != nil {
+			log.Printf("error finding tracks of smart playlist %d: %v", playlist.ID, err)
+		} else if len(trackIDs) != playlist.TrackCount {
+			if err := c.DB.Model(playlist).UpdateColumn("track_count", len(trackIDs)).Error; err != nil {
+				log.Printf("error saving track count of smart playlist %d: %v", playlist.ID, err)
+			}
+		}
+	}
+	resp.List = make([]*spec.TrackChild, 0, len(trackIDs))
 
-	transcodeMIME, transcodeSuffix := streamGetTransPrefProfile(c.DB, user.ID, params.GetOr("c", ""))
+	transcodeMIME, transcodeSuffix := streamGetTransPrefProfile(c.DB, owner.ID, params.GetOr("c", ""))
 
-	for i, id := range trackIDs {
+	for _, id := range trackIDs {
 		track := db.Track{}
 		err := c.DB.
 			Where("id=?", id).
 			Preload("Album").
 			Preload("Album.TagArtist").
-			Preload("TrackStar", "user_id=?", user.ID).
-			Preload("TrackRating", "user_id=?", user.ID).
+			Preload("TrackStar", "user_id=?", owner.ID).
+			Preload("TrackRating", "user_id=?", owner.ID).
 			Find(&track).
 			Error
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			log.Printf("wasn't able to find track with id %d", id)
 			continue
 		}
-		resp.List[i] = spec.NewTCTrackByFolder(&track, track.Album)
-		resp.List[i].TranscodedContentType = transcodeMIME
-		resp.List[i].TranscodedSuffix = transcodeSuffix
+		if !user.HasMusicFolder(track.Album.RootDir) {
+			continue
+		}
+		if err := c.DB.LoadTrackPlays(owner.ID, &track); err != nil {
+			log.Printf("error loading plays of track %d: %v", id, err)
+		}
+		trackChild := spec.NewTCTrackByFolder(&track, track.Album)
+		trackChild.TranscodedContentType = transcodeMIME
+		trackChild.TranscodedSuffix = transcodeSuffix
+		resp.List = append(resp.List, trackChild)
 		resp.Duration += track.Length
 	}
+	resp.SongCount = len(resp.List)
 	return resp
 }
 
+// smartPlaylistTrackIDs finds the tracks of the smart playlist each time it's viewed,
+// with the owner's stars, ratings, and plays
+func smartPlaylistTrackIDs(c *Controller, owner *db.User, playlist *db.Playlist) ([]int, error) {
+	rules, err := smartplaylist.Unmarshal(playlist.Rules)
+	if err != nil {
+		return nil, err
+	}
+	return smartplaylist.TrackIDs(c.DB, owner, rules, time.Now())
+}
+
 func (c *Controller) ServeGetPlaylists(r *http.Request) *spec.Response {
 	params := r.Context().Value(CtxParams).(params.Params)
 	user := r.Context().Value(CtxUser).(*db.User)
@@ -64,13 +101,14 @@ func (c *Controller) ServeGetPlaylists(r *http.Request) *spec.Response {
 		List: make([]*spec.Playlist, len(playlists)),
 	}
 	for i, playlist := range playlists {
-		sub.Playlists.List[i] = playlistRender(c, playlist, params)
+		sub.Playlists.List[i] = playlistRender(c, user, playlist, params, false)
 	}
 	return sub
 }
 
 func (c *Controller) ServeGetPlaylist(r *http.Request) *spec.Response {
 	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
 	playlistID, err := params.GetFirstInt("id", "playlistId")
 	if err != nil {
 		return spec.NewError(10, "please provide an `id` parameter")
@@ -84,7 +122,7 @@ func (c *Controller) ServeGetPlaylist(r *http.Request) *spec.Response {
 		return spec.NewError(70, "playlist with id `%d` not found", playlistID)
 	}
 	sub := spec.NewResponse()
-	sub.Playlist = playlistRender(c, &playlist, params)
+	sub.Playlist = playlistRender(c, user, &playlist, params, true)
 	return sub
 }
 
@@ -103,6 +141,9 @@ func (c *Controller) ServeCreatePlaylist(r *http.Request) *spec.Response {
 	if playlist.UserID != 0 && playlist.UserID != user.ID {
 		return spec.NewResponse()
 	}
+	if playlist.IsSmart() {
+		return spec.NewError(50, "the tracks of smart playlists come from their rules, and can't be changed")
+	}
 	playlist.UserID = user.ID
 	if val, err := params.Get("name"); err == nil {
 		playlist.Name = val
@@ -120,7 +161,7 @@ func (c *Controller) ServeCreatePlaylist(r *http.Request) *spec.Response {
 	c.DB.Save(playlist)
 
 	sub := spec.NewResponse()
-	sub.Playlist = playlistRender(c, &playlist, params)
+	sub.Playlist = playlistRender(c, user, &playlist, params, true)
 	return sub
 }
 
@@ -149,6 +190,16 @@ func (c *Controller) ServeUpdatePlaylist(r *http.Request) *spec.Response {
 	if val, err := params.GetBool("public"); err == nil {
 		playlist.IsPublic = val
 	}
+	if playlist.IsSmart() {
+		// only the details of smart playlists can change, their tracks come from rules
+		_, errRemove := params.GetIntList("songIndexToRemove")
+		_, errAdd := params.GetIDList("songIdToAdd")
+		if errRemove == nil || errAdd == nil {
+			return spec.NewError(50, "the tracks of smart playlists come from their rules, and can't be changed")
+		}
+		c.DB.Save(playlist)
+		return spec.NewResponse()
+	}
 	trackIDs := playlist.GetItems()
 
 	// delete items
@@ -0,0 +1,64 @@
+package ctrlsubsonic
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"go.senan.xyz/gonic/db"
+)
+
+func TestSmartPlaylist(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	contr := makeController(t)
+
+	var user db.User
+	is.NoErr(contr.DB.First(&user).Error)
+
+	var artist db.Artist
+	is.NoErr(contr.DB.First(&artist).Error)
+	var artistTracks int
+	is.NoErr(contr.DB.Model(db.Track{}).Where("artist_id=?", artist.ID).Count(&artistTracks).Error)
+	is.True(artistTracks > 0)
+
+	playlist := db.Playlist{
+		UserID: user.ID,
+		Name:   "smart",
+		Rules:  `{"artist":"` + artist.Name + `","sort":"-title","limit":2}`,
+	}
+	is.NoErr(contr.DB.Save(&playlist).Error)
+	id := strconv.Itoa(playlist.ID)
+
+	// lists don't find the tracks, so they have the count from the last time they were
+	list := serveAsUser(t, contr.ServeGetPlaylists, &user, url.Values{}).Playlists.List
+	is.Equal(len(list), 1)
+	is.Equal(list[0].SongCount, 0)
+	is.Equal(len(list[0].List), 0)
+
+	resp := serveAsUser(t, contr.ServeGetPlaylist, &user, url.Values{"id": {id}}).Playlist
+	is.True(resp.Readonly)
+	is.Equal(len(resp.List), 2)
+	is.True(resp.List[0].Title >= resp.List[1].Title)
+	for _, track := range resp.List {
+		is.Equal(track.Artist, artist.Name)
+	}
+	list = serveAsUser(t, contr.ServeGetPlaylists, &user, url.Values{}).Playlists.List
+	is.Equal(list[0].SongCount, 2)
+
+	// the tracks come from the rules
+	_, req := makeHTTPMock(url.Values{"playlistId": {id}, "songIdToAdd": {"tr-1"}})
+	is.True(contr.ServeUpdatePlaylist(withUser(req, &user)).Error != nil)
+	_, req = makeHTTPMock(url.Values{"playlistId": {id}, "songIndexToRemove": {"0"}})
+	is.True(contr.ServeUpdatePlaylist(withUser(req, &user)).Error != nil)
+	_, req = makeHTTPMock(url.Values{"playlistId": {id}, "songId": {"tr-1"}})
+	is.True(contr.ServeCreatePlaylist(withUser(req, &user)).Error != nil)
+
+	// but the details can change
+	serveAsUser(t, contr.ServeUpdatePlaylist, &user, url.Values{"playlistId": {id}, "name": {"renamed"}})
+	resp = serveAsUser(t, contr.ServeGetPlaylist, &user, url.Values{"id": {id}}).Playlist
+	is.Equal(resp.Name, "renamed")
+	is.Equal(len(resp.List), 2)
+}
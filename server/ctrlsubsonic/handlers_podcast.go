@@ -5,7 +5,6 @@ import (
 
 	"github.com/mmcdole/gofeed"
 
-	"go.senan.xyz/gonic/db"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/params"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/spec"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/specid"
@@ -44,10 +43,6 @@ func (c *Controller) ServeGetNewestPodcasts(r *http.Request) *spec.Response {
 }
 
 func (c *Controller) ServeDownloadPodcastEpisode(r *http.Request) *spec.Response {
-	user := r.Context().Value(CtxUser).(*db.User)
-	if !user.IsAdmin {
-		return spec.NewError(50, "user not admin")
-	}
 	params := r.Context().Value(CtxParams).(params.Params)
 	id, err := params.GetID("id")
 	if err != nil || id.Type != specid.PodcastEpisode {
@@ -60,10 +55,6 @@ func (c *Controller) ServeDownloadPodcastEpisode(r *http.Request) *spec.Response
 }
 
 func (c *Controller) ServeCreatePodcastChannel(r *http.Request) *spec.Response {
-	user := r.Context().Value(CtxUser).(*db.User)
-	if !user.IsAdmin {
-		return spec.NewError(50, "user not admin")
-	}
 	params := r.Context().Value(CtxParams).(params.Params)
 	rssURL, _ := params.Get("url")
 	fp := gofeed.NewParser()
@@ -78,10 +69,6 @@ func (c *Controller) ServeCreatePodcastChannel(r *http.Request) *spec.Response {
 }
 
 func (c *Controller) ServeRefreshPodcasts(r *http.Request) *spec.Response {
-	user := r.Context().Value(CtxUser).(*db.User)
-	if !user.IsAdmin {
-		return spec.NewError(50, "user not admin")
-	}
 	if err := c.Podcasts.RefreshPodcasts(); err != nil {
 		return spec.NewError(10, "failed to refresh feeds: %s", err)
 	}
@@ -89,10 +76,6 @@ func (c *Controller) ServeRefreshPodcasts(r *http.Request) *spec.Response {
 }
 
 func (c *Controller) ServeDeletePodcastChannel(r *http.Request) *spec.Response {
-	user := r.Context().Value(CtxUser).(*db.User)
-	if !user.IsAdmin {
-		return spec.NewError(50, "user not admin")
-	}
 	params := r.Context().Value(CtxParams).(params.Params)
 	id, err := params.GetID("id")
 	if err != nil || id.Type != specid.Podcast {
@@ -105,10 +88,6 @@ func (c *Controller) ServeDeletePodcastChannel(r *http.Request) *spec.Response {
 }
 
 func (c *Controller) ServeDeletePodcastEpisode(r *http.Request) *spec.Response {
-	user := r.Context().Value(CtxUser).(*db.User)
-	if !user.IsAdmin {
-		return spec.NewError(50, "user not admin")
-	}
 	params := r.Context().Value(CtxParams).(params.Params)
 	id, err := params.GetID("id")
 	if err != nil || id.Type != specid.PodcastEpisode {
@@ -4,16 +4,19 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"image"
 	"log"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"time"
 
 	"github.com/disintegration/imaging"
 	"github.com/jinzhu/gorm"
 
 	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/mime"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/params"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/spec"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/specid"
@@ -66,6 +69,9 @@ func streamGetAudio(dbc *db.DB, podcastsPath string, user *db.User, id specid.ID
 		if err := dbc.Preload("Album").Preload("Artist").First(&track, id.Value).Error; err != nil {
 			return nil, "", fmt.Errorf("find track: %w", err)
 		}
+		if !user.HasMusicFolder(track.Album.RootDir) {
+			return nil, "", fmt.Errorf("find track: %w", gorm.ErrRecordNotFound)
+		}
 		if track.Artist != nil && track.Album != nil {
 			log.Printf("%s requests %s - %s from %s", user.Name, track.Artist.Name, track.TagTitle, track.Album.TagTitle)
 		}
@@ -106,6 +112,32 @@ func streamUpdateStats(dbc *db.DB, userID, albumID int, playTime time.Time) erro
 	return nil
 }
 
+// a client streams a track as it starts playing, and scrobbles it when it's finished.
+// they're the same listen, so only one play is kept for anything inside this window
+const trackPlayMergeGrace = time.Minute
+
+func streamUpdateTrackStats(dbc *db.DB, userID int, track *db.Track, playTime time.Time) error {
+	window := time.Duration(track.Length)*time.Second + trackPlayMergeGrace
+	var count int
+	err := dbc.
+		Model(db.TrackPlay{}).
+		Where("user_id=? AND track_id=? AND time BETWEEN ? AND ?", userID, track.ID, playTime.Add(-window), playTime.Add(window)).
+		Count(&count).
+		Error
+	if err != nil {
+		return fmt.Errorf("find track play: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	play := db.TrackPlay{UserID: userID, TrackID: track.ID, Time: playTime}
+	if err := dbc.Create(&play).Error; err != nil {
+		return fmt.Errorf("save track play: %w", err)
+	}
+	return nil
+}
+
 func streamUpdatePodcastEpisodeStats(dbc *db.DB, peID int) error {
 	var pe db.PodcastEpisode
 	err := dbc.
@@ -226,8 +258,27 @@ func coverGetPathPodcastEpisode(dbc *db.DB, podcastPath string, id int) (string,
 	return path.Join(podcastPath, podcast.ImagePath), nil
 }
 
-func coverScaleAndSave(absPath, cachePath string, size int) error {
-	src, err := imaging.Open(absPath)
+// userHasCover returns whether a cover is of something in the user's music folders
+func (c *Controller) userHasCover(user *db.User, id specid.ID) (bool, error) {
+	if !user.IsRestricted() {
+		return true, nil
+	}
+	switch id.Type {
+	case specid.Album:
+		var album db.Album
+		if err := c.DB.Select("root_dir").First(&album, id.Value).Error; err != nil {
+			return false, fmt.Errorf("find album: %w", err)
+		}
+		return user.HasMusicFolder(album.RootDir), nil
+	case specid.Artist:
+		return c.userHasArtist(user, id.Value)
+	default:
+		return true, nil
+	}
+}
+
+func (c *Controller) coverScaleAndSave(absPath, cachePath string, size int) error {
+	src, err := c.coverOpen(absPath)
 	if err != nil {
 		return fmt.Errorf("resizing `%s`: %w", absPath, err)
 	}
@@ -243,12 +294,28 @@ func coverScaleAndSave(absPath, cachePath string, size int) error {
 	return nil
 }
 
+// coverOpen decodes an image file, or the embedded cover of an audio file
+func (c *Controller) coverOpen(absPath string) (image.Image, error) {
+	if mime.TypeByAudioExtension(filepath.Ext(absPath)) == "" {
+		return imaging.Open(absPath)
+	}
+	data, err := c.CoverReader.ReadCover(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("read embedded cover: %w", err)
+	}
+	return imaging.Decode(bytes.NewReader(data))
+}
+
 func (c *Controller) ServeGetCoverArt(w http.ResponseWriter, r *http.Request) *spec.Response {
 	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
 	id, err := params.GetID("id")
 	if err != nil {
 		return spec.NewError(10, "please provide an `id` parameter")
 	}
+	if ok, err := c.userHasCover(user, id); err != nil || !ok {
+		return spec.NewError(10, "couldn't find cover `%s`", id)
+	}
 	size := params.GetOrInt("size", coverDefaultSize)
 	cachePath := path.Join(
 		c.CoverCachePath,
@@ -261,7 +328,7 @@ func (c *Controller) ServeGetCoverArt(w http.ResponseWriter, r *http.Request) *s
 		if err != nil {
 			return spec.NewError(10, "couldn't find cover `%s`: %v", id, err)
 		}
-		if err := coverScaleAndSave(coverPath, cachePath, size); err != nil {
+		if err := c.coverScaleAndSave(coverPath, cachePath, size); err != nil {
 			log.Printf("error scaling cover: %v", err)
 			return nil
 		}
@@ -288,9 +355,13 @@ func (c *Controller) ServeStream(w http.ResponseWriter, r *http.Request) *spec.R
 
 	if track, ok := file.(*db.Track); ok && track.Album != nil {
 		defer func() {
-			if err := streamUpdateStats(c.DB, user.ID, track.Album.ID, time.Now()); err != nil {
+			now := time.Now()
+			if err := streamUpdateStats(c.DB, user.ID, track.Album.ID, now); err != nil {
 				log.Printf("error updating track status: %v", err)
 			}
+			if err := streamUpdateTrackStats(c.DB, user.ID, track, now); err != nil {
+				log.Printf("error updating track play history: %v", err)
+			}
 		}()
 	}
 
@@ -302,29 +373,61 @@ func (c *Controller) ServeStream(w http.ResponseWriter, r *http.Request) *spec.R
 		}()
 	}
 
+	return c.streamAudio(w, r, user, file, audioPath)
+}
+
+// streamAudio serves a track or podcast episode's file, or transcodes it if the client or
+// the user's transcode preference asks for it
+func (c *Controller) streamAudio(w http.ResponseWriter, r *http.Request, user *db.User, file db.AudioFile, audioPath string) *spec.Response {
+	params := r.Context().Value(CtxParams).(params.Params)
 	maxBitRate, _ := params.GetInt("maxBitRate")
 	format, _ := params.Get("format")
 
-	if format == "raw" || maxBitRate >= file.AudioBitrate() {
+	// a track from a cue sheet is only part of its file, so the file can't be served as is.
+	// unless it's all of it
+	cueTrack, _ := file.(*db.Track)
+	if cueTrack != nil && (cueTrack.CueTrack == 0 || (cueTrack.CueStart == 0 && cueTrack.CueEnd == 0)) {
+		cueTrack = nil
+	}
+
+	raw := format == "raw" || maxBitRate >= file.AudioBitrate()
+	if raw && cueTrack == nil {
 		http.ServeFile(w, r, audioPath)
 		return nil
 	}
 
-	pref, err := streamGetTransPref(c.DB, user.ID, params.GetOr("c", ""))
-	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-		return spec.NewError(0, "couldn't find transcode preference: %v", err)
+	var pref *db.TranscodePreference
+	if !raw {
+		var err error
+		pref, err = streamGetTransPref(c.DB, user.ID, params.GetOr("c", ""))
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return spec.NewError(0, "couldn't find transcode preference: %v", err)
+		}
 	}
-	if pref == nil {
+
+	var profile transcode.Profile
+	switch {
+	case pref != nil:
+		var ok bool
+		profile, ok = transcode.UserProfiles[pref.Profile]
+		if !ok {
+			return spec.NewError(0, "unknown transcode user profile %q", pref.Profile)
+		}
+		if maxBitRate > 0 && int(profile.BitRate()) > maxBitRate {
+			profile = transcode.WithBitrate(profile, transcode.BitRate(maxBitRate))
+		}
+	case cueTrack != nil:
+		profile = transcode.Copy(filepath.Ext(audioPath))
+	default:
 		http.ServeFile(w, r, audioPath)
 		return nil
 	}
 
-	profile, ok := transcode.UserProfiles[pref.Profile]
-	if !ok {
-		return spec.NewError(0, "unknown transcode user profile %q", pref.Profile)
-	}
-	if maxBitRate > 0 && int(profile.BitRate()) > maxBitRate {
-		profile = transcode.WithBitrate(profile, transcode.BitRate(maxBitRate))
+	if cueTrack != nil {
+		profile = transcode.WithSeek(profile, time.Duration(cueTrack.CueStart)*time.Millisecond)
+		if cueTrack.CueEnd > 0 {
+			profile = transcode.WithDuration(profile, time.Duration(cueTrack.CueEnd-cueTrack.CueStart)*time.Millisecond)
+		}
 	}
 
 	log.Printf("trancoding to %q with max bitrate %dk", profile.MIME(), profile.BitRate())
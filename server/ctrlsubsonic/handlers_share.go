@@ -0,0 +1,223 @@
+package ctrlsubsonic
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/server/ctrlsubsonic/params"
+	"go.senan.xyz/gonic/server/ctrlsubsonic/spec"
+	"go.senan.xyz/gonic/server/ctrlsubsonic/specid"
+)
+
+func (c *Controller) ServeGetShares(r *http.Request) *spec.Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	var shares []*db.Share
+	err := c.DB.
+		Where("user_id=?", user.ID).
+		Preload("User").
+		Preload("User.MusicFolders").
+		Preload("Entries", func(db *gorm.DB) *gorm.DB {
+			return db.Order("share_entries.id")
+		}).
+		Order("created_at").
+		Find(&shares).
+		Error
+	if err != nil {
+		return spec.NewError(0, "find shares: %v", err)
+	}
+	sub := spec.NewResponse()
+	sub.Shares = &spec.Shares{
+		List: make([]*spec.Share, len(shares)),
+	}
+	for i, share := range shares {
+		tracks, err := c.DB.GetShareTracks(share)
+		if err != nil {
+			return spec.NewError(0, "find share tracks: %v", err)
+		}
+		sub.Shares.List[i] = spec.NewShare(share, c.shareURL(r, share), tracks)
+	}
+	return sub
+}
+
+func (c *Controller) ServeCreateShare(r *http.Request) *spec.Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	params := r.Context().Value(CtxParams).(params.Params)
+	ids, err := params.GetList("id")
+	if err != nil {
+		return spec.NewError(10, "please provide at least one `id` parameter")
+	}
+
+	share := &db.Share{
+		UUID:        uuid.NewString(),
+		UserID:      user.ID,
+		Description: params.GetOr("description", ""),
+		Expires:     shareExpires(params),
+	}
+	for _, id := range ids {
+		entry, err := c.shareEntry(user, id)
+		if err != nil {
+			return spec.NewError(70, "can't share %q: %v", id, err)
+		}
+		share.Entries = append(share.Entries, entry)
+	}
+	if err := c.DB.Create(share).Error; err != nil {
+		return spec.NewError(0, "save share: %v", err)
+	}
+	share.User = user
+
+	tracks, err := c.DB.GetShareTracks(share)
+	if err != nil {
+		return spec.NewError(0, "find share tracks: %v", err)
+	}
+	sub := spec.NewResponse()
+	sub.Shares = &spec.Shares{
+		List: []*spec.Share{spec.NewShare(share, c.shareURL(r, share), tracks)},
+	}
+	return sub
+}
+
+func (c *Controller) ServeUpdateShare(r *http.Request) *spec.Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	params := r.Context().Value(CtxParams).(params.Params)
+	share, errResp := c.findUserShare(user, params)
+	if errResp != nil {
+		return errResp
+	}
+	if description, err := params.Get("description"); err == nil {
+		share.Description = description
+	}
+	if _, err := params.Get("expires"); err == nil {
+		share.Expires = shareExpires(params)
+	}
+	if err := c.DB.Save(share).Error; err != nil {
+		return spec.NewError(0, "save share: %v", err)
+	}
+	return spec.NewResponse()
+}
+
+func (c *Controller) ServeDeleteShare(r *http.Request) *spec.Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	params := r.Context().Value(CtxParams).(params.Params)
+	share, errResp := c.findUserShare(user, params)
+	if errResp != nil {
+		return errResp
+	}
+	if err := c.DB.Delete(share).Error; err != nil {
+		return spec.NewError(0, "delete share: %v", err)
+	}
+	return spec.NewResponse()
+}
+
+// ServeShareStream streams a track from a public share to someone who may not have an
+// account. only tracks in the share can be streamed, and they don't count as plays
+func (c *Controller) ServeShareStream(w http.ResponseWriter, r *http.Request) *spec.Response {
+	params := r.Context().Value(CtxParams).(params.Params)
+	id, err := params.GetID("id")
+	if err != nil || id.Type != specid.Track {
+		return spec.NewError(10, "please provide a track `id` parameter")
+	}
+
+	var share db.Share
+	err = c.DB.
+		Where("uuid=?", mux.Vars(r)["uuid"]).
+		Preload("User").
+		Preload("User.MusicFolders").
+		Preload("Entries").
+		First(&share).
+		Error
+	if err != nil || share.IsExpired(time.Now()) || !share.User.HasRole(db.RoleShare) {
+		return spec.NewError(70, "share not found")
+	}
+	tracks, err := c.DB.GetShareTracks(&share)
+	if err != nil {
+		return spec.NewError(0, "find share tracks: %v", err)
+	}
+	for _, track := range tracks {
+		if track.ID == id.Value {
+			return c.streamAudio(w, r, share.User, track, track.AbsPath())
+		}
+	}
+	return spec.NewError(70, "track not in share")
+}
+
+// shareEntry checks that an ID given to createShare is something the user can share. tracks
+// and albums have their usual IDs, and playlists have plain numbers. smart playlists can't
+// be shared, since their tracks depend on their owner and change over time
+func (c *Controller) shareEntry(user *db.User, id string) (*db.ShareEntry, error) {
+	if playlistID, err := strconv.Atoi(id); err == nil {
+		var playlist db.Playlist
+		if err := c.DB.First(&playlist, playlistID).Error; err != nil {
+			return nil, err
+		}
+		if playlist.UserID != user.ID && !playlist.IsPublic {
+			return nil, errors.New("playlist is private")
+		}
+		if playlist.IsSmart() {
+			return nil, errors.New("smart playlists can't be shared")
+		}
+		return &db.ShareEntry{PlaylistID: playlist.ID}, nil
+	}
+	sid, err := specid.New(id)
+	if err != nil {
+		return nil, err
+	}
+	switch sid.Type {
+	case specid.Track:
+		var track db.Track
+		if err := c.DB.Preload("Album").First(&track, sid.Value).Error; err != nil {
+			return nil, err
+		}
+		if !user.HasMusicFolder(track.Album.RootDir) {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return &db.ShareEntry{TrackID: sid.Value}, nil
+	case specid.Album:
+		var album db.Album
+		if err := c.DB.First(&album, sid.Value).Error; err != nil {
+			return nil, err
+		}
+		if !user.HasMusicFolder(album.RootDir) {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return &db.ShareEntry{AlbumID: sid.Value}, nil
+	default:
+		return nil, errors.New("only tracks, albums, and playlists can be shared")
+	}
+}
+
+func (c *Controller) findUserShare(user *db.User, params params.Params) (*db.Share, *spec.Response) {
+	id, err := params.GetInt("id")
+	if err != nil {
+		return nil, spec.NewError(10, "please provide an `id` parameter")
+	}
+	var share db.Share
+	if err := c.DB.First(&share, id).Error; err != nil {
+		return nil, spec.NewError(70, "share with id `%d` not found", id)
+	}
+	if share.UserID != user.ID && !user.IsAdmin {
+		return nil, spec.NewError(50, "you aren't allowed to change this share")
+	}
+	return &share, nil
+}
+
+func (c *Controller) shareURL(r *http.Request, share *db.Share) string {
+	return c.BaseURL(r) + c.Path("/share/"+share.UUID)
+}
+
+// shareExpires reads the expiry time in milliseconds since the epoch, or nil for a share
+// that doesn't expire
+func shareExpires(params params.Params) *time.Time {
+	ms, err := params.GetInt("expires")
+	if err != nil || ms <= 0 {
+		return nil
+	}
+	expires := time.UnixMilli(int64(ms))
+	return &expires
+}
@@ -0,0 +1,82 @@
+package ctrlsubsonic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/matryer/is"
+
+	"go.senan.xyz/gonic/db"
+)
+
+func TestShares(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	contr := makeControllerAudio(t)
+
+	var user db.User
+	is.NoErr(contr.DB.First(&user).Error)
+
+	var track db.Track
+	is.NoErr(contr.DB.First(&track, 1).Error)
+	var albumTracks []*db.Track
+	is.NoErr(contr.DB.Where("album_id=?", track.AlbumID).Find(&albumTracks).Error)
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	shares := serveAsUser(t, contr.ServeCreateShare, &user, url.Values{
+		"id":          {"tr-4", "al-" + strconv.Itoa(track.AlbumID)},
+		"description": {"for a friend"},
+		"expires":     {strconv.FormatInt(expires.UnixMilli(), 10)},
+	}).Shares.List
+	is.Equal(len(shares), 1)
+	share := shares[0]
+	is.Equal(share.Description, "for a friend")
+	is.Equal(share.Username, user.Name)
+	is.True(share.Expires.Equal(expires))
+	is.Equal(len(share.Entries), 1+len(albumTracks))
+	is.Equal(share.Entries[0].ID.Value, 4)
+
+	var dbShare db.Share
+	is.NoErr(contr.DB.First(&dbShare, share.ID).Error)
+	is.Equal(share.URL, "http://localhost:4747/share/"+dbShare.UUID)
+
+	_, req := makeHTTPMock(url.Values{"id": {"pl-1"}})
+	is.True(contr.ServeCreateShare(withUser(req, &user)).Error != nil) // not a track, album, or playlist
+
+	smart := db.Playlist{UserID: user.ID, Name: "smart", Rules: `{"limit":10}`}
+	is.NoErr(contr.DB.Create(&smart).Error)
+	_, req = makeHTTPMock(url.Values{"id": {strconv.Itoa(smart.ID)}})
+	is.True(contr.ServeCreateShare(withUser(req, &user)).Error != nil) // smart playlist
+
+	serveAsUser(t, contr.ServeUpdateShare, &user, url.Values{"id": {share.ID}, "description": {"changed"}, "expires": {"0"}})
+	shares = serveAsUser(t, contr.ServeGetShares, &user, url.Values{}).Shares.List
+	is.Equal(len(shares), 1)
+	is.Equal(shares[0].Description, "changed")
+	is.True(shares[0].Expires == nil)
+
+	stream := func(uuid, id string) *httptest.ResponseRecorder {
+		rr, req := makeHTTPMock(url.Values{"id": {id}})
+		req = mux.SetURLVars(req, map[string]string{"uuid": uuid})
+		if resp := contr.ServeShareStream(rr, req); resp != nil {
+			rr.Code = http.StatusNotFound
+		}
+		return rr
+	}
+	is.Equal(stream(dbShare.UUID, "tr-4").Code, http.StatusOK)
+	is.Equal(stream(dbShare.UUID, albumTracks[0].SID().String()).Code, http.StatusOK)
+	is.Equal(stream(dbShare.UUID, "tr-5").Code, http.StatusNotFound) // not shared
+	is.Equal(stream("not-a-share", "tr-4").Code, http.StatusNotFound)
+
+	var plays int
+	is.NoErr(contr.DB.Model(db.TrackPlay{}).Count(&plays).Error)
+	is.Equal(plays, 0) // listens from the share aren't the user's
+
+	serveAsUser(t, contr.ServeDeleteShare, &user, url.Values{"id": {share.ID}})
+	is.Equal(len(serveAsUser(t, contr.ServeGetShares, &user, url.Values{}).Shares.List), 0)
+	is.Equal(stream(dbShare.UUID, "tr-4").Code, http.StatusNotFound)
+}
@@ -0,0 +1,257 @@
+package ctrlsubsonic
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/paths"
+	"go.senan.xyz/gonic/server/ctrlbase"
+	"go.senan.xyz/gonic/server/ctrlsubsonic/params"
+	"go.senan.xyz/gonic/server/ctrlsubsonic/spec"
+)
+
+func userRender(c *Controller, user *db.User) *spec.User {
+	hasLastFM := user.LastFMSession != ""
+	hasListenBrainz := user.ListenBrainzToken != ""
+	folders := []int{}
+	for i, musicPath := range c.MusicPaths {
+		if user.HasMusicFolder(musicPath.Path) {
+			folders = append(folders, i)
+		}
+	}
+	// cover art and tags can't be edited, so coverArtRole is always false
+	return &spec.User{
+		Username:          user.Name,
+		AdminRole:         user.IsAdmin,
+		SettingsRole:      true,
+		StreamRole:        true,
+		DownloadRole:      user.HasRole(db.RoleDownload),
+		JukeboxRole:       c.Jukebox != nil && user.HasRole(db.RoleJukebox),
+		PodcastRole:       c.Podcasts != nil && user.HasRole(db.RolePodcast),
+		ShareRole:         user.HasRole(db.RoleShare),
+		PlaylistRole:      user.HasRole(db.RolePlaylist),
+		ScrobblingEnabled: hasLastFM || hasListenBrainz,
+		Folder:            folders,
+	}
+}
+
+// setUserRoles sets the roles given as parameters. scanRole isn't part of the subsonic
+// api, but the others are
+func setUserRoles(user *db.User, params params.Params) {
+	roles := map[string]*bool{
+		"downloadRole": &user.DownloadRole,
+		"jukeboxRole":  &user.JukeboxRole,
+		"podcastRole":  &user.PodcastRole,
+		"shareRole":    &user.ShareRole,
+		"scanRole":     &user.ScanRole,
+		"playlistRole": &user.PlaylistRole,
+	}
+	for key, role := range roles {
+		if value, err := params.GetBool(key); err == nil {
+			*role = value
+		}
+	}
+}
+
+// musicFolderPaths finds the music paths of the music folder IDs given to createUser and
+// updateUser. with every music folder, the user isn't restricted, so they also see new ones
+func musicFolderPaths(musicPaths paths.MusicPaths, ids []int) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("please provide at least one music folder")
+	}
+	seen := map[int]struct{}{}
+	var ret []string
+	for _, id := range ids {
+		if id < 0 || id >= len(musicPaths) {
+			return nil, fmt.Errorf("music folder with id `%d` not found", id)
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ret = append(ret, musicPaths[id].Path)
+	}
+	if len(ret) == len(musicPaths) {
+		return nil, nil
+	}
+	return ret, nil
+}
+
+func (c *Controller) ServeGetUser(r *http.Request) *spec.Response {
+	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
+	reqUser := user
+	if username, err := params.Get("username"); err == nil && username != user.Name {
+		if !user.IsAdmin {
+			return spec.NewError(50, "user not admin")
+		}
+		if reqUser = c.DB.GetUserByName(username); reqUser == nil {
+			return spec.NewError(70, "user %q not found", username)
+		}
+	}
+	sub := spec.NewResponse()
+	sub.User = userRender(c, reqUser)
+	return sub
+}
+
+func (c *Controller) ServeGetUsers(r *http.Request) *spec.Response {
+	user := r.Context().Value(CtxUser).(*db.User)
+	if !user.IsAdmin {
+		return spec.NewError(50, "user not admin")
+	}
+	var users []*db.User
+	if err := c.DB.Preload("MusicFolders").Order("name").Find(&users).Error; err != nil {
+		return spec.NewError(0, "find users: %v", err)
+	}
+	sub := spec.NewResponse()
+	sub.Users = &spec.Users{
+		List: make([]*spec.User, len(users)),
+	}
+	for i, u := range users {
+		sub.Users.List[i] = userRender(c, u)
+	}
+	return sub
+}
+
+func (c *Controller) ServeCreateUser(r *http.Request) *spec.Response {
+	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
+	if !user.IsAdmin {
+		return spec.NewError(50, "user not admin")
+	}
+	username := params.GetOr("username", "")
+	if err := ctrlbase.ValidateUsername(username); err != nil {
+		return spec.NewError(10, "%v", err)
+	}
+	password := decodePassword(params.GetOr("password", ""))
+	if err := ctrlbase.ValidatePasswords(password, password); err != nil {
+		return spec.NewError(10, "%v", err)
+	}
+	if c.DB.GetUserByName(username) != nil {
+		return spec.NewError(0, "user %q already exists", username)
+	}
+	encrypted, err := c.PasswordKey.Encrypt(password)
+	if err != nil {
+		return spec.NewError(0, "encrypt password: %v", err)
+	}
+	newUser := db.User{
+		Name:     username,
+		Password: encrypted,
+		IsAdmin:  params.GetOrBool("adminRole", false),
+	}
+	setUserRoles(&newUser, params)
+	var folders []string
+	if ids, err := params.GetIntList("musicFolderId"); err == nil {
+		if folders, err = musicFolderPaths(c.MusicPaths, ids); err != nil {
+			return spec.NewError(10, "%v", err)
+		}
+	}
+	if err := c.DB.Create(&newUser).Error; err != nil {
+		return spec.NewError(0, "create user %q: %v", username, err)
+	}
+	if err := c.DB.SetUserMusicFolders(&newUser, folders); err != nil {
+		return spec.NewError(0, "set music folders of %q: %v", username, err)
+	}
+	return spec.NewResponse()
+}
+
+func (c *Controller) ServeUpdateUser(r *http.Request) *spec.Response {
+	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
+	if !user.IsAdmin {
+		return spec.NewError(50, "user not admin")
+	}
+	username, err := params.Get("username")
+	if err != nil {
+		return spec.NewError(10, "please provide a `username` parameter")
+	}
+	reqUser := c.DB.GetUserByName(username)
+	if reqUser == nil {
+		return spec.NewError(70, "user %q not found", username)
+	}
+	if password, err := params.Get("password"); err == nil {
+		password = decodePassword(password)
+		if err := ctrlbase.ValidatePasswords(password, password); err != nil {
+			return spec.NewError(10, "%v", err)
+		}
+		if reqUser.Password, err = c.PasswordKey.Encrypt(password); err != nil {
+			return spec.NewError(0, "encrypt password: %v", err)
+		}
+	}
+	if isAdmin, err := params.GetBool("adminRole"); err == nil {
+		if reqUser.ID == user.ID && !isAdmin {
+			return spec.NewError(0, "can't remove your own admin role")
+		}
+		reqUser.IsAdmin = isAdmin
+	}
+	setUserRoles(reqUser, params)
+	ids, err := params.GetIntList("musicFolderId")
+	setFolders := err == nil
+	var folders []string
+	if setFolders {
+		if folders, err = musicFolderPaths(c.MusicPaths, ids); err != nil {
+			return spec.NewError(10, "%v", err)
+		}
+	}
+	if err := c.DB.Save(reqUser).Error; err != nil {
+		return spec.NewError(0, "save user %q: %v", username, err)
+	}
+	if setFolders {
+		if err := c.DB.SetUserMusicFolders(reqUser, folders); err != nil {
+			return spec.NewError(0, "set music folders of %q: %v", username, err)
+		}
+	}
+	return spec.NewResponse()
+}
+
+func (c *Controller) ServeDeleteUser(r *http.Request) *spec.Response {
+	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
+	if !user.IsAdmin {
+		return spec.NewError(50, "user not admin")
+	}
+	username, err := params.Get("username")
+	if err != nil {
+		return spec.NewError(10, "please provide a `username` parameter")
+	}
+	reqUser := c.DB.GetUserByName(username)
+	if reqUser == nil {
+		return spec.NewError(70, "user %q not found", username)
+	}
+	if reqUser.IsAdmin {
+		return spec.NewError(0, "can't delete an admin user")
+	}
+	if err := c.DB.Delete(reqUser).Error; err != nil {
+		return spec.NewError(0, "delete user %q: %v", username, err)
+	}
+	return spec.NewResponse()
+}
+
+func (c *Controller) ServeChangePassword(r *http.Request) *spec.Response {
+	params := r.Context().Value(CtxParams).(params.Params)
+	user := r.Context().Value(CtxUser).(*db.User)
+	username, err := params.Get("username")
+	if err != nil {
+		return spec.NewError(10, "please provide a `username` parameter")
+	}
+	if username != user.Name && !user.IsAdmin {
+		return spec.NewError(50, "can't change the password of another user")
+	}
+	reqUser := c.DB.GetUserByName(username)
+	if reqUser == nil {
+		return spec.NewError(70, "user %q not found", username)
+	}
+	password := decodePassword(params.GetOr("password", ""))
+	if err := ctrlbase.ValidatePasswords(password, password); err != nil {
+		return spec.NewError(10, "%v", err)
+	}
+	encrypted, err := c.PasswordKey.Encrypt(password)
+	if err != nil {
+		return spec.NewError(0, "encrypt password: %v", err)
+	}
+	if err := c.DB.Model(reqUser).Update("password", encrypted).Error; err != nil {
+		return spec.NewError(0, "save password: %v", err)
+	}
+	return spec.NewResponse()
+}
@@ -0,0 +1,124 @@
+package ctrlsubsonic
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/server/ctrlsubsonic/spec"
+)
+
+func TestUserManagement(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	contr := makeController(t)
+
+	var admin db.User
+	is.NoErr(contr.DB.First(&admin).Error)
+	is.True(admin.IsAdmin)
+
+	serveErr := func(h handlerSubsonic, user *db.User, query url.Values) *spec.Error {
+		t.Helper()
+		_, req := makeHTTPMock(query)
+		return h(withUser(req, user)).Error
+	}
+
+	// passwords are encrypted in the db
+	password := func(username string) string {
+		t.Helper()
+		password, err := contr.PasswordKey.Decrypt(contr.DB.GetUserByName(username).Password)
+		is.NoErr(err)
+		return password
+	}
+
+	// hex encoded "pass"
+	serveAsUser(t, contr.ServeCreateUser, &admin, url.Values{"username": {"alice"}, "password": {"enc:70617373"}})
+	alice := contr.DB.GetUserByName("alice")
+	is.True(alice != nil)
+	is.True(alice.Password != "pass")
+	is.Equal(password("alice"), "pass")
+	is.True(!alice.IsAdmin)
+
+	is.True(serveErr(contr.ServeCreateUser, &admin, url.Values{"username": {"alice"}, "password": {"pass"}}) != nil) // exists
+	is.True(serveErr(contr.ServeCreateUser, &admin, url.Values{"username": {"bob"}}) != nil)                         // no password
+	is.True(serveErr(contr.ServeCreateUser, alice, url.Values{"username": {"bob"}, "password": {"pass"}}) != nil)    // not admin
+
+	users := serveAsUser(t, contr.ServeGetUsers, &admin, url.Values{}).Users.List
+	is.Equal(len(users), 2)
+	is.Equal(users[0].Username, "admin")
+	is.Equal(users[1].Username, "alice")
+	is.True(serveErr(contr.ServeGetUsers, alice, url.Values{}) != nil)
+
+	is.Equal(serveAsUser(t, contr.ServeGetUser, &admin, url.Values{"username": {"alice"}}).User.Username, "alice")
+	is.Equal(serveAsUser(t, contr.ServeGetUser, alice, url.Values{}).User.Username, "alice")
+	is.True(serveErr(contr.ServeGetUser, alice, url.Values{"username": {"admin"}}) != nil)
+
+	// users can change their own password, and admins anyone's
+	serveAsUser(t, contr.ServeChangePassword, alice, url.Values{"username": {"alice"}, "password": {"new"}})
+	is.Equal(password("alice"), "new")
+	is.True(serveErr(contr.ServeChangePassword, alice, url.Values{"username": {"admin"}, "password": {"new"}}) != nil)
+	serveAsUser(t, contr.ServeChangePassword, &admin, url.Values{"username": {"alice"}, "password": {"newer"}})
+	is.Equal(password("alice"), "newer")
+
+	serveAsUser(t, contr.ServeUpdateUser, &admin, url.Values{"username": {"alice"}, "adminRole": {"true"}})
+	is.True(contr.DB.GetUserByName("alice").IsAdmin)
+	is.True(serveErr(contr.ServeDeleteUser, &admin, url.Values{"username": {"alice"}}) != nil) // can't delete admins
+	serveAsUser(t, contr.ServeUpdateUser, &admin, url.Values{"username": {"alice"}, "adminRole": {"false"}})
+
+	is.True(serveErr(contr.ServeDeleteUser, alice, url.Values{"username": {"alice"}}) != nil) // not admin
+	serveAsUser(t, contr.ServeDeleteUser, &admin, url.Values{"username": {"alice"}})
+	is.True(contr.DB.GetUserByName("alice") == nil)
+}
+
+func TestUserRoles(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	contr := makeController(t)
+
+	var admin db.User
+	is.NoErr(contr.DB.First(&admin).Error)
+
+	serveAsUser(t, contr.ServeCreateUser, &admin, url.Values{
+		"username":     {"kid"},
+		"password":     {"pass"},
+		"streamRole":   {"true"},
+		"playlistRole": {"true"},
+		"shareRole":    {"false"},
+	})
+	kid := contr.DB.GetUserByName("kid")
+	is.True(kid.PlaylistRole)
+	is.True(!kid.DownloadRole) // subsonic's default for a new user
+	is.True(!kid.ShareRole)
+
+	resp := serveAsUser(t, contr.ServeGetUser, kid, url.Values{}).User
+	is.True(resp.StreamRole)
+	is.True(resp.PlaylistRole)
+	is.True(!resp.DownloadRole)
+	is.True(!resp.ShareRole)
+	is.True(!resp.AdminRole)
+
+	resp = serveAsUser(t, contr.ServeGetUser, &admin, url.Values{}).User
+	is.True(resp.AdminRole)
+	is.True(resp.DownloadRole) // admins have every role
+
+	serveAsUser(t, contr.ServeUpdateUser, &admin, url.Values{"username": {"kid"}, "downloadRole": {"true"}})
+	kid = contr.DB.GetUserByName("kid")
+	is.True(kid.DownloadRole)
+	is.True(kid.PlaylistRole) // not given, so unchanged
+
+	// routes for a role aren't reached by users without it
+	var reached bool
+	handler := contr.WithRole(db.RoleShare)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { reached = true }))
+	rr, req := makeHTTPMock(url.Values{})
+	handler.ServeHTTP(rr, withUser(req, kid))
+	is.True(!reached)
+	is.True(strings.Contains(rr.Body.String(), "share role"))
+
+	rr, req = makeHTTPMock(url.Values{})
+	handler.ServeHTTP(rr, withUser(req, &admin))
+	is.True(reached)
+}
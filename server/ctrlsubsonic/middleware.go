@@ -5,25 +5,44 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
+	"log"
 	"net/http"
 
+	"go.senan.xyz/gonic/db"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/params"
 	"go.senan.xyz/gonic/server/ctrlsubsonic/spec"
 )
 
-func checkCredsToken(password, token, salt string) bool {
+// checkCredsToken checks the token against the user's password, which is only decrypted
+// here and in checkCredsBasic
+func checkCredsToken(key *db.PasswordKey, encrypted, token, salt string) bool {
+	password, err := key.Decrypt(encrypted)
+	if err != nil {
+		log.Printf("error decrypting password: %v\n", err)
+		return false
+	}
 	toHash := fmt.Sprintf("%s%s", password, salt)
 	hash := md5.Sum([]byte(toHash))
 	expToken := hex.EncodeToString(hash[:])
 	return token == expToken
 }
 
-func checkCredsBasic(password, given string) bool {
+func checkCredsBasic(key *db.PasswordKey, encrypted, given string) bool {
+	password, err := key.Decrypt(encrypted)
+	if err != nil {
+		log.Printf("error decrypting password: %v\n", err)
+		return false
+	}
+	return password == decodePassword(given)
+}
+
+// decodePassword decodes a password that the client hex encoded with an "enc:" prefix
+func decodePassword(given string) string {
 	if len(given) >= 4 && given[:4] == "enc:" {
 		bytes, _ := hex.DecodeString(given[4:])
-		given = string(bytes)
+		return string(bytes)
 	}
-	return password == given
+	return given
 }
 
 func (c *Controller) WithParams(next http.Handler) http.Handler {
@@ -41,6 +60,10 @@ func (c *Controller) WithRequiredParams(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		params := r.Context().Value(CtxParams).(params.Params)
 		for _, req := range requiredParameters {
+			// an api key says who the user is by itself
+			if req == "u" && params.GetOr("apiKey", "") != "" {
+				continue
+			}
 			if _, err := params.Get(req); err != nil {
 				_ = writeResp(w, r, spec.NewError(10,
 					"please provide a `%s` parameter", req))
@@ -59,6 +82,34 @@ func (c *Controller) WithUser(next http.Handler) http.Handler {
 		password, _ := params.Get("p")
 		token, _ := params.Get("t")
 		salt, _ := params.Get("s")
+		apiKey, _ := params.Get("apiKey")
+		ip := c.ClientIP(r)
+
+		if apiKey != "" {
+			if username != "" || password != "" || token != "" || salt != "" {
+				_ = writeResp(w, r, spec.NewError(43,
+					"please provide `apiKey`, or a username and password, but not both"))
+				return
+			}
+			if wait := c.LoginLimiter.Wait(ip, ""); wait > 0 {
+				_ = writeResp(w, r, spec.NewError(44,
+					"too many failed logins, please try again in %s", wait))
+				return
+			}
+			user, err := c.DB.GetUserByAPIKey(apiKey)
+			if err != nil {
+				_ = writeResp(w, r, spec.NewError(0, "find api key: %v", err))
+				return
+			}
+			if user == nil {
+				c.LoginLimiter.Fail(ip, "")
+				_ = writeResp(w, r, spec.NewError(44, "invalid api key"))
+				return
+			}
+			withUser := context.WithValue(r.Context(), CtxUser, user)
+			next.ServeHTTP(w, r.WithContext(withUser))
+			return
+		}
 
 		passwordAuth := token == "" && salt == ""
 		tokenAuth := password == ""
@@ -67,23 +118,46 @@ func (c *Controller) WithUser(next http.Handler) http.Handler {
 				"please provide `t` and `s`, or just `p`"))
 			return
 		}
+		if wait := c.LoginLimiter.Wait(ip, username); wait > 0 {
+			_ = writeResp(w, r, spec.NewError(40,
+				"too many failed logins, please try again in %s", wait))
+			return
+		}
 		user := c.DB.GetUserByName(username)
 		if user == nil {
+			c.LoginLimiter.Fail(ip, "")
 			_ = writeResp(w, r, spec.NewError(40,
 				"invalid username `%s`", username))
 			return
 		}
 		var credsOk bool
 		if tokenAuth {
-			credsOk = checkCredsToken(user.Password, token, salt)
+			credsOk = checkCredsToken(c.PasswordKey, user.Password, token, salt)
 		} else {
-			credsOk = checkCredsBasic(user.Password, password)
+			credsOk = checkCredsBasic(c.PasswordKey, user.Password, password)
 		}
 		if !credsOk {
+			c.LoginLimiter.Fail(ip, username)
 			_ = writeResp(w, r, spec.NewError(40, "invalid password"))
 			return
 		}
+		c.LoginLimiter.Succeed(username)
 		withUser := context.WithValue(r.Context(), CtxUser, user)
 		next.ServeHTTP(w, r.WithContext(withUser))
 	})
 }
+
+// WithRole only lets users with the role through, eg. for routes that change the library
+func (c *Controller) WithRole(role db.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := r.Context().Value(CtxUser).(*db.User)
+			if !user.HasRole(role) {
+				_ = writeResp(w, r, spec.NewError(50,
+					"user `%s` doesn't have the %s role", user.Name, role))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
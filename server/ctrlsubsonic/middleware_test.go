@@ -0,0 +1,125 @@
+package ctrlsubsonic
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/loginlimit"
+	"go.senan.xyz/gonic/server/ctrlsubsonic/spec"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	contr := makeController(t)
+
+	admin := contr.DB.GetUserByID(1)
+	key, apiKey, err := contr.DB.CreateAPIKey(admin, "phone")
+	is.NoErr(err)
+	is.True(apiKey.Hash != key) // only the hash is stored
+
+	whoami := func(r *http.Request) *spec.Response {
+		user := r.Context().Value(CtxUser).(*db.User)
+		sub := spec.NewResponse()
+		sub.User = &spec.User{Username: user.Name}
+		return sub
+	}
+	handler := contr.WithParams(contr.WithRequiredParams(contr.WithUser(contr.H(whoami))))
+	serve := func(query url.Values) *spec.Response {
+		query.Set("c", mockClientName)
+		query.Set("f", "json")
+		req := httptest.NewRequest(http.MethodGet, "/rest/ping?"+query.Encode(), nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var body struct {
+			Response *spec.Response `json:"subsonic-response"`
+		}
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &body))
+		return body.Response
+	}
+
+	resp := serve(url.Values{"apiKey": {key}})
+	is.Equal(resp.Error, nil)
+	is.Equal(resp.User.Username, admin.Name)
+
+	var used db.APIKey
+	is.NoErr(contr.DB.First(&used, apiKey.ID).Error)
+	is.True(used.LastUsed != nil)
+
+	resp = serve(url.Values{"apiKey": {"not a key"}})
+	is.Equal(resp.Error.Code, 44)
+
+	resp = serve(url.Values{"apiKey": {key}, "u": {mockUsername}, "p": {mockPassword}})
+	is.Equal(resp.Error.Code, 43)
+
+	// revoked keys don't work anymore
+	is.NoErr(contr.DB.Delete(apiKey).Error)
+	resp = serve(url.Values{"apiKey": {key}})
+	is.Equal(resp.Error.Code, 44)
+
+	// and passwords still do
+	resp = serve(url.Values{"u": {mockUsername}, "p": {mockPassword}})
+	is.Equal(resp.Error, nil)
+	is.Equal(resp.User.Username, mockUsername)
+}
+
+func TestLoginLimit(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	contr := makeController(t)
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	contr.TrustedProxies = []*net.IPNet{trusted}
+
+	handler := contr.WithParams(contr.WithRequiredParams(contr.WithUser(contr.H(contr.ServePing))))
+	serve := func(remoteAddr, forwardedFor, password string) *spec.Response {
+		query := url.Values{"u": {mockUsername}, "p": {password}, "c": {mockClientName}, "f": {"json"}}
+		req := httptest.NewRequest(http.MethodGet, "/rest/ping?"+query.Encode(), nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var body struct {
+			Response *spec.Response `json:"subsonic-response"`
+		}
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &body))
+		return body.Response
+	}
+
+	for i := 0; i < contr.LoginLimiter.FreeFailures+1; i++ {
+		resp := serve("10.0.0.1:1234", "192.0.2.1", "wrong")
+		is.Equal(resp.Error.Code, 40)
+	}
+
+	// even the right password is refused while the user is locked
+	resp := serve("192.0.2.2:1234", "", mockPassword)
+	is.Equal(resp.Error.Code, 40)
+	is.True(strings.Contains(resp.Error.Message, "too many failed logins"))
+
+	// the client behind the trusted proxy was locked, not the proxy
+	var ipFailures *loginlimit.Failures
+	for _, f := range contr.LoginLimiter.Failures() {
+		if f.Kind == loginlimit.KindIP {
+			ipFailures = f
+		}
+	}
+	is.True(ipFailures != nil)
+	is.Equal(ipFailures.Name, "192.0.2.1")
+
+	contr.LoginLimiter.Clear(loginlimit.KindUsername, mockUsername)
+	resp = serve("192.0.2.2:1234", "", mockPassword)
+	is.Equal(resp.Error, nil)
+
+	// untrusted clients can't pretend to be someone else
+	resp = serve("192.0.2.1:1234", "192.0.2.3", mockPassword)
+	is.Equal(resp.Error.Code, 40)
+}
@@ -67,24 +67,30 @@ func NewTCTrackByFolder(t *db.Track, parent *db.Album) *TrackChild {
 			parent.RightPath,
 			t.Filename,
 		),
-		ParentID:      parent.SID(),
-		Duration:      t.Length,
-		Genre:         strings.Join(t.GenreStrings(), ", "),
-		Year:          parent.TagYear,
-		Bitrate:       t.Bitrate,
-		IsDir:         false,
-		Type:          "music",
-		CreatedAt:     t.CreatedAt,
-		AverageRating: formatRating(t.AverageRating),
+		ParentID:        parent.SID(),
+		Duration:        t.Length,
+		Genre:           strings.Join(t.GenreStrings(), ", "),
+		Year:            parent.TagYear,
+		Bitrate:         t.Bitrate,
+		IsDir:           false,
+		Type:            "music",
+		CreatedAt:       t.CreatedAt,
+		AverageRating:   formatRating(t.AverageRating),
+		ReplayGain:      newReplayGain(t),
+		BPM:             t.TagBPM,
+		Comment:         t.TagComment,
+		DisplayComposer: t.TagComposer,
 	}
 	if trCh.Title == "" {
-		trCh.Title = t.Filename
+		trCh.Title = path.Base(t.Filename)
 	}
 	if parent.Cover != "" {
 		trCh.CoverID = parent.SID()
 	}
 	if t.Album != nil {
 		trCh.Album = t.Album.RightPath
+		// the track's own folder may be a disc folder under the album's
+		trCh.Path = t.RelPath()
 	}
 	if t.TrackStar != nil {
 		trCh.Starred = &t.TrackStar.StarDate
@@ -92,6 +98,7 @@ func NewTCTrackByFolder(t *db.Track, parent *db.Album) *TrackChild {
 	if t.TrackRating != nil {
 		trCh.UserRating = t.TrackRating.Rating
 	}
+	trCh.PlayCount, trCh.Played = t.PlayCount, t.LastPlayed
 	return trCh
 }
 
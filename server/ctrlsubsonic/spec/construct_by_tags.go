@@ -17,10 +17,20 @@ func NewAlbumByTags(a *db.Album, artist *db.Artist) *Album {
 		Genre:         strings.Join(a.GenreStrings(), ", "),
 		Duration:      a.Duration,
 		AverageRating: formatRating(a.AverageRating),
+		IsCompilation: a.TagCompilation,
 	}
 	if a.Cover != "" {
 		ret.CoverID = a.SID()
 	}
+	if a.TagLabel != "" {
+		ret.RecordLabels = []*RecordLabel{{Name: a.TagLabel}}
+	}
+	if a.TagReleaseType != "" {
+		ret.ReleaseTypes = strings.Split(a.TagReleaseType, ";")
+	}
+	if a.TagOriginalYear != 0 {
+		ret.OriginalReleaseDate = &ItemDate{Year: a.TagOriginalYear}
+	}
 	if a.AlbumStar != nil {
 		ret.Starred = &a.AlbumStar.StarDate
 	}
@@ -51,14 +61,18 @@ func NewTrackByTags(t *db.Track, album *db.Album) *TrackChild {
 			album.RightPath,
 			t.Filename,
 		),
-		Album:         album.TagTitle,
-		AlbumID:       album.SID(),
-		Genre:         strings.Join(t.GenreStrings(), ", "),
-		Duration:      t.Length,
-		Bitrate:       t.Bitrate,
-		Type:          "music",
-		Year:          album.TagYear,
-		AverageRating: formatRating(t.AverageRating),
+		Album:           album.TagTitle,
+		AlbumID:         album.SID(),
+		Genre:           strings.Join(t.GenreStrings(), ", "),
+		Duration:        t.Length,
+		Bitrate:         t.Bitrate,
+		Type:            "music",
+		Year:            album.TagYear,
+		AverageRating:   formatRating(t.AverageRating),
+		ReplayGain:      newReplayGain(t),
+		BPM:             t.TagBPM,
+		Comment:         t.TagComment,
+		DisplayComposer: t.TagComposer,
 	}
 	if album.Cover != "" {
 		ret.CoverID = album.SID()
@@ -69,6 +83,7 @@ func NewTrackByTags(t *db.Track, album *db.Album) *TrackChild {
 	if t.TrackRating != nil {
 		ret.UserRating = t.TrackRating.Rating
 	}
+	ret.PlayCount, ret.Played = t.PlayCount, t.LastPlayed
 	if album.TagArtist != nil {
 		ret.ArtistID = album.TagArtist.SID()
 	}
@@ -111,3 +126,15 @@ func NewGenre(g *db.Genre) *Genre {
 		SongCount:  g.TrackCount,
 	}
 }
+
+func newReplayGain(t *db.Track) *ReplayGain {
+	if t.ReplayGainTrackGain == nil && t.ReplayGainTrackPeak == nil && t.ReplayGainAlbumGain == nil && t.ReplayGainAlbumPeak == nil {
+		return nil
+	}
+	return &ReplayGain{
+		TrackGain: t.ReplayGainTrackGain,
+		AlbumGain: t.ReplayGainAlbumGain,
+		TrackPeak: t.ReplayGainTrackPeak,
+		AlbumPeak: t.ReplayGainAlbumPeak,
+	}
+}
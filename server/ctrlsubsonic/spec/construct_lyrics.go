@@ -0,0 +1,37 @@
+package spec
+
+import (
+	"go.senan.xyz/gonic/lyrics"
+)
+
+// langUnknown is what taggers commonly use when the lyrics' language isn't known
+const langUnknown = "xxx"
+
+func NewStructuredLyrics(l *lyrics.Lyrics, artist, title string) *StructuredLyrics {
+	ret := &StructuredLyrics{
+		Lang:          langUnknown,
+		Synced:        l.Synced,
+		DisplayArtist: artist,
+		DisplayTitle:  title,
+		Offset:        int(l.Offset.Milliseconds()),
+		Lines:         make([]*LyricsLine, 0, len(l.Lines)),
+	}
+	if l.Lang != "" {
+		ret.Lang = l.Lang
+	}
+	if l.Artist != "" {
+		ret.DisplayArtist = l.Artist
+	}
+	if l.Title != "" {
+		ret.DisplayTitle = l.Title
+	}
+	for _, line := range l.Lines {
+		specLine := &LyricsLine{Value: line.Value}
+		if l.Synced {
+			start := int(line.Start.Milliseconds())
+			specLine.Start = &start
+		}
+		ret.Lines = append(ret.Lines, specLine)
+	}
+	return ret
+}
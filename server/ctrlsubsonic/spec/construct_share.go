@@ -0,0 +1,27 @@
+package spec
+
+import (
+	"strconv"
+
+	"go.senan.xyz/gonic/db"
+)
+
+func NewShare(s *db.Share, url string, tracks []*db.Track) *Share {
+	ret := &Share{
+		ID:          strconv.Itoa(s.ID),
+		URL:         url,
+		Description: s.Description,
+		Created:     s.CreatedAt,
+		Expires:     s.Expires,
+		LastVisited: s.LastVisited,
+		VisitCount:  s.VisitCount,
+		Entries:     make([]*TrackChild, len(tracks)),
+	}
+	if s.User != nil {
+		ret.Username = s.User.Name
+	}
+	for i, track := range tracks {
+		ret.Entries[i] = NewTrackByTags(track, track.Album)
+	}
+	return ret
+}
@@ -25,6 +25,7 @@ type Response struct {
 	Version               string                 `xml:"version,attr"          json:"version"`
 	XMLNS                 string                 `xml:"xmlns,attr"            json:"-"`
 	Type                  string                 `xml:"type,attr"             json:"type"`
+	OpenSubsonic          bool                   `xml:"openSubsonic,attr"     json:"openSubsonic"`
 	Error                 *Error                 `xml:"error"                 json:"error,omitempty"`
 	Albums                *Albums                `xml:"albumList"             json:"albumList,omitempty"`
 	AlbumsTwo             *Albums                `xml:"albumList2"            json:"albumList2,omitempty"`
@@ -42,6 +43,7 @@ type Response struct {
 	SearchResultTwo       *SearchResultTwo       `xml:"searchResult2"         json:"searchResult2,omitempty"`
 	SearchResultThree     *SearchResultThree     `xml:"searchResult3"         json:"searchResult3,omitempty"`
 	User                  *User                  `xml:"user"                  json:"user,omitempty"`
+	Users                 *Users                 `xml:"users"                 json:"users,omitempty"`
 	Playlists             *Playlists             `xml:"playlists"             json:"playlists,omitempty"`
 	Playlist              *Playlist              `xml:"playlist"              json:"playlist,omitempty"`
 	ArtistInfo            *ArtistInfo            `xml:"artistInfo"            json:"artistInfo,omitempty"`
@@ -60,14 +62,20 @@ type Response struct {
 	SimilarSongsTwo       *SimilarSongsTwo       `xml:"similarSongs2"         json:"similarSongs2,omitempty"`
 	InternetRadioStations *InternetRadioStations `xml:"internetRadioStations" json:"internetRadioStations,omitempty"`
 	Lyrics                *Lyrics                `xml:"lyrics"                json:"lyrics,omitempty"`
+	LyricsList            *LyricsList            `xml:"lyricsList"            json:"lyricsList,omitempty"`
+	NowPlaying            *NowPlaying            `xml:"nowPlaying"            json:"nowPlaying,omitempty"`
+	Shares                *Shares                `xml:"shares"                json:"shares,omitempty"`
+
+	OpenSubsonicExtensions []*OpenSubsonicExtension `xml:"openSubsonicExtensions" json:"openSubsonicExtensions,omitempty"`
 }
 
 func NewResponse() *Response {
 	return &Response{
-		Status:  "ok",
-		XMLNS:   xmlns,
-		Version: apiVersion,
-		Type:    gonic.Name,
+		Status:       "ok",
+		XMLNS:        xmlns,
+		Version:      apiVersion,
+		Type:         gonic.Name,
+		OpenSubsonic: true,
 	}
 }
 
@@ -97,7 +105,8 @@ func NewError(code int, message string, a ...interface{}) *Response {
 			Code:    code,
 			Message: fmt.Sprintf(message, a...),
 		},
-		Type: gonic.Name,
+		Type:         gonic.Name,
+		OpenSubsonic: true,
 	}
 }
 
@@ -128,12 +137,53 @@ type Album struct {
 	Starred       *time.Time `xml:"starred,attr,omitempty"         json:"starred,omitempty"`
 	UserRating    int        `xml:"userRating,attr,omitempty"      json:"userRating,omitempty"`
 	AverageRating string     `xml:"averageRating,attr,omitempty"   json:"averageRating,omitempty"`
+	// opensubsonic
+	RecordLabels        []*RecordLabel `xml:"recordLabels,omitempty"        json:"recordLabels,omitempty"`
+	IsCompilation       bool           `xml:"isCompilation,attr,omitempty"  json:"isCompilation,omitempty"`
+	ReleaseTypes        []string       `xml:"releaseTypes,omitempty"        json:"releaseTypes,omitempty"`
+	OriginalReleaseDate *ItemDate      `xml:"originalReleaseDate,omitempty" json:"originalReleaseDate,omitempty"`
+}
+
+type RecordLabel struct {
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type ItemDate struct {
+	Year int `xml:"year,attr,omitempty" json:"year,omitempty"`
 }
 
 type RandomTracks struct {
 	List []*TrackChild `xml:"song" json:"song"`
 }
 
+type NowPlaying struct {
+	List []*NowPlayingEntry `xml:"entry" json:"entry"`
+}
+
+type NowPlayingEntry struct {
+	*TrackChild
+	Username   string `xml:"username,attr"             json:"username"`
+	MinutesAgo int    `xml:"minutesAgo,attr"           json:"minutesAgo"`
+	PlayerID   int    `xml:"playerId,attr"             json:"playerId"`
+	PlayerName string `xml:"playerName,attr,omitempty" json:"playerName,omitempty"`
+}
+
+type Shares struct {
+	List []*Share `xml:"share" json:"share"`
+}
+
+type Share struct {
+	ID          string        `xml:"id,attr"                    json:"id"`
+	URL         string        `xml:"url,attr"                   json:"url"`
+	Description string        `xml:"description,attr,omitempty" json:"description,omitempty"`
+	Username    string        `xml:"username,attr"              json:"username"`
+	Created     time.Time     `xml:"created,attr"               json:"created"`
+	Expires     *time.Time    `xml:"expires,attr,omitempty"     json:"expires,omitempty"`
+	LastVisited *time.Time    `xml:"lastVisited,attr,omitempty" json:"lastVisited,omitempty"`
+	VisitCount  int           `xml:"visitCount,attr"            json:"visitCount"`
+	Entries     []*TrackChild `xml:"entry"                      json:"entry"`
+}
+
 type TracksByGenre struct {
 	List []*TrackChild `xml:"song" json:"song"`
 }
@@ -167,6 +217,22 @@ type TrackChild struct {
 	Starred       *time.Time `xml:"starred,attr,omitempty"         json:"starred,omitempty"`
 	UserRating    int        `xml:"userRating,attr,omitempty"      json:"userRating,omitempty"`
 	AverageRating string     `xml:"averageRating,attr,omitempty"   json:"averageRating,omitempty"`
+	PlayCount     int        `xml:"playCount,attr,omitempty"       json:"playCount,omitempty"`
+	// opensubsonic
+	ReplayGain      *ReplayGain `xml:"replayGain,omitempty"           json:"replayGain,omitempty"`
+	BPM             int         `xml:"bpm,attr,omitempty"             json:"bpm,omitempty"`
+	Comment         string      `xml:"comment,attr,omitempty"         json:"comment,omitempty"`
+	DisplayComposer string      `xml:"displayComposer,attr,omitempty" json:"displayComposer,omitempty"`
+	Played          *time.Time  `xml:"played,attr,omitempty"          json:"played,omitempty"`
+}
+
+// https://opensubsonic.netlify.app/docs/responses/replaygain/
+
+type ReplayGain struct {
+	TrackGain *float32 `xml:"trackGain,attr,omitempty" json:"trackGain,omitempty"`
+	AlbumGain *float32 `xml:"albumGain,attr,omitempty" json:"albumGain,omitempty"`
+	TrackPeak *float32 `xml:"trackPeak,attr,omitempty" json:"trackPeak,omitempty"`
+	AlbumPeak *float32 `xml:"albumPeak,attr,omitempty" json:"albumPeak,omitempty"`
 }
 
 type Artists struct {
@@ -221,8 +287,10 @@ type Licence struct {
 }
 
 type ScanStatus struct {
-	Scanning bool `xml:"scanning,attr"        json:"scanning"`
-	Count    int  `xml:"count,attr,omitempty" json:"count,omitempty"`
+	Scanning    bool       `xml:"scanning,attr"              json:"scanning"`
+	Count       int        `xml:"count,attr,omitempty"       json:"count,omitempty"`
+	FolderCount int        `xml:"folderCount,attr,omitempty" json:"folderCount,omitempty"`
+	LastScan    *time.Time `xml:"lastScan,attr,omitempty"    json:"lastScan,omitempty"`
 }
 
 type SearchResultTwo struct {
@@ -255,6 +323,10 @@ type User struct {
 	Folder              []int  `xml:"folder,attr"              json:"folder"`
 }
 
+type Users struct {
+	List []*User `xml:"user" json:"user"`
+}
+
 type Playlists struct {
 	List []*Playlist `xml:"playlist" json:"playlist"`
 }
@@ -268,6 +340,7 @@ type Playlist struct {
 	Created   time.Time     `xml:"created,attr"   json:"created"`
 	Duration  int           `xml:"duration,attr"  json:"duration,omitempty"`
 	Public    bool          `xml:"public,attr"    json:"public,omitempty"`
+	Readonly  bool          `xml:"readonly,attr,omitempty" json:"readonly,omitempty"` // opensubsonic, for smart playlists
 	List      []*TrackChild `xml:"entry"          json:"entry"`
 }
 
@@ -417,6 +490,33 @@ type Lyrics struct {
 	Title  string `xml:"title,attr,omitempty"  json:"title,omitempty"`
 }
 
+// https://opensubsonic.netlify.app/docs/responses/lyricslist/
+
+type LyricsList struct {
+	StructuredLyrics []*StructuredLyrics `xml:"structuredLyrics" json:"structuredLyrics"`
+}
+
+type StructuredLyrics struct {
+	Lang          string        `xml:"lang,attr"                    json:"lang"`
+	Synced        bool          `xml:"synced,attr"                  json:"synced"`
+	DisplayArtist string        `xml:"displayArtist,attr,omitempty" json:"displayArtist,omitempty"`
+	DisplayTitle  string        `xml:"displayTitle,attr,omitempty"  json:"displayTitle,omitempty"`
+	Offset        int           `xml:"offset,attr,omitempty"        json:"offset,omitempty"`
+	Lines         []*LyricsLine `xml:"line"                         json:"line"`
+}
+
+type LyricsLine struct {
+	Start *int   `xml:"start,attr,omitempty" json:"start,omitempty"`
+	Value string `xml:",chardata"            json:"value"`
+}
+
+// https://opensubsonic.netlify.app/docs/responses/opensubsonicextension/
+
+type OpenSubsonicExtension struct {
+	Name     string `xml:"name,attr" json:"name"`
+	Versions []int  `xml:"versions"  json:"versions"`
+}
+
 func formatRating(rating float64) string {
 	if rating == 0 {
 		return ""
@@ -3,9 +3,11 @@ package server
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"time"
 
 	"github.com/gorilla/handlers"
@@ -15,6 +17,8 @@ import (
 
 	"go.senan.xyz/gonic/db"
 	"go.senan.xyz/gonic/jukebox"
+	"go.senan.xyz/gonic/loginlimit"
+	"go.senan.xyz/gonic/oidc"
 	"go.senan.xyz/gonic/paths"
 	"go.senan.xyz/gonic/podcasts"
 	"go.senan.xyz/gonic/scanner"
@@ -32,13 +36,25 @@ import (
 type Options struct {
 	DB             *db.DB
 	MusicPaths     paths.MusicPaths
+	PasswordKey    *db.PasswordKey
+	TrustedProxies []*net.IPNet
 	PodcastPath    string
 	CachePath      string
 	CoverCachePath string
 	ProxyPrefix    string
 	GenreSplit     string
+	ArtistSplit    string
+	ScanWorkers    int
+	ScanExclude    *regexp.Regexp
+	DiscFolders    *regexp.Regexp
+	TagReader      tags.Reader
+	CoverReader    tags.CoverReader
 	HTTPLog        bool
 	JukeboxEnabled bool
+
+	ProxyAuth       *ctrladmin.ProxyAuth
+	OIDC            *oidc.Provider
+	AuthCreateUsers bool
 }
 
 type Server struct {
@@ -50,13 +66,25 @@ type Server struct {
 }
 
 func New(opts Options) (*Server, error) {
-	tagger := &tags.TagReader{}
+	tagger := opts.TagReader
+	if tagger == nil {
+		tagger = &tags.TagReader{}
+	}
+	covers := opts.CoverReader
+	if covers == nil {
+		covers = &tags.FFmpegCoverReader{}
+	}
 
-	scanner := scanner.New(opts.MusicPaths.Paths(), opts.DB, opts.GenreSplit, tagger)
+	scanner := scanner.New(opts.MusicPaths.Paths(), opts.DB, opts.GenreSplit, opts.ArtistSplit, opts.ScanWorkers, opts.ScanExclude, opts.DiscFolders, tagger, covers)
 	base := &ctrlbase.Controller{
 		DB:          opts.DB,
 		ProxyPrefix: opts.ProxyPrefix,
 		Scanner:     scanner,
+		MusicPaths:  opts.MusicPaths,
+		PasswordKey: opts.PasswordKey,
+
+		LoginLimiter:   loginlimit.New(),
+		TrustedProxies: opts.TrustedProxies,
 	}
 
 	// router with common wares for admin / subsonic
@@ -92,20 +120,24 @@ func New(opts Options) (*Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("create admin controller: %w", err)
 	}
+	ctrlAdmin.ProxyAuth = opts.ProxyAuth
+	ctrlAdmin.OIDC = opts.OIDC
+	ctrlAdmin.AutoCreateUsers = opts.AuthCreateUsers
 	ctrlSubsonic := &ctrlsubsonic.Controller{
 		Controller:     base,
 		CachePath:      opts.CachePath,
 		CoverCachePath: opts.CoverCachePath,
 		PodcastsPath:   opts.PodcastPath,
-		MusicPaths:     opts.MusicPaths,
 		Scrobblers:     []scrobble.Scrobbler{&lastfm.Scrobbler{DB: opts.DB}, &listenbrainz.Scrobbler{}},
 		Podcasts:       podcast,
 		Transcoder:     cacheTranscoder,
+		CoverReader:    covers,
 	}
 
 	setupMisc(r, base)
 	setupAdmin(r.PathPrefix("/admin").Subrouter(), ctrlAdmin)
 	setupSubsonic(r.PathPrefix("/rest").Subrouter(), ctrlSubsonic)
+	setupShare(r.PathPrefix("/share").Subrouter(), ctrlAdmin, ctrlSubsonic)
 
 	server := &Server{
 		scanner: scanner,
@@ -148,6 +180,12 @@ func setupAdmin(r *mux.Router, ctrl *ctrladmin.Controller) {
 	r.Use(ctrl.WithSession)
 	r.Handle("/login", ctrl.H(ctrl.ServeLogin))
 	r.Handle("/login_do", ctrl.HR(ctrl.ServeLoginDo)) // "raw" handler, updates session
+	r.Handle("/login_totp", ctrl.H(ctrl.ServeLoginTOTP))
+	r.Handle("/login_totp_do", ctrl.HR(ctrl.ServeLoginTOTPDo)) // "raw" handler, updates session
+	if ctrl.OIDC != nil {
+		r.Handle("/login_oidc", ctrl.HR(ctrl.ServeLoginOIDC))                  // "raw" handler, updates session
+		r.Handle("/login_oidc_callback", ctrl.HR(ctrl.ServeLoginOIDCCallback)) // "raw" handler, updates session
+	}
 
 	staticHandler := http.StripPrefix("/admin", http.FileServer(http.FS(assets.Static)))
 	r.PathPrefix("/static").Handler(staticHandler)
@@ -166,12 +204,36 @@ func setupAdmin(r *mux.Router, ctrl *ctrladmin.Controller) {
 	routUser.Handle("/delete_own_avatar_do", ctrl.H(ctrl.ServeDeleteOwnAvatarDo))
 	routUser.Handle("/link_lastfm_do", ctrl.H(ctrl.ServeLinkLastFMDo))
 	routUser.Handle("/unlink_lastfm_do", ctrl.H(ctrl.ServeUnlinkLastFMDo))
+	if ctrl.OIDC != nil {
+		routUser.Handle("/link_oidc_do", ctrl.HR(ctrl.ServeLinkOIDCDo)) // "raw" handler, updates session
+		routUser.Handle("/unlink_oidc_do", ctrl.H(ctrl.ServeUnlinkOIDCDo))
+	}
 	routUser.Handle("/link_listenbrainz_do", ctrl.H(ctrl.ServeLinkListenBrainzDo))
 	routUser.Handle("/unlink_listenbrainz_do", ctrl.H(ctrl.ServeUnlinkListenBrainzDo))
-	routUser.Handle("/upload_playlist_do", ctrl.H(ctrl.ServeUploadPlaylistDo))
-	routUser.Handle("/delete_playlist_do", ctrl.H(ctrl.ServeDeletePlaylistDo))
 	routUser.Handle("/create_transcode_pref_do", ctrl.H(ctrl.ServeCreateTranscodePrefDo))
 	routUser.Handle("/delete_transcode_pref_do", ctrl.H(ctrl.ServeDeleteTranscodePrefDo))
+	routUser.Handle("/create_api_key_do", ctrl.H(ctrl.ServeCreateAPIKeyDo))
+	routUser.Handle("/delete_api_key_do", ctrl.H(ctrl.ServeDeleteAPIKeyDo))
+	routUser.Handle("/totp", ctrl.H(ctrl.ServeTOTP))
+	routUser.Handle("/enable_totp_do", ctrl.H(ctrl.ServeEnableTOTPDo))
+	routUser.Handle("/disable_totp_do", ctrl.H(ctrl.ServeDisableTOTPDo))
+	routUser.Handle("/create_totp_recovery_codes_do", ctrl.H(ctrl.ServeCreateTOTPRecoveryCodesDo))
+
+	// playlist routes (if session is valid, and has the playlist role)
+	routPlaylist := routUser.NewRoute().Subrouter()
+	routPlaylist.Use(ctrl.WithRoleSession(db.RolePlaylist))
+	routPlaylist.Handle("/upload_playlist_do", ctrl.H(ctrl.ServeUploadPlaylistDo))
+	routPlaylist.Handle("/delete_playlist_do", ctrl.H(ctrl.ServeDeletePlaylistDo))
+	routPlaylist.Handle("/create_smart_playlist", ctrl.H(ctrl.ServeCreateSmartPlaylist))
+	routPlaylist.Handle("/create_smart_playlist_do", ctrl.H(ctrl.ServeCreateSmartPlaylistDo))
+
+	// podcast routes (if session is valid, and has the podcast role)
+	routPodcast := routUser.NewRoute().Subrouter()
+	routPodcast.Use(ctrl.WithRoleSession(db.RolePodcast))
+	routPodcast.Handle("/add_podcast_do", ctrl.H(ctrl.ServePodcastAddDo))
+	routPodcast.Handle("/delete_podcast_do", ctrl.H(ctrl.ServePodcastDeleteDo))
+	routPodcast.Handle("/download_podcast_do", ctrl.H(ctrl.ServePodcastDownloadDo))
+	routPodcast.Handle("/update_podcast_do", ctrl.H(ctrl.ServePodcastUpdateDo))
 
 	// admin routes (if session is valid, and is admin)
 	routAdmin := routUser.NewRoute().Subrouter()
@@ -180,6 +242,12 @@ func setupAdmin(r *mux.Router, ctrl *ctrladmin.Controller) {
 	routAdmin.Handle("/change_username_do", ctrl.H(ctrl.ServeChangeUsernameDo))
 	routAdmin.Handle("/change_password", ctrl.H(ctrl.ServeChangePassword))
 	routAdmin.Handle("/change_password_do", ctrl.H(ctrl.ServeChangePasswordDo))
+	routAdmin.Handle("/change_roles", ctrl.H(ctrl.ServeChangeRoles))
+	routAdmin.Handle("/change_roles_do", ctrl.H(ctrl.ServeChangeRolesDo))
+	routAdmin.Handle("/failed_logins", ctrl.H(ctrl.ServeFailedLogins))
+	routAdmin.Handle("/clear_failed_logins_do", ctrl.H(ctrl.ServeClearFailedLoginsDo))
+	routAdmin.Handle("/change_music_folders", ctrl.H(ctrl.ServeChangeMusicFolders))
+	routAdmin.Handle("/change_music_folders_do", ctrl.H(ctrl.ServeChangeMusicFoldersDo))
 	routAdmin.Handle("/change_avatar", ctrl.H(ctrl.ServeChangeAvatar))
 	routAdmin.Handle("/change_avatar_do", ctrl.H(ctrl.ServeChangeAvatarDo))
 	routAdmin.Handle("/delete_avatar_do", ctrl.H(ctrl.ServeDeleteAvatarDo))
@@ -191,10 +259,6 @@ func setupAdmin(r *mux.Router, ctrl *ctrladmin.Controller) {
 	routAdmin.Handle("/update_lastfm_api_key_do", ctrl.H(ctrl.ServeUpdateLastFMAPIKeyDo))
 	routAdmin.Handle("/start_scan_inc_do", ctrl.H(ctrl.ServeStartScanIncDo))
 	routAdmin.Handle("/start_scan_full_do", ctrl.H(ctrl.ServeStartScanFullDo))
-	routAdmin.Handle("/add_podcast_do", ctrl.H(ctrl.ServePodcastAddDo))
-	routAdmin.Handle("/delete_podcast_do", ctrl.H(ctrl.ServePodcastDeleteDo))
-	routAdmin.Handle("/download_podcast_do", ctrl.H(ctrl.ServePodcastDownloadDo))
-	routAdmin.Handle("/update_podcast_do", ctrl.H(ctrl.ServePodcastUpdateDo))
 	routAdmin.Handle("/add_internet_radio_station_do", ctrl.H(ctrl.ServeInternetRadioStationAddDo))
 	routAdmin.Handle("/delete_internet_radio_station_do", ctrl.H(ctrl.ServeInternetRadioStationDeleteDo))
 	routAdmin.Handle("/update_internet_radio_station_do", ctrl.H(ctrl.ServeInternetRadioStationUpdateDo))
@@ -206,30 +270,64 @@ func setupAdmin(r *mux.Router, ctrl *ctrladmin.Controller) {
 	r.NotFoundHandler = notFoundRoute.GetHandler()
 }
 
+func setupShare(r *mux.Router, ctrlAdmin *ctrladmin.Controller, ctrlSubsonic *ctrlsubsonic.Controller) {
+	// public, for anyone with the link
+	r.Handle("/{uuid}", ctrlAdmin.H(ctrlAdmin.ServeShare))
+	r.Handle("/{uuid}/stream", ctrlSubsonic.WithParams(ctrlSubsonic.HR(ctrlSubsonic.ServeShareStream)))
+}
+
 func setupSubsonic(r *mux.Router, ctrl *ctrlsubsonic.Controller) {
 	r.Use(ctrl.WithParams)
+
+	// public
+	r.Handle("/getOpenSubsonicExtensions{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetOpenSubsonicExtensions))
+
+	r = r.NewRoute().Subrouter()
 	r.Use(ctrl.WithRequiredParams)
 	r.Use(ctrl.WithUser)
 
+	// routes for users with a role (admins have them all)
+	routRole := func(role db.Role) *mux.Router {
+		rout := r.NewRoute().Subrouter()
+		rout.Use(ctrl.WithRole(role))
+		return rout
+	}
+	routDownload := routRole(db.RoleDownload)
+	routJukebox := routRole(db.RoleJukebox)
+	routPodcast := routRole(db.RolePodcast)
+	routShare := routRole(db.RoleShare)
+	routScan := routRole(db.RoleScan)
+	routPlaylist := routRole(db.RolePlaylist)
+
 	// common
 	r.Handle("/getLicense{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetLicence))
 	r.Handle("/getMusicFolders{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetMusicFolders))
 	r.Handle("/getScanStatus{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetScanStatus))
 	r.Handle("/ping{_:(?:\\.view)?}", ctrl.H(ctrl.ServePing))
 	r.Handle("/scrobble{_:(?:\\.view)?}", ctrl.H(ctrl.ServeScrobble))
-	r.Handle("/startScan{_:(?:\\.view)?}", ctrl.H(ctrl.ServeStartScan))
+	r.Handle("/getNowPlaying{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetNowPlaying))
+	r.Handle("/getShares{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetShares))
+	routShare.Handle("/createShare{_:(?:\\.view)?}", ctrl.H(ctrl.ServeCreateShare))
+	routShare.Handle("/updateShare{_:(?:\\.view)?}", ctrl.H(ctrl.ServeUpdateShare))
+	routShare.Handle("/deleteShare{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDeleteShare))
+	routScan.Handle("/startScan{_:(?:\\.view)?}", ctrl.H(ctrl.ServeStartScan))
 	r.Handle("/getUser{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetUser))
+	r.Handle("/getUsers{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetUsers))
+	r.Handle("/createUser{_:(?:\\.view)?}", ctrl.H(ctrl.ServeCreateUser))
+	r.Handle("/updateUser{_:(?:\\.view)?}", ctrl.H(ctrl.ServeUpdateUser))
+	r.Handle("/deleteUser{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDeleteUser))
+	r.Handle("/changePassword{_:(?:\\.view)?}", ctrl.H(ctrl.ServeChangePassword))
 	r.Handle("/getPlaylists{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetPlaylists))
 	r.Handle("/getPlaylist{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetPlaylist))
-	r.Handle("/createPlaylist{_:(?:\\.view)?}", ctrl.H(ctrl.ServeCreatePlaylist))
-	r.Handle("/updatePlaylist{_:(?:\\.view)?}", ctrl.H(ctrl.ServeUpdatePlaylist))
-	r.Handle("/deletePlaylist{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDeletePlaylist))
+	routPlaylist.Handle("/createPlaylist{_:(?:\\.view)?}", ctrl.H(ctrl.ServeCreatePlaylist))
+	routPlaylist.Handle("/updatePlaylist{_:(?:\\.view)?}", ctrl.H(ctrl.ServeUpdatePlaylist))
+	routPlaylist.Handle("/deletePlaylist{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDeletePlaylist))
 	r.Handle("/savePlayQueue{_:(?:\\.view)?}", ctrl.H(ctrl.ServeSavePlayQueue))
 	r.Handle("/getPlayQueue{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetPlayQueue))
 	r.Handle("/getSong{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetSong))
 	r.Handle("/getRandomSongs{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetRandomSongs))
 	r.Handle("/getSongsByGenre{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetSongsByGenre))
-	r.Handle("/jukeboxControl{_:(?:\\.view)?}", ctrl.H(ctrl.ServeJukebox))
+	routJukebox.Handle("/jukeboxControl{_:(?:\\.view)?}", ctrl.H(ctrl.ServeJukebox))
 	r.Handle("/getBookmarks{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetBookmarks))
 	r.Handle("/createBookmark{_:(?:\\.view)?}", ctrl.H(ctrl.ServeCreateBookmark))
 	r.Handle("/deleteBookmark{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDeleteBookmark))
@@ -237,11 +335,12 @@ func setupSubsonic(r *mux.Router, ctrl *ctrlsubsonic.Controller) {
 	r.Handle("/getSimilarSongs{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetSimilarSongs))
 	r.Handle("/getSimilarSongs2{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetSimilarSongsTwo))
 	r.Handle("/getLyrics{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetLyrics))
+	r.Handle("/getLyricsBySongId{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetLyricsBySongID))
 
 	// raw
 	r.Handle("/getCoverArt{_:(?:\\.view)?}", ctrl.HR(ctrl.ServeGetCoverArt))
 	r.Handle("/stream{_:(?:\\.view)?}", ctrl.HR(ctrl.ServeStream))
-	r.Handle("/download{_:(?:\\.view)?}", ctrl.HR(ctrl.ServeStream))
+	routDownload.Handle("/download{_:(?:\\.view)?}", ctrl.HR(ctrl.ServeStream))
 	r.Handle("/getAvatar{_:(?:\\.view)?}", ctrl.HR(ctrl.ServeGetAvatar))
 
 	// browse by tag
@@ -270,11 +369,11 @@ func setupSubsonic(r *mux.Router, ctrl *ctrlsubsonic.Controller) {
 	// podcasts
 	r.Handle("/getPodcasts{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetPodcasts))
 	r.Handle("/getNewestPodcasts{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetNewestPodcasts))
-	r.Handle("/downloadPodcastEpisode{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDownloadPodcastEpisode))
-	r.Handle("/createPodcastChannel{_:(?:\\.view)?}", ctrl.H(ctrl.ServeCreatePodcastChannel))
-	r.Handle("/refreshPodcasts{_:(?:\\.view)?}", ctrl.H(ctrl.ServeRefreshPodcasts))
-	r.Handle("/deletePodcastChannel{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDeletePodcastChannel))
-	r.Handle("/deletePodcastEpisode{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDeletePodcastEpisode))
+	routPodcast.Handle("/downloadPodcastEpisode{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDownloadPodcastEpisode))
+	routPodcast.Handle("/createPodcastChannel{_:(?:\\.view)?}", ctrl.H(ctrl.ServeCreatePodcastChannel))
+	routPodcast.Handle("/refreshPodcasts{_:(?:\\.view)?}", ctrl.H(ctrl.ServeRefreshPodcasts))
+	routPodcast.Handle("/deletePodcastChannel{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDeletePodcastChannel))
+	routPodcast.Handle("/deletePodcastEpisode{_:(?:\\.view)?}", ctrl.H(ctrl.ServeDeletePodcastEpisode))
 
 	// internet radio
 	r.Handle("/getInternetRadioStations{_:(?:\\.view)?}", ctrl.H(ctrl.ServeGetInternetRadioStations))
@@ -0,0 +1,167 @@
+package smartplaylist
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// nsp is navidrome's smart playlist format. its rules are nested conditions like
+// {"inTheRange": {"year": [1990, 1999]}}. only the ones that match a field of Rules are
+// supported
+type nsp struct {
+	Name    string                       `json:"name"`
+	Comment string                       `json:"comment"`
+	All     []map[string]json.RawMessage `json:"all"`
+	Any     []map[string]json.RawMessage `json:"any"`
+	Sort    string                       `json:"sort"`
+	Order   string                       `json:"order"`
+	Limit   int                          `json:"limit"`
+}
+
+var nspSorts = map[string]string{
+	"random":     "random",
+	"dateadded":  "added",
+	"year":       "year",
+	"rating":     "rating",
+	"playcount":  "plays",
+	"lastplayed": "last_played",
+	"title":      "title",
+	"artist":     "artist",
+}
+
+func parseNSP(data []byte) (*Definition, error) {
+	var in nsp
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRules, err)
+	}
+	def := &Definition{Name: in.Name, Comment: in.Comment}
+	def.Limit = in.Limit
+
+	if len(in.Any) > 0 && len(in.All) > 0 {
+		return nil, fmt.Errorf("%w: both all and any", ErrUnsupported)
+	}
+	if err := def.addNSPGenres(in.Any); err != nil {
+		return nil, err
+	}
+	for _, cond := range in.All {
+		if err := def.addNSPCondition(cond); err != nil {
+			return nil, err
+		}
+	}
+
+	if in.Sort != "" {
+		sort, ok := nspSorts[strings.ToLower(strings.TrimPrefix(in.Sort, "-"))]
+		if !ok {
+			return nil, fmt.Errorf("%w: sort %q", ErrUnsupported, in.Sort)
+		}
+		if strings.HasPrefix(in.Sort, "-") != strings.EqualFold(in.Order, "desc") {
+			sort = "-" + sort
+		}
+		def.Sort = sort
+	}
+	return def, nil
+}
+
+func (def *Definition) addNSPCondition(cond map[string]json.RawMessage) error {
+	if len(cond) != 1 {
+		return fmt.Errorf("%w: condition with %d operators", ErrUnsupported, len(cond))
+	}
+	for op, raw := range cond {
+		if op == "any" {
+			var conditions []map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &conditions); err != nil {
+				return fmt.Errorf("%w: any: %v", ErrUnsupported, err)
+			}
+			return def.addNSPGenres(conditions)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return fmt.Errorf("%w: %q: %v", ErrUnsupported, op, err)
+		}
+		for field, value := range fields {
+			if err := def.addNSPField(op, strings.ToLower(field), value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addNSPGenres reads an "any" of genres, eg. {"any": [{"is": {"genre": "rock"}}, ...]},
+// the only "any" that fits Rules
+func (def *Definition) addNSPGenres(conditions []map[string]json.RawMessage) error {
+	for _, cond := range conditions {
+		var fields map[string]string
+		if err := json.Unmarshal(cond["is"], &fields); len(cond) != 1 || err != nil || len(fields) != 1 || fields["genre"] == "" {
+			return fmt.Errorf("%w: any, except of genres", ErrUnsupported)
+		}
+		def.Genres = append(def.Genres, fields["genre"])
+	}
+	return nil
+}
+
+//nolint:gocyclo // it's a long but flat list of what's supported
+func (def *Definition) addNSPField(op, field string, value json.RawMessage) error {
+	var (
+		str     string
+		num     int
+		boolean bool
+		rng     [2]int
+	)
+	unmarshal := func(dest interface{}) bool {
+		return json.Unmarshal(value, dest) == nil
+	}
+	switch {
+	case field == "genre" && op == "is" && unmarshal(&str):
+		def.Genres = append(def.Genres, str)
+	case field == "artist" && op == "is" && unmarshal(&str):
+		def.Artist = str
+	case field == "year" && op == "is" && unmarshal(&num):
+		def.YearFrom, def.YearTo = num, num
+	case field == "year" && op == "inTheRange" && unmarshal(&rng):
+		def.YearFrom, def.YearTo = rng[0], rng[1]
+	case field == "year" && op == "gt" && unmarshal(&num):
+		def.YearFrom = num + 1
+	case field == "year" && op == "lt" && unmarshal(&num):
+		def.YearTo = num - 1
+	case field == "rating" && op == "gt" && unmarshal(&num):
+		def.MinRating = num + 1
+	case field == "loved" && op == "is" && unmarshal(&boolean) && boolean:
+		def.Starred = true
+	case field == "playcount" && op == "gt" && unmarshal(&num):
+		def.MinPlays = num + 1
+	case field == "playcount" && op == "lt" && unmarshal(&num):
+		maxPlays := num - 1
+		def.MaxPlays = &maxPlays
+	case field == "playcount" && op == "is" && unmarshal(&num):
+		def.MinPlays, def.MaxPlays = num, &num
+	case field == "lastplayed" && op == "inTheLast" && unmarshal(&num):
+		def.PlayedDays = num
+	case field == "lastplayed" && op == "notInTheLast" && unmarshal(&num):
+		def.NotPlayedDays = num
+	case field == "dateadded" && op == "inTheLast" && unmarshal(&num):
+		def.AddedDays = num
+	case field == "filepath" && op == "startsWith" && unmarshal(&str):
+		def.PathGlob = escapeGlob(str) + "*"
+	case field == "filepath" && op == "contains" && unmarshal(&str):
+		def.PathGlob = "*" + escapeGlob(str) + "*"
+	default:
+		return fmt.Errorf("%w: %s %s %s", ErrUnsupported, field, op, value)
+	}
+	return nil
+}
+
+// escapeGlob makes the glob match the string literally. sqlite's GLOB has no escape
+// character, but a special character in brackets is literal
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("*?[", r) {
+			sb.WriteString("[" + string(r) + "]")
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
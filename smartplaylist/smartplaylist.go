@@ -0,0 +1,241 @@
+// Package smartplaylist finds the tracks of playlists defined by rules, like "4+ star rock
+// tracks from the 90s not played in 6 months", instead of by a list of tracks
+package smartplaylist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"go.senan.xyz/gonic/db"
+)
+
+var (
+	ErrInvalidRules = errors.New("invalid smart playlist rules")
+	ErrUnsupported  = errors.New("unsupported smart playlist rule")
+)
+
+// Rules that a track must match all of to be in the playlist. zero values match any
+// track. ratings, stars, and plays are those of the playlist's owner
+type Rules struct {
+	Genres        []string `json:"genres,omitempty"` // any of
+	Artist        string   `json:"artist,omitempty"` // the track artist, ignoring case
+	YearFrom      int      `json:"year_from,omitempty"`
+	YearTo        int      `json:"year_to,omitempty"`
+	MinRating     int      `json:"min_rating,omitempty"`
+	Starred       bool     `json:"starred,omitempty"`
+	MinPlays      int      `json:"min_plays,omitempty"`
+	MaxPlays      *int     `json:"max_plays,omitempty"`       // so that 0 can be never played
+	PlayedDays    int      `json:"played_days,omitempty"`     // played in the last days
+	NotPlayedDays int      `json:"not_played_days,omitempty"` // not played in the last days, or ever
+	AddedDays     int      `json:"added_days,omitempty"`      // added in the last days
+	PathGlob      string   `json:"path_glob,omitempty"`       // path in the music folder, eg. "jazz/*". see validateGlob
+	Sort          string   `json:"sort,omitempty"`            // one of Sorts, descending with a leading "-"
+	Limit         int      `json:"limit,omitempty"`
+}
+
+// Sorts are what tracks can be sorted by, besides the default of artist, album, and
+// track number
+var Sorts = map[string]string{
+	"random":      "random()",
+	"added":       "tracks.created_at",
+	"year":        "albums.tag_year",
+	"rating":      "track_ratings.rating",
+	"plays":       "plays.count",
+	"last_played": "plays.last",
+	"title":       "tracks.tag_title",
+	"artist":      "artists.name",
+}
+
+func (r *Rules) Validate() error {
+	switch {
+	case r.MinRating < 0 || r.MinRating > 5:
+		return fmt.Errorf("%w: rating must be between 1 and 5", ErrInvalidRules)
+	case r.YearFrom != 0 && r.YearTo != 0 && r.YearFrom > r.YearTo:
+		return fmt.Errorf("%w: year range is backwards", ErrInvalidRules)
+	case r.MinPlays < 0 || (r.MaxPlays != nil && *r.MaxPlays < r.MinPlays):
+		return fmt.Errorf("%w: play count range is backwards", ErrInvalidRules)
+	case r.PlayedDays < 0 || r.NotPlayedDays < 0 || r.AddedDays < 0 || r.Limit < 0:
+		return fmt.Errorf("%w: days and limit can't be negative", ErrInvalidRules)
+	}
+	if _, ok := Sorts[strings.TrimPrefix(r.Sort, "-")]; r.Sort != "" && !ok {
+		return fmt.Errorf("%w: unknown sort %q", ErrInvalidRules, r.Sort)
+	}
+	if err := validateGlob(r.PathGlob); err != nil {
+		return fmt.Errorf("%w: path glob: %v", ErrInvalidRules, err)
+	}
+	return nil
+}
+
+// validateGlob checks a glob for sqlite's GLOB, which matches it. it's like a shell glob,
+// except that "*" matches "/" too, "[^a-z]" is a negated set, and there's no escape
+// character, so a special character is matched literally in brackets, eg. "[*]"
+func validateGlob(glob string) error {
+	for i := 0; i < len(glob); i++ {
+		if glob[i] != '[' {
+			continue
+		}
+		j := i + 1
+		if j < len(glob) && glob[j] == '^' {
+			j++
+		}
+		if j < len(glob) && glob[j] == ']' {
+			j++ // a "]" first in the set is literal
+		}
+		end := strings.IndexByte(glob[j:], ']')
+		if end == -1 {
+			return errors.New("unterminated [")
+		}
+		i = j + end
+	}
+	return nil
+}
+
+// Definition is a smart playlist as uploaded, with its name
+type Definition struct {
+	Name    string `json:"name,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	Rules
+}
+
+// Parse reads a definition, either in gonic's format, the fields of Definition, or the
+// rules that gonic supports of navidrome's .nsp format
+func Parse(data []byte) (*Definition, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRules, err)
+	}
+	_, hasAll := probe["all"]
+	_, hasAny := probe["any"]
+	var def *Definition
+	if hasAll || hasAny {
+		var err error
+		if def, err = parseNSP(data); err != nil {
+			return nil, err
+		}
+	} else {
+		def = &Definition{}
+		if err := json.Unmarshal(data, def); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRules, err)
+		}
+	}
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// Marshal is how the rules are stored with the playlist
+func (r *Rules) Marshal() (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("marshal rules: %w", err)
+	}
+	return string(data), nil
+}
+
+func Unmarshal(rules string) (*Rules, error) {
+	var ret Rules
+	if err := json.Unmarshal([]byte(rules), &ret); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRules, err)
+	}
+	return &ret, nil
+}
+
+// TrackIDs finds the tracks that match the rules, with the stars, ratings, plays, and
+// music folders of the user who owns the playlist. the owner's music folders must be preloaded
+func TrackIDs(dbc *db.DB, owner *db.User, rules *Rules, now time.Time) ([]int, error) {
+	q := dbc.
+		Model(db.Track{}).
+		Select("tracks.id").
+		Joins("JOIN albums ON albums.id=tracks.album_id").
+		Joins("JOIN artists ON artists.id=tracks.artist_id").
+		Joins("LEFT JOIN track_ratings ON track_ratings.track_id=tracks.id AND track_ratings.user_id=?", owner.ID).
+		Joins("LEFT JOIN track_stars ON track_stars.track_id=tracks.id AND track_stars.user_id=?", owner.ID).
+		Joins(`LEFT JOIN (
+			SELECT track_id, count(*) AS count, max(time) AS last FROM track_plays WHERE user_id=? GROUP BY track_id
+		) plays ON plays.track_id=tracks.id`, owner.ID)
+
+	// before the limit, so that a restricted owner gets as many tracks as they asked for
+	if owner.IsRestricted() {
+		paths := make([]string, 0, len(owner.MusicFolders))
+		for _, folder := range owner.MusicFolders {
+			paths = append(paths, folder.Path)
+		}
+		q = q.Where("albums.root_dir IN (?)", paths)
+	}
+	if len(rules.Genres) > 0 {
+		q = q.Where(`tracks.id IN (
+			SELECT track_genres.track_id FROM track_genres
+			JOIN genres ON genres.id=track_genres.genre_id
+			WHERE lower(genres.name) IN (?)
+		)`, lower(rules.Genres))
+	}
+	if rules.Artist != "" {
+		q = q.Where("lower(artists.name)=?", strings.ToLower(rules.Artist))
+	}
+	if rules.YearFrom != 0 {
+		q = q.Where("albums.tag_year >= ?", rules.YearFrom)
+	}
+	if rules.YearTo != 0 {
+		q = q.Where("albums.tag_year <= ?", rules.YearTo)
+	}
+	if rules.MinRating != 0 {
+		q = q.Where("track_ratings.rating >= ?", rules.MinRating)
+	}
+	if rules.Starred {
+		q = q.Where("track_stars.track_id IS NOT NULL")
+	}
+	if rules.MinPlays != 0 {
+		q = q.Where("plays.count >= ?", rules.MinPlays)
+	}
+	if rules.MaxPlays != nil {
+		q = q.Where("coalesce(plays.count, 0) <= ?", *rules.MaxPlays)
+	}
+	if rules.PlayedDays != 0 {
+		q = q.Where("plays.last >= ?", daysAgo(now, rules.PlayedDays))
+	}
+	if rules.NotPlayedDays != 0 {
+		q = q.Where("plays.last IS NULL OR plays.last < ?", daysAgo(now, rules.NotPlayedDays))
+	}
+	if rules.AddedDays != 0 {
+		q = q.Where("tracks.created_at >= ?", daysAgo(now, rules.AddedDays))
+	}
+	if rules.PathGlob != "" {
+		q = q.Where("(albums.left_path || albums.right_path || '/' || tracks.filename) GLOB ?", rules.PathGlob)
+	}
+
+	if sort := strings.TrimPrefix(rules.Sort, "-"); sort != "" {
+		order := Sorts[sort]
+		if strings.HasPrefix(rules.Sort, "-") {
+			order += " DESC"
+		}
+		q = q.Order(gorm.Expr(order))
+	}
+	q = q.Order("artists.name, albums.tag_title, tracks.tag_disc_number, tracks.tag_track_number")
+	if rules.Limit != 0 {
+		q = q.Limit(rules.Limit)
+	}
+
+	var ids []int
+	if err := q.Pluck("tracks.id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("find tracks: %w", err)
+	}
+	return ids, nil
+}
+
+func daysAgo(now time.Time, days int) time.Time {
+	return now.AddDate(0, 0, -days)
+}
+
+func lower(in []string) []string {
+	ret := make([]string, 0, len(in))
+	for _, s := range in {
+		ret = append(ret, strings.ToLower(strings.TrimSpace(s)))
+	}
+	return ret
+}
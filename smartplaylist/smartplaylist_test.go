@@ -0,0 +1,173 @@
+package smartplaylist
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"go.senan.xyz/gonic/db"
+	"go.senan.xyz/gonic/mockfs"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	def, err := Parse([]byte(`{"name": "90s rock", "genres": ["rock"], "year_from": 1990, "year_to": 1999, "sort": "-rating", "limit": 10}`))
+	is.NoErr(err)
+	is.Equal(def.Name, "90s rock")
+	is.Equal(def.Genres, []string{"rock"})
+	is.Equal(def.YearFrom, 1990)
+	is.Equal(def.Sort, "-rating")
+	is.Equal(def.Limit, 10)
+
+	def, err = Parse([]byte(`{
+		"name": "80's top songs",
+		"all": [
+			{"any": [{"is": {"genre": "rock"}}, {"is": {"genre": "pop"}}]},
+			{"is": {"loved": true}},
+			{"inTheRange": {"year": [1981, 1990]}},
+			{"gt": {"rating": 3}},
+			{"notInTheLast": {"lastPlayed": 180}},
+			{"startsWith": {"filepath": "80s/"}}
+		],
+		"sort": "year",
+		"order": "desc",
+		"limit": 25
+	}`))
+	is.NoErr(err)
+	is.Equal(def.Name, "80's top songs")
+	is.Equal(def.Genres, []string{"rock", "pop"})
+	is.True(def.Starred)
+	is.Equal(def.YearFrom, 1981)
+	is.Equal(def.YearTo, 1990)
+	is.Equal(def.MinRating, 4)
+	is.Equal(def.NotPlayedDays, 180)
+	is.Equal(def.PathGlob, "80s/*")
+	is.Equal(def.Sort, "-year")
+	is.Equal(def.Limit, 25)
+
+	_, err = Parse([]byte(`{"all": [{"contains": {"comment": "live"}}]}`))
+	is.True(errors.Is(err, ErrUnsupported))
+	_, err = Parse([]byte(`{"year_from": 2000, "year_to": 1990}`))
+	is.True(errors.Is(err, ErrInvalidRules))
+	_, err = Parse([]byte(`{"sort": "colour"}`))
+	is.True(errors.Is(err, ErrInvalidRules))
+	_, err = Parse([]byte(`{"path_glob": "jazz/[a-z"}`))
+	is.True(errors.Is(err, ErrInvalidRules))
+	_, err = Parse([]byte(`{"path_glob": "jazz/[^]a-z]*"}`))
+	is.NoErr(err)
+	_, err = Parse([]byte(`not json`))
+	is.True(errors.Is(err, ErrInvalidRules))
+}
+
+func TestTrackIDs(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.New(t)
+	m.AddItems()
+	for al := 0; al < 3; al++ {
+		for tr := 0; tr < 3; tr++ {
+			m.SetTags(fmt.Sprintf("artist-0/album-%d/track-%d.flac", al, tr), func(tags *mockfs.Tags) error {
+				tags.RawGenre = "Rock"
+				return nil
+			})
+		}
+	}
+	m.ScanAndClean()
+	dbc := m.DB()
+	user := dbc.GetUserByID(1)
+
+	var tracks []*db.Track
+	is.NoErr(dbc.
+		Joins("JOIN albums ON albums.id=tracks.album_id").
+		Order("albums.left_path, albums.right_path, tracks.filename").
+		Find(&tracks).
+		Error)
+	is.Equal(len(tracks), 27)
+	now := time.Now()
+	is.NoErr(dbc.Create(&db.TrackStar{UserID: user.ID, TrackID: tracks[0].ID, StarDate: now}).Error)
+	is.NoErr(dbc.Create(&db.TrackRating{UserID: user.ID, TrackID: tracks[0].ID, Rating: 5}).Error)
+	is.NoErr(dbc.Create(&db.TrackRating{UserID: user.ID, TrackID: tracks[1].ID, Rating: 4}).Error)
+	is.NoErr(dbc.Create(&db.TrackRating{UserID: user.ID, TrackID: tracks[2].ID, Rating: 2}).Error)
+	for i := 0; i < 3; i++ {
+		is.NoErr(dbc.Create(&db.TrackPlay{UserID: user.ID, TrackID: tracks[1].ID, Time: now.AddDate(0, -1, 0)}).Error)
+	}
+	is.NoErr(dbc.Create(&db.TrackPlay{UserID: user.ID, TrackID: tracks[2].ID, Time: now.AddDate(-1, 0, 0)}).Error)
+
+	count := func(rules Rules) int {
+		ids, err := TrackIDs(dbc, user, &rules, now)
+		is.NoErr(err)
+		return len(ids)
+	}
+	zero := 0
+	is.Equal(count(Rules{}), 27)
+	is.Equal(count(Rules{Genres: []string{"rock"}}), 9)
+	is.Equal(count(Rules{Artist: "ARTIST-1"}), 9)
+	is.Equal(count(Rules{YearFrom: 2020, YearTo: 2021}), 27)
+	is.Equal(count(Rules{YearFrom: 1990, YearTo: 1999}), 0)
+	is.Equal(count(Rules{Starred: true}), 1)
+	is.Equal(count(Rules{MinRating: 4}), 2)
+	is.Equal(count(Rules{MinPlays: 2}), 1)
+	is.Equal(count(Rules{MaxPlays: &zero}), 25)
+	is.Equal(count(Rules{PlayedDays: 60}), 1)
+	is.Equal(count(Rules{NotPlayedDays: 180}), 26)
+	is.Equal(count(Rules{AddedDays: 1}), 27)
+	is.Equal(count(Rules{PathGlob: "artist-2/album-0/*"}), 3)
+	is.Equal(count(Rules{PathGlob: "artist-2/*"}), 9)             // "*" matches "/" too
+	is.Equal(count(Rules{PathGlob: "artist-[^01]/album-0/*"}), 3) // a negated set
+	is.Equal(count(Rules{Genres: []string{"rock"}, MinRating: 4, NotPlayedDays: 7}), 2)
+	is.Equal(count(Rules{Limit: 5}), 5)
+
+	ids, err := TrackIDs(dbc, user, &Rules{Sort: "-rating", Limit: 2}, now)
+	is.NoErr(err)
+	is.Equal(ids, []int{tracks[0].ID, tracks[1].ID})
+
+	// someone else's stars don't count
+	other := &db.User{ID: 2}
+	ids, err = TrackIDs(dbc, other, &Rules{Starred: true}, now)
+	is.NoErr(err)
+	is.Equal(len(ids), 0)
+}
+
+func TestTrackIDsMusicFolders(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	m := mockfs.NewWithDirs(t, []string{"m-0", "m-1"})
+	m.AddItemsPrefix("m-0")
+	m.AddItemsPrefix("m-1")
+	m.ScanAndClean()
+	dbc := m.DB()
+
+	allowed := filepath.Join(m.TmpDir(), "m-0")
+	kid := &db.User{Name: "kid", Password: "kid"}
+	is.NoErr(dbc.Create(kid).Error)
+	is.NoErr(dbc.SetUserMusicFolders(kid, []string{allowed}))
+	kid = dbc.GetUserByID(kid.ID)
+
+	ids, err := TrackIDs(dbc, kid, &Rules{}, time.Now())
+	is.NoErr(err)
+	is.Equal(len(ids), 27)
+
+	// the limit only counts tracks the owner can see
+	ids, err = TrackIDs(dbc, kid, &Rules{Sort: "-title", Limit: 5}, time.Now())
+	is.NoErr(err)
+	is.Equal(len(ids), 5)
+	var outside int
+	is.NoErr(dbc.
+		Model(db.Track{}).
+		Joins("JOIN albums ON albums.id=tracks.album_id").
+		Where("tracks.id IN (?) AND albums.root_dir!=?", ids, allowed).
+		Count(&outside).
+		Error)
+	is.Equal(outside, 0)
+
+	// admins see every folder
+	ids, err = TrackIDs(dbc, dbc.GetUserByID(1), &Rules{}, time.Now())
+	is.NoErr(err)
+	is.Equal(len(ids), 54)
+}
@@ -0,0 +1,64 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestCode(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	// the sha-1 vectors from RFC 6238, with the last 6 of their 8 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := Code(secret, Step(time.Unix(unix, 0)))
+		is.NoErr(err)
+		is.Equal(code, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+
+	secret, err := NewSecret()
+	is.NoErr(err)
+	now := time.Unix(1_700_000_000, 0)
+	code, err := Code(secret, Step(now))
+	is.NoErr(err)
+
+	step, ok := Validate(secret, code, now, 0)
+	is.True(ok)
+	is.Equal(step, Step(now))
+
+	// a step of drift either way is fine
+	_, ok = Validate(secret, code, now.Add(Period), 0)
+	is.True(ok)
+	_, ok = Validate(secret, code, now.Add(-Period), 0)
+	is.True(ok)
+	_, ok = Validate(secret, code, now.Add(2*Period), 0)
+	is.True(!ok)
+
+	// but not twice
+	_, ok = Validate(secret, code, now, step)
+	is.True(!ok)
+
+	_, ok = Validate(secret, "12345", now, 0)
+	is.True(!ok)
+}
+
+func TestURL(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	is.Equal(URL("gonic", "alice", "JBSWY3DPEHPK3PXP"), "otpauth://totp/gonic:alice?issuer=gonic&secret=JBSWY3DPEHPK3PXP")
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/google/shlex"
@@ -41,22 +42,50 @@ var (
 	Opus128       = NewProfile("audio/ogg", "opus", 128, `ffmpeg -v 0 -i <file> -ss <seek> -map 0:a:0 -vn -b:a <bitrate> -c:a libopus -vbr on -f opus -`)
 	Opus128RG     = NewProfile("audio/ogg", "opus", 128, `ffmpeg -v 0 -i <file> -ss <seek> -map 0:a:0 -vn -b:a <bitrate> -c:a libopus -vbr on -af "volume=replaygain=track:replaygain_preamp=6dB:replaygain_noclip=0, alimiter=level=disabled, asidedata=mode=delete:type=REPLAYGAIN" -metadata replaygain_album_gain= -metadata replaygain_album_peak= -metadata replaygain_track_gain= -metadata replaygain_track_peak= -metadata r128_album_gain= -metadata r128_track_gain= -f opus -`)
 	Opus128RGLoud = NewProfile("audio/ogg", "opus", 128, `ffmpeg -v 0 -i <file> -ss <seek> -map 0:a:0 -vn -b:a <bitrate> -c:a libopus -vbr on -af "aresample=96000:resampler=soxr, volume=replaygain=track:replaygain_preamp=15dB:replaygain_noclip=0, alimiter=level=disabled, asidedata=mode=delete:type=REPLAYGAIN" -metadata replaygain_album_gain= -metadata replaygain_album_peak= -metadata replaygain_track_gain= -metadata replaygain_track_peak= -metadata r128_album_gain= -metadata r128_track_gain= -f opus -`)
+
+	// lossless, for when only part of the file should be served as is
+	FLAC = NewProfile("audio/flac", "flac", 0, `ffmpeg -v 0 -i <file> -ss <seek> -map 0:a:0 -vn -c:a flac -f flac -`)
 )
 
+// copyFormats are the ffmpeg formats that can be copied to from a file with the extension,
+// without encoding it again
+var copyFormats = map[string]struct{ mime, format string }{
+	"flac": {"audio/flac", "flac"},
+	"mp3":  {"audio/mpeg", "mp3"},
+	"ogg":  {"audio/ogg", "ogg"},
+	"oga":  {"audio/ogg", "ogg"},
+	"opus": {"audio/ogg", "opus"},
+	"wv":   {"audio/x-wavpack", "wv"},
+	"wav":  {"audio/wav", "wav"},
+}
+
+// Copy is for serving part of a file as is, in the same format. formats that ffmpeg can't
+// write to a pipe, like mp4, are encoded as FLAC instead
+func Copy(suffix string) Profile {
+	suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+	copyFormat, ok := copyFormats[suffix]
+	if !ok {
+		return FLAC
+	}
+	return NewProfile(copyFormat.mime, suffix, 0, fmt.Sprintf(`ffmpeg -v 0 -i <file> -ss <seek> -map 0:a:0 -vn -c:a copy -f %s -`, copyFormat.format))
+}
+
 type BitRate uint // kilobits/s
 
 type Profile struct {
-	bitrate BitRate // the default bitrate, but the user can request a different one
-	seek    time.Duration
-	mime    string
-	suffix  string
-	exec    string
+	bitrate  BitRate // the default bitrate, but the user can request a different one
+	seek     time.Duration
+	duration time.Duration // 0 to transcode until the end of the file
+	mime     string
+	suffix   string
+	exec     string
 }
 
-func (p *Profile) BitRate() BitRate    { return p.bitrate }
-func (p *Profile) Seek() time.Duration { return p.seek }
-func (p *Profile) Suffix() string      { return p.suffix }
-func (p *Profile) MIME() string        { return p.mime }
+func (p *Profile) BitRate() BitRate        { return p.bitrate }
+func (p *Profile) Seek() time.Duration     { return p.seek }
+func (p *Profile) Duration() time.Duration { return p.duration }
+func (p *Profile) Suffix() string          { return p.suffix }
+func (p *Profile) MIME() string            { return p.mime }
 
 func NewProfile(mime string, suffix string, bitrate BitRate, exec string) Profile {
 	return Profile{mime: mime, suffix: suffix, bitrate: bitrate, exec: exec}
@@ -72,6 +101,11 @@ func WithSeek(p Profile, seek time.Duration) Profile {
 	return p
 }
 
+func WithDuration(p Profile, duration time.Duration) Profile {
+	p.duration = duration
+	return p
+}
+
 var ErrNoProfileParts = fmt.Errorf("not enough profile parts")
 
 func parseProfile(profile Profile, in string) (string, []string, error) {
@@ -94,6 +128,10 @@ func parseProfile(profile Profile, in string) (string, []string, error) {
 			args = append(args, in)
 		case "<seek>":
 			args = append(args, fmt.Sprintf("%dus", profile.Seek().Microseconds()))
+			// output options that limit where it ends go with the one that says where it starts
+			if profile.Duration() > 0 {
+				args = append(args, "-t", fmt.Sprintf("%dus", profile.Duration().Microseconds()))
+			}
 		case "<bitrate>":
 			args = append(args, fmt.Sprintf("%dk", profile.BitRate()))
 		default: